@@ -0,0 +1,177 @@
+package ksk
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// How often the number of access log lines left out is logged
+const accessLogReportInterval = time.Minute
+
+// Captures the status code and body size written by the wrapped handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Status as seen by the client, defaulting to 200 for empty responses
+func (r *statusRecorder) code() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// Decides which requests make it into the access log: all errors and slow
+// requests, and a sample of the rest
+type accessLogSampler struct {
+	rate float64
+	// Requests taking at least this long are always kept, 0 disables
+	slow time.Duration
+	// Lines left out since the last report
+	suppressed atomic.Uint64
+}
+
+func newAccessLogSampler(rate float64, slow time.Duration) *accessLogSampler {
+	return &accessLogSampler{rate: rate, slow: slow}
+}
+
+// Report whether a request that was answered with status after took is
+// logged, counting it if not. The sample is drawn from the request ID, so
+// a request is either kept or left out everywhere its ID is sampled.
+func (s *accessLogSampler) keep(requestID string, status int, took time.Duration) bool {
+	if status >= http.StatusBadRequest || s.isSlow(took) || s.rate >= 1 {
+		return true
+	}
+	if s.rate > 0 {
+		h := fnv.New64a()
+		h.Write([]byte(requestID))
+		// The top 53 bits as a float in [0, 1)
+		if float64(h.Sum64()>>11)/(1<<53) < s.rate {
+			return true
+		}
+	}
+	s.suppressed.Add(1)
+	return false
+}
+
+func (s *accessLogSampler) isSlow(took time.Duration) bool {
+	return s.slow > 0 && took >= s.slow
+}
+
+// Log how many lines were left out every interval, until ctx is done
+func (s *accessLogSampler) reportLoop(ctx context.Context, logger *slog.Logger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n := s.suppressed.Swap(0); n > 0 {
+				logger.Info("access log sampled",
+					slog.Uint64("suppressed", n),
+					slog.Float64("sample_rate", s.rate),
+					slog.Duration("interval", interval),
+				)
+			}
+		}
+	}
+}
+
+// Emit one structured log line per request the sampler keeps. Slow
+// requests are logged as warnings with slow set, server errors as errors.
+func withAccessLog(logger *slog.Logger, sampler *accessLogSampler, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r)
+
+		took := time.Since(start)
+		status := rec.code()
+		requestID := requestIDFrom(r.Context())
+		if !sampler.keep(requestID, status, took) {
+			return
+		}
+		slow := sampler.isSlow(took)
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case slow:
+			level = slog.LevelWarn
+		}
+		if !logger.Enabled(r.Context(), level) {
+			return
+		}
+
+		logger.LogAttrs(r.Context(), level, "request",
+			slog.String("request_id", requestID),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.Int64("bytes", rec.bytes),
+			slog.Float64("duration_ms", float64(took.Microseconds())/1000),
+			slog.String("client_ip", clientIP(r)),
+			slog.String("cache", rec.Header().Get("X-Cache")),
+			slog.Bool("slow", slow),
+		)
+	})
+}
+
+// NewLogger builds the JSON logger for the configured destination and
+// level, Config.LogOutput and Config.LogLevel
+func NewLogger(output, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+
+	var w io.Writer
+	switch strings.ToLower(output) {
+	case "", "stderr":
+		w = os.Stderr
+	case "stdout":
+		w = os.Stdout
+	default:
+		f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("open log output: %w", err)
+		}
+		w = f
+	}
+
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: lvl})), nil
+}
@@ -0,0 +1,153 @@
+package ksk
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Register an admin endpoint behind requireAdmin. Every admin and debug
+// route goes through here.
+func (s *Server) handleAdmin(pattern string, handler http.HandlerFunc) {
+	s.adminRoutes[pattern] = true
+	s.adminMux.HandleFunc(pattern, s.requireAdmin(handler))
+}
+
+// Require a client IP in Config.AdminAllow, answering 403 otherwise, and
+// the admin token as a bearer token or X-Admin-Token header, answering 401
+// otherwise. Every request is logged with its ID and client IP, rejected
+// ones as warnings.
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		attrs := []slog.Attr{
+			slog.String("request_id", requestIDFrom(r.Context())),
+			slog.String("client_ip", clientIP(r)),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("query", r.URL.RawQuery),
+		}
+		switch {
+		case !s.adminIPAllowed(r):
+			slog.LogAttrs(r.Context(), slog.LevelWarn, "admin request rejected", append(attrs, slog.String("reason", "ip_not_allowed"))...)
+			writeError(w, r, http.StatusForbidden, "forbidden", "Forbidden")
+		case !validAdminToken(r, s.cfg.AdminToken):
+			slog.LogAttrs(r.Context(), slog.LevelWarn, "admin request rejected", append(attrs, slog.String("reason", "invalid_token"))...)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, r, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		default:
+			slog.LogAttrs(r.Context(), slog.LevelInfo, "admin request", attrs...)
+			next(w, r)
+		}
+	}
+}
+
+// Report whether r passes both admin checks, for requests that admins may
+// make to public endpoints such as X-Cache-Bypass and ?raw=1
+func (s *Server) isAdmin(r *http.Request) bool {
+	return s.adminIPAllowed(r) && validAdminToken(r, s.cfg.AdminToken)
+}
+
+// Report whether the client IP, as resolved by withClientIP, is in the
+// admin allowlist; an empty one allows no client. Forwarding headers only
+// count when sent by a trusted proxy, so they cannot be spoofed.
+func (s *Server) adminIPAllowed(r *http.Request) bool {
+	addr, err := netip.ParseAddr(clientIP(r))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range s.cfg.AdminAllow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func validAdminToken(r *http.Request, token string) bool {
+	if token == "" {
+		return false
+	}
+	given := r.Header.Get("X-Admin-Token")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		given = bearer
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// POST /admin/cache/purge[?key=/event/123] drops one or all cache entries.
+// Keys are relative to the configured upstream base URL, such as
+// /event/123?lang=en for one language or /events?show_past=true#ics@"..."
+// for a derived view. ?prefix=/event/123 instead drops every variant
+// below a path: all languages, views and remembered failures.
+func (s *Server) purgeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	key, prefix := query.Get("key"), query.Get("prefix")
+	if key != "" && prefix != "" {
+		writeError(w, r, http.StatusBadRequest, "invalid_parameter", "Expected either key or prefix")
+		return
+	}
+	if key == "" && prefix == "" {
+		s.invalidateEventIndex()
+		writeJSON(w, http.StatusOK, map[string]any{"status": "ok", "purged": s.cache.Purge()})
+		return
+	}
+
+	if prefix != "" {
+		if !strings.HasPrefix(prefix, "/") {
+			prefix = "/" + prefix
+		}
+		purged := 0
+		for _, cached := range s.cachedUnder(prefix) {
+			if s.cache.Delete(cached) {
+				purged++
+			}
+			if cached == s.cfg.UpstreamURL+eventsPath {
+				s.invalidateEventIndex()
+			}
+		}
+		if purged == 0 {
+			writeError(w, r, http.StatusNotFound, "key_not_cached", "Nothing cached under prefix: "+prefix)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"status": "ok", "purged": purged, "prefix": prefix})
+		return
+	}
+
+	if !strings.HasPrefix(key, "/") {
+		key = "/" + key
+	}
+	full := s.cfg.UpstreamURL + key
+	if key == eventsPath {
+		s.invalidateEventIndex()
+	}
+	deleted := s.cache.Delete(full)
+	// Purging a body also forgets a remembered failure, so the next
+	// request tries again
+	if parseCacheKey(full).variant == "" && s.cache.Delete(failureKey(full)) {
+		deleted = true
+	}
+	if !deleted {
+		writeError(w, r, http.StatusNotFound, "key_not_cached", "Key not cached: "+key)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"status": "ok", "purged": 1, "key": key})
+}
+
+// GET /admin/cache/keys lists cached upstream URLs with size, age and expiry
+func (s *Server) keysHandler(w http.ResponseWriter, r *http.Request) {
+	if !isRead(r) {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"keys": s.cache.Keys(), "stats": s.cache.Stats()})
+}
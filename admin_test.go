@@ -0,0 +1,60 @@
+package ksk
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminAccess(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	prefixes := func(list ...string) []string { return list }
+	tests := []struct {
+		name    string
+		allow   []string
+		remote  string
+		token   string
+		forward map[string]string
+		status  int
+	}{
+		{"allowed", prefixes("127.0.0.1"), "127.0.0.1:1234", "secret", nil, http.StatusOK},
+		{"allowed range", prefixes("192.0.2.0/24"), "192.0.2.77:1234", "secret", nil, http.StatusOK},
+		{"no allowlist", nil, "127.0.0.1:1234", "secret", nil, http.StatusForbidden},
+		{"not allowed", prefixes("127.0.0.1"), "203.0.113.5:1234", "secret", nil, http.StatusForbidden},
+		{"wrong token", prefixes("127.0.0.1"), "127.0.0.1:1234", "guess", nil, http.StatusUnauthorized},
+		{"no token", prefixes("127.0.0.1"), "127.0.0.1:1234", "", nil, http.StatusUnauthorized},
+		{"spoofed forwarded for", prefixes("127.0.0.1"), "203.0.113.5:1234", "secret",
+			map[string]string{"X-Forwarded-For": "127.0.0.1"}, http.StatusForbidden},
+		{"spoofed real IP", prefixes("127.0.0.1"), "203.0.113.5:1234", "secret",
+			map[string]string{"X-Real-IP": "127.0.0.1"}, http.StatusForbidden},
+		{"through trusted proxy", prefixes("198.51.100.7"), "10.0.0.1:1234", "secret",
+			map[string]string{"X-Forwarded-For": "198.51.100.7"}, http.StatusOK},
+		{"spoofed through trusted proxy", prefixes("127.0.0.1"), "10.0.0.1:1234", "secret",
+			map[string]string{"X-Forwarded-For": "127.0.0.1, 203.0.113.5"}, http.StatusForbidden},
+		{"allowlisted proxy itself", prefixes("10.0.0.1"), "10.0.0.1:1234", "secret",
+			map[string]string{"X-Forwarded-For": "203.0.113.5"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestServer(t, upstream.URL, func(cfg *Config) {
+				cfg.AdminToken = "secret"
+				cfg.AdminAllow, _ = parseIPPrefixes("", tt.allow)
+				cfg.TrustedProxies, _ = parseIPPrefixes("", []string{"10.0.0.0/8"})
+			}).Handler()
+
+			r := httptest.NewRequest(http.MethodGet, "/admin/stats", nil)
+			r.RemoteAddr = tt.remote
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			for key, value := range tt.forward {
+				r.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package ksk
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Body of every error response:
+// {"error": {"code": "invalid_event_id", "message": "...", "status": 400}}
+type errorEnvelope struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	// Stable, machine-readable reason
+	Code string `json:"code"`
+	// Human-readable description
+	Message string `json:"message"`
+	Status  int    `json:"status"`
+	// Kind of upstream failure, such as timeout or dns, for upstream errors
+	Class string `json:"class,omitempty"`
+	// Lets users quote the request in reports
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Write an error envelope with the given status
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	writeJSON(w, status, errorEnvelope{Error: apiError{
+		Code:      code,
+		Message:   message,
+		Status:    status,
+		RequestID: requestIDFrom(r.Context()),
+	}})
+}
+
+// Report whether r is a GET or HEAD, the methods of all read endpoints
+func isRead(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead
+}
+
+// 405 for read endpoints
+func writeMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+	writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+}
+
+// Error codes of upstream failures, by the sentinel they match
+var upstreamErrorCodes = []struct {
+	err  error
+	code string
+}{
+	// Before errUpstreamUnavailable, which timeouts match as well
+	{errUpstreamTimeout, "upstream_timeout"},
+	{errUpstreamUnavailable, "upstream_unavailable"},
+	{errUpstreamStatus, "upstream_error"},
+	{errUpstreamRead, "upstream_read_failed"},
+	{errUpstreamInvalid, "upstream_invalid_response"},
+	{errUpstreamTooLarge, "upstream_response_too_large"},
+	{errUpstreamBusy, "upstream_busy"},
+}
+
+// 502 for an upstream fetch error, without internal details but with its
+// class, 504 if the upstream timed out, or 503 if the gateway had too many
+// upstream requests in flight to make one
+func writeUpstreamError(w http.ResponseWriter, r *http.Request, err error) {
+	code, sentinel := upstreamErrorCode(err)
+	if sentinel == errUpstreamBusy {
+		w.Header().Set("Retry-After", "1")
+		writeError(w, r, http.StatusServiceUnavailable, code, sentinel.Error())
+		return
+	}
+	status := http.StatusBadGateway
+	if sentinel == errUpstreamTimeout {
+		status = http.StatusGatewayTimeout
+	}
+	writeJSON(w, status, errorEnvelope{Error: apiError{
+		Code:      code,
+		Message:   sentinel.Error(),
+		Status:    status,
+		Class:     upstreamErrorClass(err),
+		RequestID: requestIDFrom(r.Context()),
+	}})
+}
+
+// Error code of an upstream failure and the sentinel it matches
+func upstreamErrorCode(err error) (string, error) {
+	for _, known := range upstreamErrorCodes {
+		if errors.Is(err, known.err) {
+			return known.code, known.err
+		}
+	}
+	return "upstream_unavailable", errUpstreamUnavailable
+}
+
+// Sentinel error for an upstream error code
+func upstreamErrorFromCode(code string) error {
+	for _, known := range upstreamErrorCodes {
+		if known.code == code {
+			return known.err
+		}
+	}
+	return errUpstreamUnavailable
+}
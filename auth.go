@@ -0,0 +1,77 @@
+package ksk
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Credentials attached to every upstream request. The value never reaches
+// logs or clients.
+type upstreamAuth struct {
+	header string
+	// Secrets file the value is reloaded from, empty if it came from the
+	// environment
+	file string
+
+	mu    sync.RWMutex
+	value string
+}
+
+// Auth for the configuration, nil if no header is configured
+func newUpstreamAuth(cfg Config) *upstreamAuth {
+	if cfg.UpstreamAuthHeader == "" {
+		return nil
+	}
+	return &upstreamAuth{
+		header: http.CanonicalHeaderKey(cfg.UpstreamAuthHeader),
+		file:   cfg.UpstreamAuthFile,
+		value:  cfg.UpstreamAuthValue,
+	}
+}
+
+func (a *upstreamAuth) apply(req *http.Request) {
+	if a == nil {
+		return
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	req.Header.Set(a.header, a.value)
+}
+
+// Re-read the value from the secrets file, keeping the current one if that
+// fails. Values from the environment cannot change and are left alone.
+func (a *upstreamAuth) reload() {
+	if a == nil || a.file == "" {
+		return
+	}
+	value, err := readSecretFile(a.file)
+	if err != nil {
+		log.Printf("Keeping the previous upstream credentials: %v", err)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.value = value
+	log.Printf("Reloaded upstream credentials from %s", a.file)
+}
+
+// Read a secret, ignoring surrounding whitespace such as a final newline
+func readSecretFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read secrets file: %w", err)
+	}
+	value := strings.TrimSpace(string(b))
+	if value == "" {
+		return "", fmt.Errorf("secrets file %s is empty", path)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return "", fmt.Errorf("secrets file %s must contain a single line", path)
+	}
+	return value, nil
+}
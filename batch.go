@@ -0,0 +1,136 @@
+package ksk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	// Most IDs one batch request may ask for
+	maxBatchIDs = 50
+	// Upstream fetches a batch request runs at once
+	batchWorkers = 6
+)
+
+// Body of /events/batch. Events maps every requested ID to its event, or
+// to null if it could not be served; errors says why for those IDs.
+type batchResponse struct {
+	Events map[string]json.RawMessage `json:"events"`
+	Errors map[string]batchError      `json:"errors"`
+}
+
+type batchError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Kind of upstream failure, as in error responses
+	Class string `json:"class,omitempty"`
+}
+
+// Handle /events/batch?ids=12,15,99, several event details in one request.
+// Every event is resolved through the cache like /event/{id}; unknown IDs
+// and upstream failures only affect their own entry.
+func (s *Server) batchHandler(policy cachePolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isRead(r) {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		ids, err := parseBatchIDs(r.URL.Query().Get("ids"))
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_parameter", err.Error())
+			return
+		}
+
+		res := batchResponse{
+			Events: make(map[string]json.RawMessage, len(ids)),
+			Errors: map[string]batchError{},
+		}
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		slots := make(chan struct{}, batchWorkers)
+		for _, id := range ids {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				slots <- struct{}{}
+				defer func() { <-slots }()
+
+				data, err := s.batchEvent(r, id, policy)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					res.Events[id] = json.RawMessage("null")
+					res.Errors[id] = batchErrorFor(err)
+					return
+				}
+				res.Events[id] = data
+			}()
+		}
+		wg.Wait()
+
+		if r.Context().Err() != nil {
+			s.writeCanceled(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	}
+}
+
+// Body of one event, from the events list index where possible
+func (s *Server) batchEvent(r *http.Request, id string, policy cachePolicy) (json.RawMessage, error) {
+	if !s.offline() && s.defaultLanguage(r) {
+		if entry, ok := s.indexedEvent(id); ok {
+			return entry.data, nil
+		}
+	}
+	entry, _, _, err := s.resolve(r.Context(), s.localize(r, s.cfg.UpstreamURL+"/event/"+id), policy, nil)
+	if err != nil {
+		return nil, err
+	}
+	return entry.data, nil
+}
+
+// Split a comma-separated ID list, dropping duplicates
+func parseBatchIDs(raw string) ([]string, error) {
+	if raw == "" {
+		return nil, fmt.Errorf("Missing ids, expected a comma-separated list of event ids")
+	}
+
+	parts := strings.Split(raw, ",")
+	if len(parts) > maxBatchIDs {
+		return nil, fmt.Errorf("At most %d ids per request", maxBatchIDs)
+	}
+
+	var ids []string
+	seen := map[string]bool{}
+	for _, id := range parts {
+		id = strings.TrimSpace(id)
+		if !idRegex.MatchString(id) {
+			return nil, fmt.Errorf("Invalid event id %q", id)
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Error entry for an event that could not be served, with the code its
+// own request would have had
+func batchErrorFor(err error) batchError {
+	if errors.Is(err, errUpstreamNotFound) {
+		return batchError{Code: "not_found", Message: "Not found"}
+	}
+	code, sentinel := upstreamErrorCode(err)
+	if sentinel == errUpstreamBusy {
+		return batchError{Code: code, Message: sentinel.Error()}
+	}
+	return batchError{Code: code, Message: sentinel.Error(), Class: upstreamErrorClass(err)}
+}
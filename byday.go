@@ -0,0 +1,120 @@
+package ksk
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Longest range /events/by-day covers in one request
+const maxByDayRange = 366
+
+// Event as listed in the by-day overview
+type dayEvent struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Start string `json:"start"`
+}
+
+// Handle /events/by-day?from=&to=, the events of each day in the range
+// for calendar grids. Days without events map to an empty array and
+// multi-day events appear under every day they span. The overview is
+// cached per range and rebuilt when the events list changes.
+func (s *Server) byDayHandler(policy cachePolicy) http.HandlerFunc {
+	upstream := s.cfg.UpstreamURL + eventsPath
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isRead(r) {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		from, to, err := parseDayRange(r)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_parameter", err.Error())
+			return
+		}
+
+		name := "by-day=" + from.Format(time.DateOnly) + ".." + to.Format(time.DateOnly)
+		s.serveDerived(w, r, upstream, policy, derivedView{name: name, build: func(source cacheEntry) ([]byte, string, error) {
+			events, err := decodeEvents(source.data)
+			if err != nil {
+				return nil, "", err
+			}
+			body, err := json.Marshal(eventsByDay(events, from, to))
+			return body, "", err
+		}})
+	}
+}
+
+// Parse the required, inclusive from and to dates as Berlin midnights
+func parseDayRange(r *http.Request) (from, to time.Time, err error) {
+	query := r.URL.Query()
+	var bounds [2]time.Time
+	for i, name := range []string{"from", "to"} {
+		v, err := dateParam(query, name)
+		if err != nil {
+			return from, to, err
+		}
+		if v == "" {
+			return from, to, fmt.Errorf("Missing %s date, expected YYYY-MM-DD", name)
+		}
+		bounds[i], _ = time.ParseInLocation(time.DateOnly, v, berlin)
+	}
+
+	from, to = bounds[0], bounds[1]
+	if to.Before(from) {
+		return from, to, fmt.Errorf("Invalid range, to is before from")
+	}
+	if to.After(from.AddDate(0, 0, maxByDayRange-1)) {
+		return from, to, fmt.Errorf("Range must not exceed %d days", maxByDayRange)
+	}
+	return from, to, nil
+}
+
+// Group events by the Berlin dates from from to to they take place on
+func eventsByDay(events []event, from, to time.Time) map[string][]dayEvent {
+	days := map[string][]dayEvent{}
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		days[d.Format(time.DateOnly)] = []dayEvent{}
+	}
+
+	for _, ev := range events {
+		if ev.Start.IsZero() {
+			continue
+		}
+
+		start := ev.Start.In(berlin)
+		last := start
+		if !ev.End.IsZero() && ev.End.After(ev.Start) {
+			last = ev.End.In(berlin)
+			// An event ending at midnight does not take place that day
+			if !ev.AllDay && last.Equal(midnight(last)) {
+				last = last.Add(-time.Nanosecond)
+			}
+		}
+
+		item := dayEvent{ID: ev.ID, Title: ev.Title, Start: start.Format(time.RFC3339)}
+		if ev.AllDay {
+			item.Start = start.Format(time.DateOnly)
+		}
+
+		// Only walk the part of the event inside the range
+		first := midnight(start)
+		if first.Before(from) {
+			first = from
+		}
+		for d := first; !d.After(last) && !d.After(to); d = d.AddDate(0, 0, 1) {
+			key := d.Format(time.DateOnly)
+			days[key] = append(days[key], item)
+		}
+	}
+	return days
+}
+
+// Start of t's day in its location
+func midnight(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,71 @@
+package ksk
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Request header with which admin token holders skip the cache
+const cacheBypassHeader = "X-Cache-Bypass"
+
+// Report whether r skips the cache: it sends X-Cache-Bypass: 1 and the
+// admin token from an allowed IP. Otherwise the header is ignored rather
+// than rejected, so it cannot be used to bust the cache.
+func (s *Server) bypasses(r *http.Request) bool {
+	return r.Header.Get(cacheBypassHeader) == "1" && s.isAdmin(r)
+}
+
+// Answer a bypassing request with what the upstream returns now. With
+// CacheBypassStore the result replaces the cached entry, coalescing with
+// concurrent misses; otherwise the cache is left untouched. Either way the
+// response must not be cached downstream.
+func (s *Server) serveBypass(w http.ResponseWriter, r *http.Request, upstream string, policy cachePolicy) {
+	if !s.cfg.CacheBypassStore || s.offline() {
+		policy.uncached = true
+		s.serveUncached(w, r, upstream, policy)
+		return
+	}
+
+	res, _, err := s.fetches.do(r.Context(), upstream, func() (fetchResult, error) {
+		return s.fetchUpstream(r.Context(), upstream, policy, nil)
+	})
+	if !s.writeUncachedError(w, r, err) {
+		return
+	}
+	res.entry.private = true
+	s.writeResolved(w, r, res.entry, "BYPASS")
+}
+
+// Fetch upstream for this request only, without looking at or filling the
+// cache, and answer with the result
+func (s *Server) serveUncached(w http.ResponseWriter, r *http.Request, upstream string, policy cachePolicy) {
+	var entry cacheEntry
+	var err error
+	if s.offline() {
+		entry, err = s.loadFixture(upstream, policy)
+		entry.private = true
+	} else if err = s.fetchLimit.acquire(r.Context()); err == nil {
+		entry, err = s.fetchFailover(r.Context(), upstream, policy, nil)
+		s.fetchLimit.release()
+	}
+	if !s.writeUncachedError(w, r, err) {
+		return
+	}
+	s.writeResolved(w, r, entry, "BYPASS")
+}
+
+// Answer a failed uncached fetch, reporting false if a response has been
+// written
+func (s *Server) writeUncachedError(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case r.Context().Err() != nil:
+		s.writeCanceled(w, r)
+	case errors.Is(err, errUpstreamNotFound):
+		writeNotFound(w, r)
+	case err != nil:
+		writeUpstreamError(w, r, err)
+	default:
+		return true
+	}
+	return false
+}
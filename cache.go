@@ -0,0 +1,413 @@
+package ksk
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Version of the stored representation of cache entries, including the
+// transformations applied to bodies before caching, such as stripping,
+// sanitization and time normalization. Bump it whenever they change, so a
+// new binary misses on entries the previous one left in Redis or a
+// snapshot instead of serving them.
+const cacheSchemaVersion = 1
+
+// Prefix that keeps the keys of each schema version apart in a shared
+// store
+func cacheNamespace() string {
+	return "v" + strconv.Itoa(cacheSchemaVersion) + ":"
+}
+
+// Store of upstream responses keyed by upstream URL, in memory or in
+// Redis. Implementations must be safe for concurrent use. Errors talking
+// to a remote store are handled internally and reported as misses, so a
+// broken cache degrades to fetching from the upstream instead of failing
+// requests.
+type cacheStore interface {
+	// Get returns the entry for key, including expired entries that are
+	// still retained so they can be served stale
+	Get(key string) (cacheEntry, bool)
+	// Set stores entry and keeps it for at most retain
+	Set(key string, entry cacheEntry, retain time.Duration)
+	// Delete removes an entry and reports whether it existed
+	Delete(key string) bool
+	// Purge removes all entries and returns how many there were
+	Purge() int
+	// Keys describes the stored entries
+	Keys() []cacheKeyInfo
+	Stats() cacheStats
+}
+
+type cacheEntry struct {
+	data []byte
+	// gzip-compressed copy of data, nil for small bodies
+	gzipped []byte
+	// When the entry was filled and until when it is fresh
+	stored time.Time
+	until  time.Time
+	// Strong validator derived from data
+	etag string
+	// Validators sent by the upstream, used to revalidate on refresh
+	upstreamETag string
+	lastModified string
+	// Negative entry: the upstream reported the resource as missing
+	notFound bool
+	// Negative entry: error code and class of a failed fetch, stored
+	// under failureKey so it never replaces a stale copy
+	failure      string
+	failureClass string
+	// Content-Type of data as the upstream sent it or a view built it,
+	// empty for JSON without one
+	contentType string
+	// Content-Language the upstream sent, if any
+	contentLanguage string
+	// Base URL of the upstream or mirror that served data
+	source string
+	// Uncached answer for one client, such as ?raw=1, that shared caches
+	// must not store either
+	private bool
+}
+
+// Memory used by the entry's bodies
+func (e cacheEntry) size() int64 {
+	return int64(len(e.data) + len(e.gzipped))
+}
+
+// Serialized form of a cacheEntry for stores outside the process
+type storedEntry struct {
+	Data            []byte    `json:"data,omitempty"`
+	Gzipped         []byte    `json:"gzipped,omitempty"`
+	Stored          time.Time `json:"stored"`
+	Until           time.Time `json:"until"`
+	ETag            string    `json:"etag,omitempty"`
+	UpstreamETag    string    `json:"upstream_etag,omitempty"`
+	LastModified    string    `json:"last_modified,omitempty"`
+	NotFound        bool      `json:"not_found,omitempty"`
+	Failure         string    `json:"failure,omitempty"`
+	FailureClass    string    `json:"failure_class,omitempty"`
+	ContentType     string    `json:"content_type,omitempty"`
+	ContentLanguage string    `json:"content_language,omitempty"`
+	Source          string    `json:"source,omitempty"`
+}
+
+func newStoredEntry(e cacheEntry) storedEntry {
+	return storedEntry{
+		Data:            e.data,
+		Gzipped:         e.gzipped,
+		Stored:          e.stored,
+		Until:           e.until,
+		ETag:            e.etag,
+		UpstreamETag:    e.upstreamETag,
+		LastModified:    e.lastModified,
+		NotFound:        e.notFound,
+		Failure:         e.failure,
+		FailureClass:    e.failureClass,
+		ContentType:     e.contentType,
+		ContentLanguage: e.contentLanguage,
+		Source:          e.source,
+	}
+}
+
+func (se storedEntry) entry() cacheEntry {
+	return cacheEntry{
+		data:            se.Data,
+		gzipped:         se.Gzipped,
+		stored:          se.Stored,
+		until:           se.Until,
+		etag:            se.ETag,
+		upstreamETag:    se.UpstreamETag,
+		lastModified:    se.LastModified,
+		notFound:        se.NotFound,
+		failure:         se.Failure,
+		failureClass:    se.FailureClass,
+		contentType:     se.ContentType,
+		contentLanguage: se.ContentLanguage,
+		source:          se.Source,
+	}
+}
+
+func marshalEntry(e cacheEntry) ([]byte, error) {
+	return json.Marshal(newStoredEntry(e))
+}
+
+func unmarshalEntry(b []byte) (cacheEntry, error) {
+	var se storedEntry
+	if err := json.Unmarshal(b, &se); err != nil {
+		return cacheEntry{}, err
+	}
+	return se.entry(), nil
+}
+
+// Strong ETag for a body: a quoted, truncated SHA-256 of its content
+func computeETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// Snapshot of a cached entry for inspection
+type cacheKeyInfo struct {
+	Key string `json:"key"`
+	// Language and variant the key holds, if not the default language and
+	// the upstream body
+	Language    string    `json:"language,omitempty"`
+	Variant     string    `json:"variant,omitempty"`
+	Size        int64     `json:"size"`
+	Age         string    `json:"age"`
+	Expires     time.Time `json:"expires"`
+	Expired     bool      `json:"expired"`
+	NotFound    bool      `json:"not_found,omitempty"`
+	Failure     string    `json:"failure,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+}
+
+func newKeyInfo(key string, entry cacheEntry, now time.Time) cacheKeyInfo {
+	parsed := parseCacheKey(key)
+	return cacheKeyInfo{
+		Key:         key,
+		Language:    parsed.lang,
+		Variant:     parsed.variant,
+		Size:        entry.size(),
+		Age:         now.Sub(entry.stored).Round(time.Second).String(),
+		Expires:     entry.until.UTC(),
+		Expired:     !now.Before(entry.until),
+		NotFound:    entry.notFound,
+		Failure:     entry.failure,
+		ContentType: entry.contentType,
+	}
+}
+
+type cacheStats struct {
+	Entries   int    `json:"entries"`
+	Bytes     int64  `json:"bytes"`
+	Evictions uint64 `json:"evictions"`
+	Expired   uint64 `json:"expired"`
+}
+
+// Bounded in-memory cache that evicts approximately least recently used
+// entries once either the entry count or the total body size exceeds its
+// limits. Hits only take the read lock: instead of reordering the list they
+// mark the entry as used, and eviction gives marked entries a second
+// chance (the CLOCK algorithm). Concurrent hits on the same hot key thus
+// never wait for each other.
+type lruCache struct {
+	mu         sync.RWMutex
+	items      map[string]*list.Element
+	order      *list.List // front is most recently inserted or spared
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+	evictions  uint64
+	// Entries the sweep removed after their retention
+	expired uint64
+}
+
+type lruItem struct {
+	key   string
+	entry cacheEntry
+	// Past this point the entry is dropped by the sweep
+	expires time.Time
+	// Read since eviction last looked at the entry
+	used atomic.Bool
+}
+
+func newLRUCache(maxEntries int, maxBytes int64) *lruCache {
+	return &lruCache{
+		items:      map[string]*list.Element{},
+		order:      list.New(),
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+	}
+}
+
+// Get looks up an entry and marks it as recently used
+func (c *lruCache) Get(key string) (cacheEntry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return cacheEntry{}, false
+	}
+	// Items are replaced, never modified, so reading one is safe here
+	item := el.Value.(*lruItem)
+	if time.Now().After(item.expires) {
+		return cacheEntry{}, false
+	}
+	if !item.used.Load() {
+		item.used.Store(true)
+	}
+	return item.entry, true
+}
+
+// Set inserts or replaces an entry, evicting old entries to stay within
+// limits. Bodies larger than the whole byte budget are not cached.
+func (c *lruCache) Set(key string, entry cacheEntry, retain time.Duration) {
+	size := entry.size()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return
+	}
+
+	item := &lruItem{key: key, entry: entry, expires: time.Now().Add(retain)}
+	// New entries survive the next eviction round, as if just read
+	item.used.Store(true)
+	c.items[key] = c.order.PushFront(item)
+	c.bytes += size
+
+	for c.overLimit() {
+		c.evictOne()
+	}
+}
+
+// Evict the oldest entry that has not been read since it was last spared,
+// moving read ones to the front
+func (c *lruCache) evictOne() {
+	for {
+		el := c.order.Back()
+		if el.Value.(*lruItem).used.Swap(false) {
+			c.order.MoveToFront(el)
+			continue
+		}
+		c.removeElement(el)
+		c.evictions++
+		return
+	}
+}
+
+func (c *lruCache) overLimit() bool {
+	if c.order.Len() == 0 {
+		return false
+	}
+	return (c.maxEntries > 0 && c.order.Len() > c.maxEntries) ||
+		(c.maxBytes > 0 && c.bytes > c.maxBytes)
+}
+
+func (c *lruCache) removeElement(el *list.Element) {
+	item := c.order.Remove(el).(*lruItem)
+	delete(c.items, item.key)
+	c.bytes -= item.entry.size()
+}
+
+func (c *lruCache) Delete(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if ok {
+		c.removeElement(el)
+	}
+	return ok
+}
+
+func (c *lruCache) Purge() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := c.order.Len()
+	c.items = map[string]*list.Element{}
+	c.order.Init()
+	c.bytes = 0
+	return n
+}
+
+// Keys lists all entries, roughly most recently used first
+func (c *lruCache) Keys() []cacheKeyInfo {
+	now := time.Now()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	infos := make([]cacheKeyInfo, 0, c.order.Len())
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		item := el.Value.(*lruItem)
+		infos = append(infos, newKeyInfo(item.key, item.entry, now))
+	}
+	return infos
+}
+
+func (c *lruCache) Stats() cacheStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return cacheStats{
+		Entries:   c.order.Len(),
+		Bytes:     c.bytes,
+		Evictions: c.evictions,
+		Expired:   c.expired,
+	}
+}
+
+// Entries the sweep removes per write lock, so requests are not held up
+// for a whole pass over a large cache
+const sweepBatch = 256
+
+// Remove entries past their retention and return how many. Expired keys
+// are collected under the read lock and removed in batches, each checked
+// again in case it was refreshed in between.
+func (c *lruCache) sweep() int {
+	now := time.Now()
+
+	c.mu.RLock()
+	var expired []string
+	for el := c.order.Back(); el != nil; el = el.Prev() {
+		if item := el.Value.(*lruItem); now.After(item.expires) {
+			expired = append(expired, item.key)
+		}
+	}
+	c.mu.RUnlock()
+
+	removed := 0
+	for len(expired) > 0 {
+		batch := expired[:min(sweepBatch, len(expired))]
+		expired = expired[len(batch):]
+
+		c.mu.Lock()
+		for _, key := range batch {
+			if el, ok := c.items[key]; ok && now.After(el.Value.(*lruItem).expires) {
+				c.removeElement(el)
+				c.expired++
+				removed++
+			}
+		}
+		c.mu.Unlock()
+	}
+	return removed
+}
+
+// Periodically drop entries that are past their retention and log
+// evictions since the previous sweep
+func (c *lruCache) sweepLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastEvictions uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		removed := c.sweep()
+		st := c.Stats()
+		if removed > 0 || st.Evictions != lastEvictions {
+			log.Printf("Cache sweep removed %d expired entries, %d evicted since last sweep (%d entries, %d bytes cached)",
+				removed, st.Evictions-lastEvictions, st.Entries, st.Bytes)
+		}
+		lastEvictions = st.Evictions
+	}
+}
@@ -0,0 +1,126 @@
+package ksk
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLRUCacheSecondChance(t *testing.T) {
+	c := newLRUCache(3, 0)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		c.Set(key, cacheEntry{data: []byte(key)}, time.Minute)
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("oldest entry a not evicted")
+	}
+
+	// Read since the last eviction, so spared in favour of c
+	c.Get("b")
+	c.Set("e", cacheEntry{data: []byte("e")}, time.Minute)
+	for key, want := range map[string]bool{"b": true, "c": false, "d": true, "e": true} {
+		if _, ok := c.Get(key); ok != want {
+			t.Errorf("%s cached: %v, want %v", key, ok, want)
+		}
+	}
+	if st := c.Stats(); st.Entries != 3 || st.Evictions != 2 {
+		t.Errorf("stats %+v, want 3 entries and 2 evictions", st)
+	}
+}
+
+func TestLRUCacheExpiryAndOverwrite(t *testing.T) {
+	c := newLRUCache(0, 0)
+	c.Set("k", cacheEntry{data: []byte("old")}, time.Minute)
+	c.Set("k", cacheEntry{data: []byte("new")}, time.Minute)
+	if entry, _ := c.Get("k"); string(entry.data) != "new" {
+		t.Errorf("got %q after refill, want new", entry.data)
+	}
+	if st := c.Stats(); st.Entries != 1 || st.Bytes != (cacheEntry{data: []byte("new")}).size() {
+		t.Errorf("stats %+v after refill", st)
+	}
+
+	c.Set("gone", cacheEntry{data: []byte("x")}, -time.Second)
+	if _, ok := c.Get("gone"); ok {
+		t.Error("entry past its retention returned")
+	}
+	if n := c.sweep(); n != 1 {
+		t.Errorf("sweep removed %d entries, want 1", n)
+	}
+}
+
+// Hits, fills, deletes, sweeps and evictions at once, for the race
+// detector and the byte accounting
+func TestLRUCacheConcurrent(t *testing.T) {
+	c := newLRUCache(20, 1<<10)
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 2000 {
+				key := fmt.Sprintf("k%d", (g*7+i)%50)
+				switch i % 10 {
+				case 0:
+					c.Set(key, cacheEntry{data: make([]byte, i%100)}, time.Minute)
+				case 1:
+					c.Delete(key)
+				case 2:
+					c.Set(key, cacheEntry{data: []byte(key)}, -time.Second)
+				case 3:
+					c.sweep()
+				case 4:
+					c.Keys()
+					c.Stats()
+				default:
+					c.Get(key)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var bytes int64
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		bytes += el.Value.(*lruItem).entry.size()
+	}
+	if bytes != c.bytes || c.order.Len() != len(c.items) {
+		t.Errorf("accounting off: %d bytes in %d entries, tracked %d bytes in %d", bytes, c.order.Len(), c.bytes, len(c.items))
+	}
+	if c.order.Len() > 20 || c.bytes > 1<<10 {
+		t.Errorf("over the limits with %d entries of %d bytes", c.order.Len(), c.bytes)
+	}
+}
+
+func BenchmarkLRUCacheGet(b *testing.B) {
+	c := newLRUCache(defaultCacheMaxEntries, defaultCacheMaxBytes)
+	c.Set("hot", cacheEntry{data: []byte(testEvents)}, time.Hour)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.Get("hot")
+		}
+	})
+}
+
+func BenchmarkServeCachedHit(b *testing.B) {
+	upstream := newFakeUpstream(b)
+	h := newTestServer(b, upstream.URL, func(cfg *Config) {
+		cfg.AccessLogSampleRate = 0
+	}).Handler()
+	if w := serve(h, http.MethodGet, "/api/v1/genres", nil); w.Code != http.StatusOK {
+		b.Fatalf("status %d", w.Code)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if w := serve(h, http.MethodGet, "/api/v1/genres", nil); w.Header().Get("X-Cache") != "HIT" {
+				b.Fatalf("X-Cache %q", w.Header().Get("X-Cache"))
+			}
+		}
+	})
+}
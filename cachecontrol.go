@@ -0,0 +1,95 @@
+package ksk
+
+import (
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long an upstream response may be cached according to its
+// Cache-Control and Expires headers. Hints are clamped to the configured
+// bounds and fallback applies when there are none. store is false for
+// no-store responses; no-cache ones are stored but already expired, so
+// every request revalidates them.
+func (s *Server) upstreamTTL(h http.Header, fallback time.Duration) (ttl time.Duration, store bool) {
+	directives := cacheDirectives(h.Get("Cache-Control"))
+	if _, ok := directives["no-store"]; ok {
+		return 0, false
+	}
+	if _, ok := directives["no-cache"]; ok {
+		return 0, true
+	}
+
+	ttl, ok := freshnessLifetime(h, directives)
+	if !ok {
+		return fallback, true
+	}
+	if age, err := strconv.Atoi(h.Get("Age")); err == nil && age > 0 {
+		ttl -= time.Duration(age) * time.Second
+	}
+	live := s.live.Load()
+	return min(max(ttl, live.ttlMin), live.ttlMax), true
+}
+
+// Lifetime from s-maxage, max-age or Expires, in that order of precedence
+func freshnessLifetime(h http.Header, directives map[string]string) (time.Duration, bool) {
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if v, ok := directives[name]; ok {
+			if secs, err := strconv.ParseInt(v, 10, 64); err == nil && secs >= 0 {
+				return time.Duration(min(secs, math.MaxInt32)) * time.Second, true
+			}
+		}
+	}
+
+	expires := h.Get("Expires")
+	if expires == "" {
+		return 0, false
+	}
+	at, err := http.ParseTime(expires)
+	if err != nil {
+		// Invalid dates such as "0" mean already expired (RFC 9111 5.3)
+		return 0, true
+	}
+	// Relative to the upstream's clock, which may differ from ours
+	now := time.Now()
+	if date, err := http.ParseTime(h.Get("Date")); err == nil {
+		now = date
+	}
+	return max(at.Sub(now), 0), true
+}
+
+// Parse a Cache-Control header into lower-case directive names and their
+// unquoted values
+func cacheDirectives(header string) map[string]string {
+	directives := map[string]string{}
+	for _, part := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name == "" {
+			continue
+		}
+		directives[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return directives
+}
+
+// Spread ttl randomly by the configured fraction either way, so entries
+// filled together do not all expire together
+func (s *Server) jitterTTL(ttl time.Duration) time.Duration {
+	spread := time.Duration(float64(ttl) * s.live.Load().ttlJitter)
+	if spread <= 0 {
+		return ttl
+	}
+	return ttl - spread + rand.N(2*spread+1)
+}
+
+// Default TTL of a policy's responses, the endpoint's current setting for
+// built-in endpoints
+func (s *Server) policyTTL(policy cachePolicy) time.Duration {
+	if policy.endpoint != "" {
+		return s.live.Load().ttls[policy.endpoint]
+	}
+	return policy.ttl
+}
@@ -0,0 +1,54 @@
+package ksk
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestJitterTTLBand(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	ttl := 10 * time.Minute
+	s := newTestServer(t, upstream.URL, func(cfg *Config) {
+		cfg.TTLJitter = 0.1
+	})
+
+	seen := map[time.Duration]bool{}
+	for range 1000 {
+		d := s.jitterTTL(ttl)
+		if d < 9*time.Minute || d > 11*time.Minute {
+			t.Fatalf("jittered TTL %s outside 9m to 11m", d)
+		}
+		seen[d] = true
+	}
+	if len(seen) < 2 {
+		t.Error("TTL not jittered")
+	}
+
+	s = newTestServer(t, upstream.URL, func(cfg *Config) {
+		cfg.TTLJitter = 0
+	})
+	if d := s.jitterTTL(ttl); d != ttl {
+		t.Errorf("TTL %s without jitter, want %s", d, ttl)
+	}
+}
+
+func TestCachedExpiryWithinJitterBand(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	s := newTestServer(t, upstream.URL, func(cfg *Config) {
+		cfg.TTLs["genres"] = 10 * time.Minute
+		cfg.TTLJitter = 0.2
+	})
+	h := s.Handler()
+
+	if w := serve(h, http.MethodGet, "/api/v1/genres", nil); w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", w.Code)
+	}
+	entry, ok := s.cache.Get(upstream.URL + genresPath)
+	if !ok {
+		t.Fatal("not cached")
+	}
+	if ttl := entry.until.Sub(entry.stored); ttl < 8*time.Minute || ttl > 12*time.Minute {
+		t.Errorf("entry kept for %s, want 8m to 12m", ttl)
+	}
+}
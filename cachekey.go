@@ -0,0 +1,87 @@
+package ksk
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Variant of the negative entry that remembers a failed fetch
+const failedVariant = "failed"
+
+// Key of a cache entry: an upstream resource in one language, and which
+// variant of it the entry holds. Its string form is the upstream URL with
+// the language as a trailing lang parameter and the variant as fragment,
+// such as https://host/api/events?show_past=true&lang=en#ics@"etag", which
+// is also the URL the body is fetched from.
+type cacheKey struct {
+	// Upstream URL with its normalized query, without the language
+	upstream string
+	// Language other than the default, empty for the default
+	lang string
+	// Representation built from or recorded about the upstream body, such
+	// as a derived view with the body's ETag or failedVariant; empty for
+	// the body itself
+	variant string
+}
+
+// Parse the string form of a key
+func parseCacheKey(s string) cacheKey {
+	s, variant, _ := strings.Cut(s, "#")
+	k := cacheKey{upstream: s, variant: variant}
+	base, query, ok := strings.Cut(s, "?")
+	if !ok {
+		return k
+	}
+	// localize appends the language as the last parameter
+	i := strings.LastIndex(query, "lang=")
+	if i < 0 || (i > 0 && query[i-1] != '&') || strings.Contains(query[i:], "&") {
+		return k
+	}
+	lang, err := url.QueryUnescape(query[i+len("lang="):])
+	if err != nil {
+		return k
+	}
+	k.lang = lang
+	k.upstream = base
+	if query = strings.TrimSuffix(query[:i], "&"); query != "" {
+		k.upstream += "?" + query
+	}
+	return k
+}
+
+func (k cacheKey) String() string {
+	s := k.upstream
+	if k.lang != "" {
+		sep := "?"
+		if strings.Contains(s, "?") {
+			sep = "&"
+		}
+		s += sep + "lang=" + url.QueryEscape(k.lang)
+	}
+	if k.variant != "" {
+		s += "#" + k.variant
+	}
+	return s
+}
+
+// The key of another variant of the same upstream body
+func (k cacheKey) withVariant(variant string) cacheKey {
+	k.variant = variant
+	return k
+}
+
+// Report whether the key, relative to base, starts with prefix at a
+// boundary: the end of a path segment, query parameter or the whole key.
+// A prefix of /event/12 thus covers /event/12?lang=en and /event/12#failed
+// but not /event/123.
+func keyHasPrefix(key, base, prefix string) bool {
+	rel, ok := strings.CutPrefix(key, base)
+	if !ok {
+		return false
+	}
+	rest, ok := strings.CutPrefix(rel, prefix)
+	if !ok {
+		return false
+	}
+	return rest == "" || strings.HasSuffix(prefix, "/") || strings.ContainsAny(rest[:1], "?&#/")
+}
@@ -0,0 +1,123 @@
+package ksk
+
+import (
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestCacheKeyRoundTrip(t *testing.T) {
+	tests := []struct {
+		key  cacheKey
+		text string
+	}{
+		{cacheKey{upstream: "http://up/genres"}, "http://up/genres"},
+		{cacheKey{upstream: "http://up/genres", lang: "en"}, "http://up/genres?lang=en"},
+		{cacheKey{upstream: "http://up/events?show_past=true", lang: "en"}, "http://up/events?show_past=true&lang=en"},
+		{cacheKey{upstream: "http://up/events?show_past=true", variant: `ics@"abc"`}, `http://up/events?show_past=true#ics@"abc"`},
+		{cacheKey{upstream: "http://up/events?show_past=true", lang: "en", variant: `filter@"abc"`}, `http://up/events?show_past=true&lang=en#filter@"abc"`},
+		{cacheKey{upstream: "http://up/event/1", variant: failedVariant}, "http://up/event/1#failed"},
+	}
+	for _, tt := range tests {
+		if got := tt.key.String(); got != tt.text {
+			t.Errorf("%+v: string %q, want %q", tt.key, got, tt.text)
+		}
+		if got := parseCacheKey(tt.text); got != tt.key {
+			t.Errorf("%q: parsed %+v, want %+v", tt.text, got, tt.key)
+		}
+	}
+	// A lang parameter that localize did not append stays in the URL
+	if k := parseCacheKey("http://up/search?lang=en&q=x"); k.lang != "" {
+		t.Errorf("lang %q taken from the middle of the query", k.lang)
+	}
+}
+
+func TestKeyHasPrefix(t *testing.T) {
+	const base = "http://up"
+	tests := []struct {
+		key, prefix string
+		want        bool
+	}{
+		{"http://up/event/12", "/event/12", true},
+		{"http://up/event/12?lang=en", "/event/12", true},
+		{"http://up/event/12#failed", "/event/12", true},
+		{"http://up/event/12/accessibility", "/event/12", true},
+		{"http://up/event/123", "/event/12", false},
+		{"http://up/event/123", "/event/", true},
+		{"http://up/events?show_past=true", "/event", false},
+		{"http://other/event/12", "/event/12", false},
+	}
+	for _, tt := range tests {
+		if got := keyHasPrefix(tt.key, base, tt.prefix); got != tt.want {
+			t.Errorf("keyHasPrefix(%q, %q) = %v, want %v", tt.key, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestCacheVariantsCoexist(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	upstream.handle(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/genres" && r.URL.Query().Get("lang") == "en" {
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `[{"id": 3, "name": "Drama"}, {"id": 4, "name": "Music"}]`)
+			return
+		}
+		upstream.serve(w, r)
+	})
+	h := newTestServer(t, upstream.URL, func(cfg *Config) {
+		cfg.AdminToken = "secret"
+		// httptest requests come from 192.0.2.1
+		cfg.AdminAllow, _ = parseIPPrefixes("", []string{"192.0.2.0/24"})
+	}).Handler()
+
+	// Variants of the same upstream bodies by language, filter and format
+	targets := []string{
+		"/api/v1/genres",
+		"/api/v1/genres?lang=en",
+		"/api/v1/events",
+		"/api/v1/events?genre=3",
+		"/api/v1/events?genre=4",
+		"/api/v1/events.ics",
+	}
+	bodies := map[string]string{}
+	seen := map[string]string{}
+	for _, target := range targets {
+		w := serve(h, http.MethodGet, target, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, want 200", target, w.Code)
+		}
+		body := w.Body.String()
+		if other, ok := seen[body]; ok {
+			t.Errorf("%s has the body of %s", target, other)
+		}
+		seen[body] = target
+		bodies[target] = body
+	}
+	for _, target := range targets {
+		w := serve(h, http.MethodGet, target, nil)
+		if w.Header().Get("X-Cache") != "HIT" || w.Body.String() != bodies[target] {
+			t.Errorf("%s: X-Cache %q, body %.60q, want a HIT with %.60q", target, w.Header().Get("X-Cache"), w.Body, bodies[target])
+		}
+	}
+	if n := upstream.count("/genres"); n != 2 {
+		t.Errorf("upstream got %d requests for /genres, want one per language", n)
+	}
+	if n := upstream.count("/events"); n != 1 {
+		t.Errorf("upstream got %d requests for /events, want 1", n)
+	}
+
+	// A prefix purges every variant of a path and nothing else
+	admin := http.Header{"Authorization": {"Bearer secret"}}
+	if w := serve(h, http.MethodPost, "/admin/cache/purge?prefix=/genres", admin); w.Code != http.StatusOK {
+		t.Fatalf("purge: status %d: %s", w.Code, w.Body)
+	}
+	for _, target := range targets {
+		want := "HIT"
+		if target == "/api/v1/genres" || target == "/api/v1/genres?lang=en" {
+			want = "MISS"
+		}
+		if w := serve(h, http.MethodGet, target, nil); w.Header().Get("X-Cache") != want {
+			t.Errorf("%s after the purge: X-Cache %q, want %s", target, w.Header().Get("X-Cache"), want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package ksk
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Change to one event between two versions of the events list
+type eventChange struct {
+	Time time.Time `json:"time"`
+	// "added", "removed" or "modified"
+	Type string `json:"type"`
+	ID   string `json:"id"`
+	// Top-level fields that were added, removed or changed, for
+	// modifications
+	Fields []string `json:"fields,omitempty"`
+}
+
+// Rolling log of the changes to the events list, oldest first. It keeps
+// the latest list by ID to diff the next one against, at most size
+// changes and none older than maxAge.
+type changeLog struct {
+	size   int
+	maxAge time.Duration
+
+	mu      sync.Mutex
+	etag    string
+	events  map[string]json.RawMessage
+	changes []eventChange
+	// Changes since this time are all in the log; zero until the first
+	// list was recorded
+	start time.Time
+}
+
+func newChangeLog(size int, maxAge time.Duration) *changeLog {
+	return &changeLog{size: size, maxAge: maxAge}
+}
+
+// Diff a new version of the events list against the previous one and log
+// the changes. The first version only becomes the baseline.
+func (l *changeLog) record(etag string, events []event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if etag == l.etag {
+		return
+	}
+
+	now := time.Now()
+	next := make(map[string]json.RawMessage, len(events))
+	for _, ev := range events {
+		if ev.ID != "" {
+			next[ev.ID] = ev.raw
+		}
+	}
+	if l.events == nil {
+		l.start = now
+	} else {
+		l.changes = append(l.changes, diffEvents(l.events, next, now)...)
+	}
+	l.etag, l.events = etag, next
+	l.trim(now)
+}
+
+// Drop changes beyond the size and age limits. The log then only covers
+// the time after the newest dropped change.
+func (l *changeLog) trim(now time.Time) {
+	drop := max(len(l.changes)-l.size, 0)
+	for drop < len(l.changes) && now.Sub(l.changes[drop].Time) > l.maxAge {
+		drop++
+	}
+	if drop == 0 {
+		return
+	}
+	l.start = l.changes[drop-1].Time
+	l.changes = slices.Delete(l.changes, 0, drop)
+}
+
+// ETag of the latest recorded list
+func (l *changeLog) current() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.etag
+}
+
+// Changes after since up to now, the start of the log, and whether the
+// log covers all changes after since
+func (l *changeLog) since(since, now time.Time) ([]eventChange, time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.trim(now)
+
+	if l.start.IsZero() || since.Before(l.start) {
+		return nil, l.start, false
+	}
+	after := func(c eventChange, t time.Time) int {
+		if c.Time.After(t) {
+			return 1
+		}
+		return -1
+	}
+	i, _ := slices.BinarySearchFunc(l.changes, since, after)
+	j, _ := slices.BinarySearchFunc(l.changes, now, after)
+	return slices.Clone(l.changes[i:max(i, j)]), l.start, true
+}
+
+// Changes from old to new, sorted by type and ID
+func diffEvents(old, new map[string]json.RawMessage, now time.Time) []eventChange {
+	var added, removed, modified []eventChange
+	for id, raw := range new {
+		prev, ok := old[id]
+		switch {
+		case !ok:
+			added = append(added, eventChange{Time: now, Type: "added", ID: id})
+		case !bytes.Equal(prev, raw):
+			modified = append(modified, eventChange{Time: now, Type: "modified", ID: id, Fields: changedFields(prev, raw)})
+		}
+	}
+	for id := range old {
+		if _, ok := new[id]; !ok {
+			removed = append(removed, eventChange{Time: now, Type: "removed", ID: id})
+		}
+	}
+
+	byID := func(a, b eventChange) int { return strings.Compare(a.ID, b.ID) }
+	slices.SortFunc(added, byID)
+	slices.SortFunc(removed, byID)
+	slices.SortFunc(modified, byID)
+	return slices.Concat(added, removed, modified)
+}
+
+// Names of the top-level fields that differ between two versions of an
+// event, sorted
+func changedFields(old, new json.RawMessage) []string {
+	var a, b map[string]json.RawMessage
+	if json.Unmarshal(old, &a) != nil || json.Unmarshal(new, &b) != nil {
+		return nil
+	}
+	var fields []string
+	for name, value := range b {
+		if prev, ok := a[name]; !ok || !bytes.Equal(prev, value) {
+			fields = append(fields, name)
+		}
+	}
+	for name := range a {
+		if _, ok := b[name]; !ok {
+			fields = append(fields, name)
+		}
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+// Response of /events/changes
+type eventChanges struct {
+	Since time.Time `json:"since"`
+	// Time the changes were listed at, the since of the next request
+	Until time.Time `json:"until"`
+	// Start of the time the log covers, null before the events list was
+	// first fetched
+	LogStart *time.Time `json:"log_start"`
+	// Set if since predates the log, so changes may be missing and the
+	// client has to fetch the whole list again
+	Resync  bool          `json:"resync"`
+	Changes []eventChange `json:"changes"`
+}
+
+// Handle /events/changes?since=, the changes to the events list after
+// since, for partners that sync incrementally. The log only covers the
+// time this gateway has been watching the list.
+func (s *Server) eventChangesHandler(policy cachePolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isRead(r) {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+		raw := r.URL.Query().Get("since")
+		if raw == "" {
+			writeError(w, r, http.StatusBadRequest, "invalid_parameter", "Missing since")
+			return
+		}
+		since, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_parameter", "Invalid since, expected an RFC 3339 time: "+raw)
+			return
+		}
+
+		// Fetching the list, if due, logs its latest changes first. A list
+		// another replica put in a shared cache is logged here.
+		list, _, ok := s.resolveOrFail(w, r, s.cfg.UpstreamURL+eventsPath, policy, nil)
+		if !ok {
+			return
+		}
+		if list.etag != s.changes.current() {
+			s.indexEvents(list)
+		}
+
+		now := time.Now()
+		changes, start, complete := s.changes.since(since, now)
+		resp := eventChanges{Since: since, Until: now, Resync: !complete, Changes: changes}
+		if !start.IsZero() {
+			resp.LogStart = &start
+		}
+		if resp.Changes == nil {
+			resp.Changes = []eventChange{}
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
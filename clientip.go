@@ -0,0 +1,130 @@
+package ksk
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type clientIPKey struct{}
+
+// Which peers may report the client's address in X-Forwarded-For or
+// X-Real-IP
+type proxyTrust struct {
+	// Trust the immediate peer whatever its address, such as the proxy in
+	// front of a Unix socket
+	peer bool
+	// Proxies trusted anywhere in the chain
+	trusted []netip.Prefix
+}
+
+// Store the client IP of each request for clientIP. Forwarding headers
+// are only read when the immediate peer is a trusted proxy, so clients
+// connecting directly cannot spoof their address with them.
+func withClientIP(trust proxyTrust, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := trust.resolve(r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip)))
+	})
+}
+
+// Client IP resolved by withClientIP, the connection's remote address
+// outside it
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+// Remote address of the connection without the port
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (t proxyTrust) contains(ip netip.Addr) bool {
+	for _, prefix := range t.trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Walk the forwarding chain from the immediate peer towards the client,
+// stopping at the first hop that is not a trusted proxy. A malformed hop
+// ends the walk at the proxy that reported it.
+func (t proxyTrust) resolve(r *http.Request) string {
+	peer := remoteIP(r)
+	peerAddr, err := netip.ParseAddr(peer)
+	if !t.peer && (err != nil || !t.contains(peerAddr.Unmap())) {
+		return peer
+	}
+
+	hops := forwardedHops(r.Header)
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHop(hops[i])
+		if !ok {
+			break
+		}
+		client = addr.String()
+		if !t.contains(addr) {
+			break
+		}
+	}
+	return client
+}
+
+// Addresses in X-Forwarded-For, nearest proxy last, or the one in
+// X-Real-IP if there are none
+func forwardedHops(h http.Header) []string {
+	var hops []string
+	for _, value := range h.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	if len(hops) == 0 {
+		if real := strings.TrimSpace(h.Get("X-Real-IP")); real != "" {
+			hops = append(hops, real)
+		}
+	}
+	return hops
+}
+
+// Parse a forwarded address, which some proxies send with a port
+func parseHop(hop string) (netip.Addr, bool) {
+	if addr, err := netip.ParseAddr(hop); err == nil {
+		return addr.Unmap(), true
+	}
+	if addrPort, err := netip.ParseAddrPort(hop); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	return netip.Addr{}, false
+}
+
+// Parse a list of CIDR ranges and single addresses; what names an entry in
+// errors
+func parseIPPrefixes(what string, list []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range list {
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: expected an IP address or CIDR range", what, entry)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
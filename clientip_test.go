@@ -0,0 +1,66 @@
+package ksk
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIPForwardingChain(t *testing.T) {
+	trusted, err := parseIPPrefixes("trusted proxy", []string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		trust  proxyTrust
+		remote string
+		xff    []string
+		realIP string
+		want   string
+	}{
+		{"no headers", proxyTrust{trusted: trusted}, "10.0.0.1:1234", nil, "", "10.0.0.1"},
+		{"untrusted peer spoofing", proxyTrust{trusted: trusted}, "203.0.113.5:1234", []string{"1.2.3.4"}, "", "203.0.113.5"},
+		{"untrusted peer real IP", proxyTrust{trusted: trusted}, "203.0.113.5:1234", nil, "1.2.3.4", "203.0.113.5"},
+		{"trusted peer", proxyTrust{trusted: trusted}, "10.0.0.1:1234", []string{"198.51.100.7"}, "", "198.51.100.7"},
+		{"rightmost untrusted hop", proxyTrust{trusted: trusted}, "10.0.0.1:1234", []string{"1.2.3.4, 198.51.100.7, 10.0.0.2"}, "", "198.51.100.7"},
+		{"single trusted address", proxyTrust{trusted: trusted}, "192.168.1.1:1234", []string{"1.2.3.4, 192.168.1.1"}, "", "1.2.3.4"},
+		{"neighbour of a trusted address", proxyTrust{trusted: trusted}, "10.0.0.1:1234", []string{"1.2.3.4, 192.168.1.2"}, "", "192.168.1.2"},
+		{"all hops trusted", proxyTrust{trusted: trusted}, "10.0.0.1:1234", []string{"10.0.0.3, 10.0.0.2"}, "", "10.0.0.3"},
+		{"several headers", proxyTrust{trusted: trusted}, "10.0.0.1:1234", []string{"1.2.3.4", "198.51.100.7"}, "", "198.51.100.7"},
+		{"malformed hop", proxyTrust{trusted: trusted}, "10.0.0.1:1234", []string{"1.2.3.4, garbage, 10.0.0.2"}, "", "10.0.0.2"},
+		{"malformed nearest hop", proxyTrust{trusted: trusted}, "10.0.0.1:1234", []string{"1.2.3.4, not-an-ip"}, "", "10.0.0.1"},
+		{"empty hop", proxyTrust{trusted: trusted}, "10.0.0.1:1234", []string{"1.2.3.4, , 10.0.0.2"}, "", "10.0.0.2"},
+		{"empty header", proxyTrust{trusted: trusted}, "10.0.0.1:1234", []string{""}, "", "10.0.0.1"},
+		{"CIDR as hop", proxyTrust{trusted: trusted}, "10.0.0.1:1234", []string{"1.2.3.0/24"}, "", "10.0.0.1"},
+		{"hop with port", proxyTrust{trusted: trusted}, "10.0.0.1:1234", []string{"198.51.100.7:4711"}, "", "198.51.100.7"},
+		{"IPv6 hop with port", proxyTrust{trusted: trusted}, "10.0.0.1:1234", []string{"[2001:db8::1]:4711"}, "", "2001:db8::1"},
+		{"IPv4-mapped hop", proxyTrust{trusted: trusted}, "10.0.0.1:1234", []string{"::ffff:198.51.100.7"}, "", "198.51.100.7"},
+		{"IPv4-mapped peer", proxyTrust{trusted: trusted}, "[::ffff:10.0.0.1]:1234", []string{"198.51.100.7"}, "", "198.51.100.7"},
+		{"real IP", proxyTrust{trusted: trusted}, "10.0.0.1:1234", nil, "198.51.100.9", "198.51.100.9"},
+		{"real IP behind forwarded for", proxyTrust{trusted: trusted}, "10.0.0.1:1234", []string{"198.51.100.7"}, "198.51.100.9", "198.51.100.7"},
+		{"malformed real IP", proxyTrust{trusted: trusted}, "10.0.0.1:1234", nil, "unknown", "10.0.0.1"},
+		{"any peer trusted", proxyTrust{peer: true}, "@", []string{"1.2.3.4, 198.51.100.7"}, "", "198.51.100.7"},
+		{"nothing trusted", proxyTrust{}, "10.0.0.1:1234", []string{"198.51.100.7"}, "", "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, value := range tt.xff {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			var got string
+			withClientIP(tt.trust, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = clientIP(r)
+			})).ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.want {
+				t.Errorf("client IP %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
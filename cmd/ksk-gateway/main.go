@@ -0,0 +1,34 @@
+// Command ksk-gateway runs the calendar API gateway as a standalone server
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	ksk "github.com/Kulturleben/go-ksk"
+)
+
+func main() {
+	cfg, err := ksk.LoadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	logger, err := ksk.NewLogger(cfg.LogOutput, cfg.LogLevel)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	// Route the standard logger through the JSON handler as well
+	slog.SetDefault(logger)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := ksk.New(cfg).ListenAndServe(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,1247 @@
+package ksk
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	defaultUpstream   = "https://calman.barrierefrei.berlin/calendar/api/v1"
+	defaultListenAddr = ":3000"
+
+	defaultSocketMode = 0o660
+
+	defaultShutdownTimeout = 10 * time.Second
+
+	defaultMaxHeaderBytes    = 16 << 10
+	defaultMaxURILength      = 2048
+	defaultReadHeaderTimeout = 2 * time.Second
+	defaultCacheTTL          = 5 * time.Minute
+	defaultStaleTTL          = time.Hour
+	defaultFailureTTL        = 15 * time.Second
+	defaultTTLMin            = 10 * time.Second
+	defaultTTLMax            = 24 * time.Hour
+	defaultTTLJitter         = 0.1
+	defaultUpstreamRetries   = 2
+
+	defaultUpstreamConcurrency   = 16
+	defaultUpstreamQueueTimeout  = 2 * time.Second
+	defaultUpstreamIdleConns     = 32
+	defaultUpstreamIdleTimeout   = 90 * time.Second
+	defaultUpstreamTLSTimeout    = 5 * time.Second
+	defaultUpstreamHeaderTimeout = 8 * time.Second
+	defaultMaxBodySize           = 10 << 20
+	defaultStreamMinSize         = 1 << 20
+
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+
+	// Off, as without TrustProxy every client behind a proxy shares its IP
+	defaultRateLimit   = 0
+	defaultRateBurst   = 20
+	defaultWarmTimeout = 5 * time.Second
+
+	defaultCacheBackend       = "memory"
+	defaultRedisAddr          = "localhost:6379"
+	defaultRedisPrefix        = "ksk:"
+	defaultCacheMaxEntries    = 10000
+	defaultCacheMaxBytes      = 64 << 20
+	defaultCacheSweepInterval = time.Minute
+
+	defaultFeedLimit = 50
+
+	defaultShrinkLimit = 50
+
+	defaultCORSOrigins = "*"
+	defaultCORSMaxAge  = 10 * time.Minute
+
+	defaultSanitizeFields = "description"
+
+	defaultLanguages = "de,en"
+
+	defaultAccessFeatures = "wheelchair,hearing_loop,sign_language,audio_description,easy_language"
+
+	defaultWebhookTimeout = 5 * time.Second
+
+	defaultStreamClients = 100
+
+	defaultTracingSampleRatio = 1.0
+
+	defaultChangeLogSize = 10000
+	defaultChangeLogAge  = 24 * time.Hour
+
+	defaultLatencySamples    = 1024
+	defaultLatencyWindow     = 5 * time.Minute
+	defaultLatencyAlertAfter = 2 * time.Minute
+
+	defaultAccessLogSampleRate = 1.0
+	defaultAccessLogSlow       = time.Second
+)
+
+// Config holds the runtime settings of the gateway. The settings in
+// reloadableSettings are re-read from the config file and environment on
+// SIGHUP and POST /admin/reload if the Config came from LoadConfig, or
+// taken from a new Config with Server.Reload; changing any other one, such
+// as the listen address or TLS, takes a restart.
+type Config struct {
+	// File of KSK_NAME=value lines that take precedence over the
+	// environment, re-read on reload
+	ConfigFile string
+	// Command-line arguments the configuration was parsed from, parsed
+	// again on reload
+	args []string
+	// Set by LoadConfig; configs built in code have nothing to re-read
+	loaded bool
+	// Base URL of the calendar API, without trailing slash
+	UpstreamURL string
+	// Mirrors of the upstream tried in order when it is down, in the same
+	// form as UpstreamURL
+	UpstreamFallbacks []string
+	// Name the upstream a response came from in an X-Upstream header
+	UpstreamHeader bool
+	// Address the HTTP server listens on: host:port, or unix:/path for a
+	// Unix domain socket (behind a proxy, which should also set TrustProxy)
+	ListenAddr string
+	// Path the API is served under, such as /calendar, when it shares a
+	// host with other services or is mounted by one; empty for the root
+	PathPrefix string
+	// Permissions of the Unix domain socket
+	SocketMode os.FileMode
+	// Private address, in the form of ListenAddr, of a second listener for
+	// /metrics, health checks and the admin and debug endpoints, which then
+	// leave the public one; empty to serve everything on ListenAddr
+	AdminAddr string
+	// Certificate and key files (PEM) to serve HTTPS on ListenAddr; plain
+	// HTTP when both are empty
+	TLSCert string
+	TLSKey  string
+	// Address of a plain-HTTP listener redirecting to HTTPS, empty for none
+	RedirectAddr string
+	// Grace period for in-flight requests on SIGINT/SIGTERM
+	ShutdownTimeout time.Duration
+	// Largest request header block in bytes, request URI in bytes (longer
+	// ones get a 414) and time a client may take to send the headers
+	MaxHeaderBytes    int
+	MaxURILength      int
+	ReadHeaderTimeout time.Duration
+	// Cache TTL per endpoint of ttlEndpoints. The
+	// upstream's Cache-Control or Expires takes precedence where present.
+	TTLs map[string]time.Duration
+	// Bounds for TTLs taken from upstream headers
+	TTLMin time.Duration
+	TTLMax time.Duration
+	// Fraction by which TTLs are randomly lengthened or shortened, so
+	// entries filled together expire at different times; 0 disables it
+	TTLJitter float64
+	// Largest upstream body accepted per endpoint of upstreamEndpoints
+	MaxBodySizes map[string]int64
+	// Time a request may take per endpoint of upstreamEndpoints, including
+	// the routes derived from it, before it is answered with a 504; 0
+	// disables the deadline
+	Timeouts map[string]time.Duration
+	// Events list bodies of at least this size are streamed to the client
+	// on a cache miss instead of being buffered first; 0 disables streaming.
+	// Bodies that are rewritten are never streamed, so it only applies with
+	// EventTimes off, the default, and without StripFields or Sanitize.
+	StreamMinSize int64
+	// How long expired cache entries may be served when the upstream fails
+	StaleTTL time.Duration
+	// How long a failed fetch is remembered, answering requests for the same
+	// URL without contacting the upstream; 0 disables this. Rejected
+	// credentials (401 and 403) are not remembered.
+	FailureTTL time.Duration
+	// Refresh the static endpoints in the background before they expire
+	Prefetch bool
+	// Upper bound for the startup cache warm-up, 0 skips warming
+	WarmTimeout time.Duration
+	// Cache backend: "memory" (per process) or "redis" (shared by replicas)
+	CacheBackend string
+	// Redis connection and key prefix for the redis backend
+	RedisAddr     string
+	RedisPassword string
+	RedisDB       int
+	RedisPrefix   string
+	// Event detail pages linked from the RSS feed: the event ID is appended
+	// to this URL. Items have no link when empty.
+	FeedLinkBase string
+	// Upcoming events listed in the RSS feed
+	FeedLimit int
+	// Public URL of an event page with {id} in place of the event ID, such
+	// as https://kulturleben.berlin/event/{id}. /sitemap.xml lists these
+	// pages and is not served when empty.
+	SitemapURLTemplate string
+	// Limits of the in-memory cache, 0 means unbounded
+	CacheMaxEntries int
+	CacheMaxBytes   int64
+	// How often entries past their stale window are removed
+	CacheSweepInterval time.Duration
+	// File the in-memory cache is saved to on shutdown and restored from
+	// on startup, disabled when empty
+	CacheSnapshot string
+	// Log destination (stderr, stdout or a file path) and minimum level
+	LogOutput string
+	LogLevel  string
+	// Share of access log lines kept; requests that failed with a 4xx or
+	// 5xx status or took at least AccessLogSlow, if positive, are always
+	// logged
+	AccessLogSampleRate float64
+	AccessLogSlow       time.Duration
+	// Browser origins allowed by CORS; "*" allows all, "*.example.org" any subdomain
+	CORSOrigins []string
+	// How long browsers may cache preflight results
+	CORSMaxAge time.Duration
+	// Allow credentialed requests, echoing the origin instead of "*"
+	CORSCredentials bool
+	// Extra attempts for transient upstream failures
+	UpstreamRetries int
+	// Upstream requests in flight at once (0 for no limit) and how long a
+	// fetch waits for one to finish before it is answered with a 503
+	UpstreamConcurrency  int
+	UpstreamQueueTimeout time.Duration
+	// Idle connections kept open per upstream host and for how long, so
+	// bursts of misses do not reconnect and handshake each time
+	UpstreamIdleConns   int
+	UpstreamIdleTimeout time.Duration
+	// Limits on the TLS handshake and on the wait for response headers,
+	// 0 for none
+	UpstreamTLSTimeout    time.Duration
+	UpstreamHeaderTimeout time.Duration
+	// Try HTTP/2 with upstreams that offer it
+	UpstreamHTTP2 bool
+	// Consecutive upstream failures that open the circuit breaker (0 disables
+	// it) and how long it stays open before a probe request is let through
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// Requests per second and burst allowed per client IP, 0 disables
+	// limiting. Behind a proxy it needs TrustProxy or TrustedProxies, or
+	// all clients share one limit.
+	RateLimit float64
+	RateBurst int
+	// Take client IPs from X-Forwarded-For or X-Real-IP when the immediate
+	// peer is a trusted proxy: any peer with TrustProxy, which is only safe
+	// behind a reverse proxy, or one in TrustedProxies. The client is the
+	// rightmost address that is not in TrustedProxies.
+	TrustProxy     bool
+	TrustedProxies []netip.Prefix
+	// Token for the /admin endpoints, which are disabled when empty
+	AdminToken string
+	// Client IPs and CIDR ranges the admin endpoints accept requests from,
+	// none when empty. Clients are identified as for TrustedProxies.
+	AdminAllow []netip.Prefix
+	// Let requests with X-Cache-Bypass: 1 and the admin token update the
+	// cache with what they fetched instead of leaving it untouched
+	CacheBypassStore bool
+	// Serve pprof and runtime stats under /debug/, also with the admin token
+	Debug bool
+	// Serve a Swagger UI page for the OpenAPI document at /api/v1/docs
+	APIDocs bool
+	// Send the gateway's version in an X-Gateway-Version header
+	VersionHeader bool
+	// Header sent with every upstream request, such as Authorization, and
+	// its value, read from UpstreamAuthFile if that is set
+	UpstreamAuthHeader string
+	UpstreamAuthValue  string
+	UpstreamAuthFile   string
+	// Largest drop in percent in the number of events a fetched list may
+	// have against the cached one; larger drops and empty lists are
+	// refused as upstream glitches. 0 disables the guard.
+	ShrinkLimit int
+	// Keys removed from upstream JSON at any depth before it is cached
+	StripFields []string
+	// HTML in the string values of SanitizeFields in event bodies, applied
+	// before they are cached: "off", "text" (strip all tags) or "html"
+	// (keep only p, br, a with a safe href, strong and em)
+	Sanitize       string
+	SanitizeFields []string
+	// Times in event bodies, rewritten before they are cached: "offset"
+	// (RFC 3339 with the Europe/Berlin offset, naive times taken as local),
+	// "utc" or "off", the default, which passes them through as sent and
+	// lets large events lists stream
+	EventTimes string
+	// Languages the upstream serves, the default first. Requests get the
+	// one of them they ask for with ?lang= or Accept-Language, cached
+	// apart. Empty to forward no language.
+	Languages []string
+	// Names of the accessibility features in the upstream's event data
+	// that ?access= on the events list accepts
+	AccessFeatures []string
+	// URLs POSTed a signed notification when the events list changes,
+	// the HMAC key of the signature and the timeout of each delivery
+	WebhookURLs    []string
+	WebhookSecret  string
+	WebhookTimeout time.Duration
+	// Event streams of the events list open at once
+	StreamClients int
+	// Most changes to the events list kept for /events/changes, and how
+	// long each is kept
+	ChangeLogSize int
+	ChangeLogAge  time.Duration
+	// Upstream fetch durations kept per endpoint for the latency
+	// percentiles in /admin/stats: the last LatencySamples within
+	// LatencyWindow. A p95 above LatencyThreshold, if positive, for at
+	// least LatencyAlertAfter is logged as a warning.
+	LatencySamples    int
+	LatencyWindow     time.Duration
+	LatencyThreshold  time.Duration
+	LatencyAlertAfter time.Duration
+	// OTLP/HTTP collector URL spans are exported to, empty to disable
+	// tracing, and the share of requests traced unless the caller decided
+	TracingEndpoint    string
+	TracingSampleRatio float64
+	// Directory of fixture files answering upstream requests offline, or,
+	// with RecordFixtures, where fetched upstream bodies are saved
+	FixturesDir    string
+	RecordFixtures bool
+	// Answer ?callback= on the events and genres lists with JSONP, for
+	// legacy widgets that cannot use CORS
+	JSONP bool
+	// JSON file of additional proxied endpoints, and the routes read from it
+	RoutesFile string
+	Routes     []Route
+	// JSON file of headers added to responses, see ResponseHeaders, and
+	// the headers read from it
+	HeadersFile     string
+	ResponseHeaders ResponseHeaders
+	// JSON files by upstream endpoint served with X-Cache: FALLBACK when a
+	// fetch fails and nothing is cached, and their compacted bodies
+	FallbackFiles map[string]string
+	Fallbacks     map[string][]byte
+}
+
+// Endpoints with their own cache TTL and body size limit, named after the
+// upstream paths they proxy
+var upstreamEndpoints = []string{"events", "genres", "event", "locations", "location"}
+
+// Endpoints with their own cache TTL: the upstream ones and the RSS feed
+var ttlEndpoints = slices.Concat(upstreamEndpoints, []string{"feed"})
+
+// Request deadlines of the upstreamEndpoints: list endpoints are cached
+// and should answer quickly, details are more often fetched
+var defaultTimeouts = map[string]time.Duration{
+	"events":    3 * time.Second,
+	"genres":    3 * time.Second,
+	"locations": 3 * time.Second,
+	"event":     8 * time.Second,
+	"location":  8 * time.Second,
+}
+
+// Map every endpoint to the same default
+func endpointDefaults[T any](endpoints []string, def T) map[string]T {
+	m := make(map[string]T, len(endpoints))
+	for _, endpoint := range endpoints {
+		m[endpoint] = def
+	}
+	return m
+}
+
+// DefaultConfig returns the settings used when no flags or KSK_* variables are set
+func DefaultConfig() Config {
+	return Config{
+		UpstreamURL:           defaultUpstream,
+		ListenAddr:            defaultListenAddr,
+		SocketMode:            defaultSocketMode,
+		ShutdownTimeout:       defaultShutdownTimeout,
+		MaxHeaderBytes:        defaultMaxHeaderBytes,
+		MaxURILength:          defaultMaxURILength,
+		ReadHeaderTimeout:     defaultReadHeaderTimeout,
+		TTLs:                  endpointDefaults(ttlEndpoints, defaultCacheTTL),
+		TTLMin:                defaultTTLMin,
+		TTLMax:                defaultTTLMax,
+		TTLJitter:             defaultTTLJitter,
+		MaxBodySizes:          endpointDefaults(upstreamEndpoints, int64(defaultMaxBodySize)),
+		Timeouts:              maps.Clone(defaultTimeouts),
+		StreamMinSize:         defaultStreamMinSize,
+		StaleTTL:              defaultStaleTTL,
+		FailureTTL:            defaultFailureTTL,
+		Prefetch:              true,
+		VersionHeader:         true,
+		WarmTimeout:           defaultWarmTimeout,
+		CacheBackend:          defaultCacheBackend,
+		RedisAddr:             defaultRedisAddr,
+		RedisPrefix:           defaultRedisPrefix,
+		FeedLimit:             defaultFeedLimit,
+		CacheMaxEntries:       defaultCacheMaxEntries,
+		CacheMaxBytes:         defaultCacheMaxBytes,
+		CacheSweepInterval:    defaultCacheSweepInterval,
+		LogOutput:             "stderr",
+		LogLevel:              "info",
+		AccessLogSampleRate:   defaultAccessLogSampleRate,
+		AccessLogSlow:         defaultAccessLogSlow,
+		CORSOrigins:           splitList(defaultCORSOrigins),
+		CORSMaxAge:            defaultCORSMaxAge,
+		UpstreamRetries:       defaultUpstreamRetries,
+		UpstreamConcurrency:   defaultUpstreamConcurrency,
+		UpstreamQueueTimeout:  defaultUpstreamQueueTimeout,
+		UpstreamIdleConns:     defaultUpstreamIdleConns,
+		UpstreamIdleTimeout:   defaultUpstreamIdleTimeout,
+		UpstreamTLSTimeout:    defaultUpstreamTLSTimeout,
+		UpstreamHeaderTimeout: defaultUpstreamHeaderTimeout,
+		UpstreamHTTP2:         true,
+		BreakerThreshold:      defaultBreakerThreshold,
+		BreakerCooldown:       defaultBreakerCooldown,
+		RateLimit:             defaultRateLimit,
+		RateBurst:             defaultRateBurst,
+		ShrinkLimit:           defaultShrinkLimit,
+		Sanitize:              sanitizeOff,
+		SanitizeFields:        splitList(defaultSanitizeFields),
+		EventTimes:            eventTimesOff,
+		Languages:             splitList(defaultLanguages),
+		AccessFeatures:        splitList(defaultAccessFeatures),
+		WebhookTimeout:        defaultWebhookTimeout,
+		StreamClients:         defaultStreamClients,
+		ChangeLogSize:         defaultChangeLogSize,
+		ChangeLogAge:          defaultChangeLogAge,
+		LatencySamples:        defaultLatencySamples,
+		LatencyWindow:         defaultLatencyWindow,
+		LatencyAlertAfter:     defaultLatencyAlertAfter,
+		TracingSampleRatio:    defaultTracingSampleRatio,
+	}
+}
+
+// LoadConfig parses flags, falling back to the config file, KSK_*
+// environment variables and defaults, in that order
+func LoadConfig(args []string) (Config, error) {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	lookupEnv = os.LookupEnv
+	cfg, err := parseConfig(args)
+	cfg.args, cfg.loaded = args, true
+	if err != nil || cfg.ConfigFile == "" {
+		return cfg, err
+	}
+
+	// Parse again with the file's variables, which may change anything
+	// but the file itself
+	vars, err := readConfigFile(cfg.ConfigFile)
+	if err != nil {
+		return cfg, err
+	}
+	lookupEnv = func(key string) (string, bool) {
+		if v, ok := vars[key]; ok {
+			return v, true
+		}
+		return os.LookupEnv(key)
+	}
+	defer func() { lookupEnv = os.LookupEnv }()
+	cfg, err = parseConfig(args)
+	cfg.args, cfg.loaded = args, true
+	return cfg, err
+}
+
+func parseConfig(args []string) (Config, error) {
+	var cfg Config
+
+	fs := flag.NewFlagSet("go-ksk", flag.ContinueOnError)
+	fs.StringVar(&cfg.ConfigFile, "config", os.Getenv("KSK_CONFIG_FILE"), "file of KSK_NAME=value lines overriding the environment, reloaded on SIGHUP")
+	fs.StringVar(&cfg.UpstreamURL, "upstream", envString("KSK_UPSTREAM_URL", defaultUpstream), "base URL of the upstream calendar API")
+	fs.StringVar(&cfg.ListenAddr, "listen", envString("KSK_LISTEN_ADDR", defaultListenAddr), "address to listen on")
+	fs.StringVar(&cfg.PathPrefix, "path-prefix", envString("KSK_PATH_PREFIX", ""), "path to serve the API under, such as /calendar (empty serves it at the root)")
+
+	socketMode, err := envFileMode("KSK_SOCKET_MODE", defaultSocketMode)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.SocketMode = socketMode
+	fs.Func("socket-mode", "octal permissions of the Unix socket when listening on unix:/path (default 0660)", func(v string) error {
+		mode, err := parseFileMode(v)
+		cfg.SocketMode = mode
+		return err
+	})
+
+	fs.StringVar(&cfg.TLSCert, "tls-cert", envString("KSK_TLS_CERT", ""), "PEM certificate file for serving HTTPS (reloaded on SIGHUP)")
+	fs.StringVar(&cfg.TLSKey, "tls-key", envString("KSK_TLS_KEY", ""), "PEM private key file for serving HTTPS")
+	fs.StringVar(&cfg.AdminAddr, "admin-addr", envString("KSK_ADMIN_ADDR", ""), "private address serving /metrics, health checks and the admin endpoints instead of the public listener (empty disables)")
+	fs.StringVar(&cfg.RedirectAddr, "tls-redirect", envString("KSK_TLS_REDIRECT_ADDR", ""), "address of a plain-HTTP listener redirecting to HTTPS (empty disables)")
+
+	shutdownTimeout, err := envDuration("KSK_SHUTDOWN_TIMEOUT", defaultShutdownTimeout)
+	if err != nil {
+		return cfg, err
+	}
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", shutdownTimeout, "grace period for in-flight requests on shutdown")
+
+	maxHeaderBytes, err := envInt("KSK_MAX_HEADER_BYTES", defaultMaxHeaderBytes)
+	if err != nil {
+		return cfg, err
+	}
+	fs.IntVar(&cfg.MaxHeaderBytes, "max-header-bytes", maxHeaderBytes, "largest request header block in bytes")
+
+	maxURILength, err := envInt("KSK_MAX_URI_LENGTH", defaultMaxURILength)
+	if err != nil {
+		return cfg, err
+	}
+	fs.IntVar(&cfg.MaxURILength, "max-uri-length", maxURILength, "longest request URI in bytes; longer ones get a 414")
+
+	readHeaderTimeout, err := envDuration("KSK_READ_HEADER_TIMEOUT", defaultReadHeaderTimeout)
+	if err != nil {
+		return cfg, err
+	}
+	fs.DurationVar(&cfg.ReadHeaderTimeout, "read-header-timeout", readHeaderTimeout, "time a client may take to send the request headers")
+
+	cfg.TTLs = map[string]time.Duration{}
+	for _, endpoint := range ttlEndpoints {
+		ttl, err := envDuration("KSK_TTL_"+strings.ToUpper(endpoint), defaultCacheTTL)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.TTLs[endpoint] = ttl
+		fs.Func("ttl-"+endpoint, "cache TTL of the "+endpoint+" endpoint (default "+defaultCacheTTL.String()+")", func(v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return err
+			}
+			cfg.TTLs[endpoint] = d
+			return nil
+		})
+	}
+
+	ttlMin, err := envDuration("KSK_TTL_MIN", defaultTTLMin)
+	if err != nil {
+		return cfg, err
+	}
+	fs.DurationVar(&cfg.TTLMin, "ttl-min", ttlMin, "shortest TTL taken from upstream Cache-Control or Expires headers")
+
+	ttlMax, err := envDuration("KSK_TTL_MAX", defaultTTLMax)
+	if err != nil {
+		return cfg, err
+	}
+	fs.DurationVar(&cfg.TTLMax, "ttl-max", ttlMax, "longest TTL taken from upstream Cache-Control or Expires headers")
+
+	ttlJitter, err := envFloat("KSK_TTL_JITTER", defaultTTLJitter)
+	if err != nil {
+		return cfg, err
+	}
+	fs.Float64Var(&cfg.TTLJitter, "ttl-jitter", ttlJitter, "fraction by which cache TTLs randomly vary either way (0 disables)")
+
+	cfg.MaxBodySizes = map[string]int64{}
+	for _, endpoint := range upstreamEndpoints {
+		size, err := envInt("KSK_MAX_BODY_"+strings.ToUpper(endpoint), defaultMaxBodySize)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.MaxBodySizes[endpoint] = int64(size)
+		fs.Func("max-body-"+endpoint, "largest upstream body in bytes accepted for the "+endpoint+" endpoint (default "+strconv.Itoa(defaultMaxBodySize)+")", func(v string) error {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return err
+			}
+			cfg.MaxBodySizes[endpoint] = n
+			return nil
+		})
+	}
+
+	cfg.Timeouts = map[string]time.Duration{}
+	for _, endpoint := range upstreamEndpoints {
+		timeout, err := envDuration("KSK_TIMEOUT_"+strings.ToUpper(endpoint), defaultTimeouts[endpoint])
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Timeouts[endpoint] = timeout
+		fs.Func("timeout-"+endpoint, "deadline of requests to the "+endpoint+" endpoint, 0 for none (default "+defaultTimeouts[endpoint].String()+")", func(v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return err
+			}
+			cfg.Timeouts[endpoint] = d
+			return nil
+		})
+	}
+
+	cfg.FallbackFiles = map[string]string{}
+	for _, endpoint := range upstreamEndpoints {
+		if file := envString("KSK_FALLBACK_"+strings.ToUpper(endpoint), ""); file != "" {
+			cfg.FallbackFiles[endpoint] = file
+		}
+		fs.Func("fallback-"+endpoint, "JSON file served for the "+endpoint+" endpoint when the upstream fails and nothing is cached", func(v string) error {
+			if v == "" {
+				delete(cfg.FallbackFiles, endpoint)
+			} else {
+				cfg.FallbackFiles[endpoint] = v
+			}
+			return nil
+		})
+	}
+
+	streamMin, err := envInt("KSK_STREAM_MIN_SIZE", defaultStreamMinSize)
+	if err != nil {
+		return cfg, err
+	}
+	fs.Int64Var(&cfg.StreamMinSize, "stream-min-size", int64(streamMin), "events list bodies of at least this many bytes are streamed on a cache miss (0 disables)")
+
+	staleTTL, err := envDuration("KSK_STALE_TTL", defaultStaleTTL)
+	if err != nil {
+		return cfg, err
+	}
+	fs.DurationVar(&cfg.StaleTTL, "stale-ttl", staleTTL, "how long expired entries are served if the upstream fails (0 disables)")
+
+	failureTTL, err := envDuration("KSK_FAILURE_TTL", defaultFailureTTL)
+	if err != nil {
+		return cfg, err
+	}
+	fs.DurationVar(&cfg.FailureTTL, "failure-ttl", failureTTL, "how long failed upstream fetches are remembered to fail fast (0 disables)")
+
+	prefetch, err := envBool("KSK_PREFETCH", true)
+	if err != nil {
+		return cfg, err
+	}
+	fs.BoolVar(&cfg.Prefetch, "prefetch", prefetch, "refresh the events and genres lists in the background")
+
+	warmTimeout, err := envDuration("KSK_WARM_TIMEOUT", defaultWarmTimeout)
+	if err != nil {
+		return cfg, err
+	}
+	fs.DurationVar(&cfg.WarmTimeout, "warm-timeout", warmTimeout, "how long startup waits for the cache warm-up (0 disables)")
+
+	fs.StringVar(&cfg.CacheBackend, "cache", envString("KSK_CACHE", defaultCacheBackend), "cache backend: memory or redis")
+	fs.StringVar(&cfg.RedisAddr, "redis-addr", envString("KSK_REDIS_ADDR", defaultRedisAddr), "Redis address for the redis cache backend")
+	fs.StringVar(&cfg.RedisPrefix, "redis-prefix", envString("KSK_REDIS_PREFIX", defaultRedisPrefix), "key prefix for entries stored in Redis")
+	// Only from the environment so the password never shows up in ps output
+	cfg.RedisPassword = envString("KSK_REDIS_PASSWORD", "")
+
+	redisDB, err := envInt("KSK_REDIS_DB", 0)
+	if err != nil {
+		return cfg, err
+	}
+	fs.IntVar(&cfg.RedisDB, "redis-db", redisDB, "Redis database number")
+
+	fs.StringVar(&cfg.FeedLinkBase, "feed-link-base", envString("KSK_FEED_LINK_BASE", ""), "URL that event IDs are appended to for links in the RSS feed")
+
+	feedLimit, err := envInt("KSK_FEED_LIMIT", defaultFeedLimit)
+	if err != nil {
+		return cfg, err
+	}
+	fs.IntVar(&cfg.FeedLimit, "feed-limit", feedLimit, "number of upcoming events in the RSS feed")
+
+	fs.StringVar(&cfg.SitemapURLTemplate, "sitemap-url-template", envString("KSK_SITEMAP_URL_TEMPLATE", ""), "public event page URL with {id} for the event ID, listed in /sitemap.xml (empty disables it)")
+
+	maxEntries, err := envInt("KSK_CACHE_MAX_ENTRIES", defaultCacheMaxEntries)
+	if err != nil {
+		return cfg, err
+	}
+	fs.IntVar(&cfg.CacheMaxEntries, "cache-max-entries", maxEntries, "maximum number of cached responses (0 is unbounded)")
+
+	maxBytes, err := envInt("KSK_CACHE_MAX_BYTES", defaultCacheMaxBytes)
+	if err != nil {
+		return cfg, err
+	}
+	fs.Int64Var(&cfg.CacheMaxBytes, "cache-max-bytes", int64(maxBytes), "maximum total size of cached bodies in bytes (0 is unbounded)")
+
+	sweepInterval, err := envDuration("KSK_CACHE_SWEEP_INTERVAL", defaultCacheSweepInterval)
+	if err != nil {
+		return cfg, err
+	}
+	fs.DurationVar(&cfg.CacheSweepInterval, "cache-sweep-interval", sweepInterval, "how often expired cache entries are removed")
+	fs.StringVar(&cfg.CacheSnapshot, "cache-snapshot", envString("KSK_CACHE_SNAPSHOT", ""), "save the memory cache to this file on shutdown and restore it on startup")
+
+	fs.StringVar(&cfg.LogOutput, "log-output", envString("KSK_LOG_OUTPUT", "stderr"), "log destination: stderr, stdout or a file path")
+	fs.StringVar(&cfg.LogLevel, "log-level", envString("KSK_LOG_LEVEL", "info"), "minimum log level: debug, info, warn or error")
+
+	accessLogSampleRate, err := envFloat("KSK_ACCESS_LOG_SAMPLE_RATE", defaultAccessLogSampleRate)
+	if err != nil {
+		return cfg, err
+	}
+	fs.Float64Var(&cfg.AccessLogSampleRate, "access-log-sample-rate", accessLogSampleRate, "share of successful, fast requests written to the access log")
+
+	accessLogSlow, err := envDuration("KSK_ACCESS_LOG_SLOW", defaultAccessLogSlow)
+	if err != nil {
+		return cfg, err
+	}
+	fs.DurationVar(&cfg.AccessLogSlow, "access-log-slow", accessLogSlow, "requests taking at least this long are always logged, as warnings (0 disables)")
+
+	var corsOrigins string
+	fs.StringVar(&corsOrigins, "cors-origins", envString("KSK_CORS_ORIGINS", defaultCORSOrigins), "comma-separated list of allowed CORS origins")
+
+	corsMaxAge, err := envDuration("KSK_CORS_MAX_AGE", defaultCORSMaxAge)
+	if err != nil {
+		return cfg, err
+	}
+	fs.DurationVar(&cfg.CORSMaxAge, "cors-max-age", corsMaxAge, "how long browsers may cache CORS preflight responses")
+
+	corsCredentials, err := envBool("KSK_CORS_CREDENTIALS", false)
+	if err != nil {
+		return cfg, err
+	}
+	fs.BoolVar(&cfg.CORSCredentials, "cors-credentials", corsCredentials, "allow credentialed CORS requests from the configured origins")
+
+	retries, err := envInt("KSK_UPSTREAM_RETRIES", defaultUpstreamRetries)
+	if err != nil {
+		return cfg, err
+	}
+	fs.IntVar(&cfg.UpstreamRetries, "upstream-retries", retries, "retries for connection errors, timeouts and 502/503/504 from the upstream")
+
+	concurrency, err := envInt("KSK_UPSTREAM_CONCURRENCY", defaultUpstreamConcurrency)
+	if err != nil {
+		return cfg, err
+	}
+	fs.IntVar(&cfg.UpstreamConcurrency, "upstream-concurrency", concurrency, "upstream requests in flight at once (0 for no limit)")
+
+	queueTimeout, err := envDuration("KSK_UPSTREAM_QUEUE_TIMEOUT", defaultUpstreamQueueTimeout)
+	if err != nil {
+		return cfg, err
+	}
+	fs.DurationVar(&cfg.UpstreamQueueTimeout, "upstream-queue-timeout", queueTimeout, "how long a fetch waits for a free upstream request slot before a 503")
+
+	idleConns, err := envInt("KSK_UPSTREAM_IDLE_CONNS", defaultUpstreamIdleConns)
+	if err != nil {
+		return cfg, err
+	}
+	fs.IntVar(&cfg.UpstreamIdleConns, "upstream-idle-conns", idleConns, "idle connections kept open per upstream host")
+
+	idleTimeout, err := envDuration("KSK_UPSTREAM_IDLE_TIMEOUT", defaultUpstreamIdleTimeout)
+	if err != nil {
+		return cfg, err
+	}
+	fs.DurationVar(&cfg.UpstreamIdleTimeout, "upstream-idle-timeout", idleTimeout, "how long idle upstream connections are kept open (0 for no limit)")
+
+	tlsTimeout, err := envDuration("KSK_UPSTREAM_TLS_TIMEOUT", defaultUpstreamTLSTimeout)
+	if err != nil {
+		return cfg, err
+	}
+	fs.DurationVar(&cfg.UpstreamTLSTimeout, "upstream-tls-timeout", tlsTimeout, "limit on the TLS handshake with the upstream (0 for none)")
+
+	headerTimeout, err := envDuration("KSK_UPSTREAM_HEADER_TIMEOUT", defaultUpstreamHeaderTimeout)
+	if err != nil {
+		return cfg, err
+	}
+	fs.DurationVar(&cfg.UpstreamHeaderTimeout, "upstream-header-timeout", headerTimeout, "how long to wait for the upstream's response headers (0 for no limit)")
+
+	http2, err := envBool("KSK_UPSTREAM_HTTP2", true)
+	if err != nil {
+		return cfg, err
+	}
+	fs.BoolVar(&cfg.UpstreamHTTP2, "upstream-http2", http2, "try HTTP/2 with upstreams that offer it")
+
+	threshold, err := envInt("KSK_BREAKER_THRESHOLD", defaultBreakerThreshold)
+	if err != nil {
+		return cfg, err
+	}
+	fs.IntVar(&cfg.BreakerThreshold, "breaker-threshold", threshold, "consecutive upstream failures that open the circuit breaker (0 disables)")
+
+	cooldown, err := envDuration("KSK_BREAKER_COOLDOWN", defaultBreakerCooldown)
+	if err != nil {
+		return cfg, err
+	}
+	fs.DurationVar(&cfg.BreakerCooldown, "breaker-cooldown", cooldown, "how long the circuit breaker stays open before probing the upstream")
+
+	rateLimit, err := envFloat("KSK_RATE_LIMIT", defaultRateLimit)
+	if err != nil {
+		return cfg, err
+	}
+	fs.Float64Var(&cfg.RateLimit, "rate-limit", rateLimit, "requests per second allowed per client IP (0 disables)")
+
+	rateBurst, err := envInt("KSK_RATE_BURST", defaultRateBurst)
+	if err != nil {
+		return cfg, err
+	}
+	fs.IntVar(&cfg.RateBurst, "rate-burst", rateBurst, "burst of requests allowed per client IP")
+
+	trustProxy, err := envBool("KSK_TRUST_PROXY", false)
+	if err != nil {
+		return cfg, err
+	}
+	fs.BoolVar(&cfg.TrustProxy, "trust-proxy", trustProxy, "trust the immediate peer's X-Forwarded-For to identify clients")
+	var trustedProxies string
+	fs.StringVar(&trustedProxies, "trusted-proxies", envString("KSK_TRUSTED_PROXIES", ""), "comma-separated IPs and CIDR ranges of proxies whose X-Forwarded-For is trusted")
+
+	fs.StringVar(&cfg.AdminToken, "admin-token", envString("KSK_ADMIN_TOKEN", ""), "token required for /admin endpoints (empty disables them)")
+	var adminAllow string
+	fs.StringVar(&adminAllow, "admin-allow", envString("KSK_ADMIN_ALLOW", ""), "comma-separated client IPs and CIDR ranges allowed to use /admin endpoints (empty allows none)")
+	bypassStore, err := envBool("KSK_CACHE_BYPASS_STORE", false)
+	if err != nil {
+		return cfg, err
+	}
+	fs.BoolVar(&cfg.CacheBypassStore, "cache-bypass-store", bypassStore, "cache what admin requests with X-Cache-Bypass: 1 fetch")
+
+	fs.StringVar(&cfg.UpstreamAuthHeader, "upstream-auth-header", envString("KSK_UPSTREAM_AUTH_HEADER", ""), "header carrying credentials on upstream requests")
+	fs.StringVar(&cfg.UpstreamAuthFile, "upstream-auth-file", envString("KSK_UPSTREAM_AUTH_FILE", ""), "file holding the upstream credentials header value (reloaded on SIGHUP)")
+	// Only from the environment so the secret never shows up in ps output
+	cfg.UpstreamAuthValue = envString("KSK_UPSTREAM_AUTH_VALUE", "")
+
+	var webhookURLs string
+	fs.StringVar(&webhookURLs, "webhook-urls", envString("KSK_WEBHOOK_URLS", ""), "comma-separated URLs notified when the events list changes")
+	// Like the upstream credentials, only from the environment or config file
+	cfg.WebhookSecret = envString("KSK_WEBHOOK_SECRET", "")
+	webhookTimeout, err := envDuration("KSK_WEBHOOK_TIMEOUT", defaultWebhookTimeout)
+	if err != nil {
+		return cfg, err
+	}
+	fs.DurationVar(&cfg.WebhookTimeout, "webhook-timeout", webhookTimeout, "timeout of each webhook delivery")
+
+	streamClients, err := envInt("KSK_STREAM_CLIENTS", defaultStreamClients)
+	if err != nil {
+		return cfg, err
+	}
+	fs.IntVar(&cfg.StreamClients, "stream-clients", streamClients, "event streams open at once")
+
+	changeLogSize, err := envInt("KSK_CHANGE_LOG_SIZE", defaultChangeLogSize)
+	if err != nil {
+		return cfg, err
+	}
+	fs.IntVar(&cfg.ChangeLogSize, "change-log-size", changeLogSize, "most event changes kept for /events/changes")
+	changeLogAge, err := envDuration("KSK_CHANGE_LOG_AGE", defaultChangeLogAge)
+	if err != nil {
+		return cfg, err
+	}
+	fs.DurationVar(&cfg.ChangeLogAge, "change-log-age", changeLogAge, "how long event changes are kept for /events/changes")
+
+	latencySamples, err := envInt("KSK_LATENCY_SAMPLES", defaultLatencySamples)
+	if err != nil {
+		return cfg, err
+	}
+	fs.IntVar(&cfg.LatencySamples, "latency-samples", latencySamples, "upstream fetch durations kept per endpoint for latency percentiles")
+	latencyWindow, err := envDuration("KSK_LATENCY_WINDOW", defaultLatencyWindow)
+	if err != nil {
+		return cfg, err
+	}
+	fs.DurationVar(&cfg.LatencyWindow, "latency-window", latencyWindow, "how far back upstream latency percentiles look")
+	latencyThreshold, err := envDuration("KSK_LATENCY_THRESHOLD", 0)
+	if err != nil {
+		return cfg, err
+	}
+	fs.DurationVar(&cfg.LatencyThreshold, "latency-threshold", latencyThreshold, "upstream p95 latency above which a warning is logged (0 disables)")
+	latencyAlertAfter, err := envDuration("KSK_LATENCY_ALERT_AFTER", defaultLatencyAlertAfter)
+	if err != nil {
+		return cfg, err
+	}
+	fs.DurationVar(&cfg.LatencyAlertAfter, "latency-alert-after", latencyAlertAfter, "how long the p95 must stay above the threshold before the warning")
+
+	fs.StringVar(&cfg.TracingEndpoint, "tracing-endpoint", envString("KSK_TRACING_ENDPOINT", ""), "OTLP/HTTP collector URL to export traces to (empty disables tracing)")
+	sampleRatio, err := envFloat("KSK_TRACING_SAMPLE_RATIO", defaultTracingSampleRatio)
+	if err != nil {
+		return cfg, err
+	}
+	fs.Float64Var(&cfg.TracingSampleRatio, "tracing-sample-ratio", sampleRatio, "share of requests traced when the caller sent no sampling decision")
+
+	fs.StringVar(&cfg.FixturesDir, "fixtures", envString("KSK_FIXTURES_DIR", ""), "serve upstream requests from JSON files in this directory instead of the upstream")
+
+	record, err := envBool("KSK_RECORD", false)
+	if err != nil {
+		return cfg, err
+	}
+	fs.BoolVar(&cfg.RecordFixtures, "record", record, "fetch from the upstream and save every body to the fixtures directory")
+
+	jsonp, err := envBool("KSK_JSONP", false)
+	if err != nil {
+		return cfg, err
+	}
+	fs.BoolVar(&cfg.JSONP, "jsonp", jsonp, "wrap the events and genres lists in ?callback= for JSONP widgets")
+
+	fs.StringVar(&cfg.RoutesFile, "routes", envString("KSK_ROUTES_FILE", ""), "JSON file of additional endpoints proxied to the upstream")
+	fs.StringVar(&cfg.HeadersFile, "headers", envString("KSK_HEADERS_FILE", ""), "JSON file of headers added to all responses or those of one route")
+
+	debug, err := envBool("KSK_DEBUG", false)
+	if err != nil {
+		return cfg, err
+	}
+	fs.BoolVar(&cfg.Debug, "debug", debug, "serve pprof and runtime stats under /debug/ (requires the admin token)")
+
+	apiDocs, err := envBool("KSK_API_DOCS", false)
+	if err != nil {
+		return cfg, err
+	}
+	fs.BoolVar(&cfg.APIDocs, "api-docs", apiDocs, "serve a Swagger UI page for the OpenAPI document at /api/v1/docs")
+
+	versionHeader, err := envBool("KSK_VERSION_HEADER", true)
+	if err != nil {
+		return cfg, err
+	}
+	fs.BoolVar(&cfg.VersionHeader, "version-header", versionHeader, "send the gateway's version in an X-Gateway-Version response header")
+
+	var fallbacks string
+	fs.StringVar(&fallbacks, "upstream-fallbacks", envString("KSK_UPSTREAM_FALLBACK_URLS", ""), "comma-separated mirror base URLs tried in order when the upstream is down")
+
+	upstreamHeader, err := envBool("KSK_UPSTREAM_HEADER", false)
+	if err != nil {
+		return cfg, err
+	}
+	fs.BoolVar(&cfg.UpstreamHeader, "upstream-header", upstreamHeader, "send the upstream a response came from in an X-Upstream header")
+
+	shrinkLimit, err := envInt("KSK_SHRINK_LIMIT", defaultShrinkLimit)
+	if err != nil {
+		return cfg, err
+	}
+	fs.IntVar(&cfg.ShrinkLimit, "shrink-limit", shrinkLimit, "largest drop in percent in the number of events accepted from the upstream, 0 to accept any")
+
+	var stripFields string
+	fs.StringVar(&stripFields, "strip-fields", envString("KSK_STRIP_FIELDS", ""), "comma-separated JSON keys removed from upstream responses")
+	fs.StringVar(&cfg.Sanitize, "sanitize", envString("KSK_SANITIZE", sanitizeOff), "HTML in event descriptions: off, text (strip tags) or html (allowlist)")
+	var sanitizeFields string
+	fs.StringVar(&sanitizeFields, "sanitize-fields", envString("KSK_SANITIZE_FIELDS", defaultSanitizeFields), "comma-separated JSON keys whose HTML is sanitized")
+	fs.StringVar(&cfg.EventTimes, "event-times", envString("KSK_EVENT_TIMES", eventTimesOff), "times in event bodies: offset (RFC 3339, Europe/Berlin), utc or off; any but off disables streaming")
+	var languages string
+	fs.StringVar(&languages, "languages", envString("KSK_LANGUAGES", defaultLanguages), "comma-separated languages the upstream serves, the default first")
+	var accessFeatures string
+	fs.StringVar(&accessFeatures, "access-features", envString("KSK_ACCESS_FEATURES", defaultAccessFeatures), "comma-separated accessibility features the events list can be filtered by")
+
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	cfg.CORSOrigins = splitList(corsOrigins)
+	if cfg.TrustedProxies, err = parseIPPrefixes("trusted proxy", splitList(trustedProxies)); err != nil {
+		return cfg, err
+	}
+	if cfg.AdminAllow, err = parseIPPrefixes("admin allowlist entry", splitList(adminAllow)); err != nil {
+		return cfg, err
+	}
+	cfg.StripFields = splitList(stripFields)
+	cfg.SanitizeFields = splitList(sanitizeFields)
+	cfg.AccessFeatures = splitList(accessFeatures)
+	cfg.WebhookURLs = splitList(webhookURLs)
+	cfg.Languages = nil
+	for _, lang := range splitList(languages) {
+		cfg.Languages = append(cfg.Languages, strings.ToLower(lang))
+	}
+
+	upstream, err := validateUpstream(cfg.UpstreamURL)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.UpstreamURL = upstream
+	for _, raw := range splitList(fallbacks) {
+		fallback, err := validateUpstream(raw)
+		if err != nil {
+			return cfg, err
+		}
+		if fallback == cfg.UpstreamURL || slices.Contains(cfg.UpstreamFallbacks, fallback) {
+			return cfg, fmt.Errorf("upstream URL %q listed twice", fallback)
+		}
+		cfg.UpstreamFallbacks = append(cfg.UpstreamFallbacks, fallback)
+	}
+
+	if cfg.RoutesFile != "" {
+		if cfg.Routes, err = loadRoutes(cfg.RoutesFile, cfg.UpstreamURL); err != nil {
+			return cfg, err
+		}
+	}
+	if cfg.HeadersFile != "" {
+		if cfg.ResponseHeaders, err = loadResponseHeaders(cfg.HeadersFile); err != nil {
+			return cfg, err
+		}
+	}
+	if cfg.Fallbacks, err = loadFallbacks(cfg.FallbackFiles); err != nil {
+		return cfg, err
+	}
+
+	if cfg.ListenAddr == "" || cfg.ListenAddr == "unix:" {
+		return cfg, fmt.Errorf("listen address must not be empty")
+	}
+	cfg.PathPrefix = strings.TrimSuffix(cfg.PathPrefix, "/")
+	if cfg.PathPrefix != "" && (!strings.HasPrefix(cfg.PathPrefix, "/") || strings.ContainsAny(cfg.PathPrefix, "?#")) {
+		return cfg, fmt.Errorf("path prefix must be a path such as /calendar")
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return cfg, fmt.Errorf("TLS certificate and key must be set together")
+	}
+	if cfg.AdminAddr != "" && (cfg.AdminAddr == cfg.ListenAddr || cfg.AdminAddr == cfg.RedirectAddr || cfg.AdminAddr == "unix:") {
+		return cfg, fmt.Errorf("admin address must be a listener of its own")
+	}
+	if cfg.RedirectAddr != "" && cfg.TLSCert == "" {
+		return cfg, fmt.Errorf("HTTPS redirect requires a TLS certificate and key")
+	}
+	if cfg.MaxHeaderBytes <= 0 || cfg.MaxURILength <= 0 || cfg.ReadHeaderTimeout <= 0 {
+		return cfg, fmt.Errorf("header size, URI length and header read timeout limits must be positive")
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		return cfg, fmt.Errorf("shutdown timeout must be positive")
+	}
+	for endpoint, ttl := range cfg.TTLs {
+		if ttl <= 0 {
+			return cfg, fmt.Errorf("TTL of %s must be positive", endpoint)
+		}
+	}
+	if cfg.TTLMin < 0 || cfg.TTLMax <= 0 || cfg.TTLMin > cfg.TTLMax {
+		return cfg, fmt.Errorf("TTL bounds must satisfy 0 <= minimum <= maximum and maximum > 0")
+	}
+	if !(cfg.TTLJitter >= 0 && cfg.TTLJitter < 1) {
+		return cfg, fmt.Errorf("TTL jitter must be at least 0 and below 1")
+	}
+	for endpoint, size := range cfg.MaxBodySizes {
+		if size <= 0 {
+			return cfg, fmt.Errorf("maximum body size of %s must be positive", endpoint)
+		}
+	}
+	for endpoint, timeout := range cfg.Timeouts {
+		if timeout < 0 {
+			return cfg, fmt.Errorf("timeout of %s must not be negative", endpoint)
+		}
+	}
+	if cfg.StreamMinSize < 0 {
+		return cfg, fmt.Errorf("stream minimum size must not be negative")
+	}
+	if cfg.StaleTTL < 0 {
+		return cfg, fmt.Errorf("stale TTL must not be negative")
+	}
+	if cfg.FailureTTL < 0 {
+		return cfg, fmt.Errorf("failure TTL must not be negative")
+	}
+	if cfg.WarmTimeout < 0 {
+		return cfg, fmt.Errorf("warm-up timeout must not be negative")
+	}
+	if cfg.UpstreamRetries < 0 {
+		return cfg, fmt.Errorf("upstream retries must not be negative")
+	}
+	if cfg.UpstreamConcurrency < 0 || cfg.UpstreamQueueTimeout < 0 {
+		return cfg, fmt.Errorf("upstream concurrency and queue timeout must not be negative")
+	}
+	if cfg.UpstreamIdleConns <= 0 {
+		return cfg, fmt.Errorf("upstream idle connections must be positive")
+	}
+	if cfg.UpstreamIdleTimeout < 0 || cfg.UpstreamTLSTimeout < 0 || cfg.UpstreamHeaderTimeout < 0 {
+		return cfg, fmt.Errorf("upstream connection timeouts must not be negative")
+	}
+	if cfg.BreakerThreshold < 0 || cfg.BreakerCooldown <= 0 {
+		return cfg, fmt.Errorf("circuit breaker threshold must not be negative and cool-down must be positive")
+	}
+	if cfg.RateLimit < 0 || cfg.RateBurst < 0 {
+		return cfg, fmt.Errorf("rate limit and burst must not be negative")
+	}
+	if cfg.Debug && cfg.AdminToken == "" {
+		return cfg, fmt.Errorf("debug endpoints require an admin token")
+	}
+	if cfg.CORSCredentials && slices.Contains(cfg.CORSOrigins, "*") {
+		return cfg, fmt.Errorf("CORS credentials require explicit origins instead of *")
+	}
+	if cfg.CacheBackend != "memory" && cfg.CacheBackend != "redis" {
+		return cfg, fmt.Errorf("unknown cache backend %q", cfg.CacheBackend)
+	}
+	if cfg.ShrinkLimit < 0 || cfg.ShrinkLimit > 100 {
+		return cfg, fmt.Errorf("shrink limit must be between 0 and 100 percent")
+	}
+	if cfg.Sanitize != sanitizeOff && cfg.Sanitize != sanitizeText && cfg.Sanitize != sanitizeHTML {
+		return cfg, fmt.Errorf("unknown sanitize mode %q", cfg.Sanitize)
+	}
+	if cfg.EventTimes != eventTimesOff && cfg.EventTimes != eventTimesOffset && cfg.EventTimes != eventTimesUTC {
+		return cfg, fmt.Errorf("unknown event times mode %q", cfg.EventTimes)
+	}
+	for _, lang := range cfg.Languages {
+		if !validLanguage(lang) {
+			return cfg, fmt.Errorf("invalid language %q: expected a primary language subtag such as en", lang)
+		}
+	}
+	for _, target := range cfg.WebhookURLs {
+		if u, err := url.Parse(target); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return cfg, fmt.Errorf("invalid webhook URL %q: expected an http or https URL", target)
+		}
+	}
+	if len(cfg.WebhookURLs) > 0 && cfg.WebhookSecret == "" {
+		return cfg, fmt.Errorf("webhooks require a secret to sign their notifications")
+	}
+	if cfg.WebhookTimeout <= 0 {
+		return cfg, fmt.Errorf("webhook timeout must be positive")
+	}
+	if cfg.TracingEndpoint != "" {
+		if u, err := url.Parse(cfg.TracingEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return cfg, fmt.Errorf("invalid tracing endpoint %q: expected an http or https URL", cfg.TracingEndpoint)
+		}
+	}
+	if cfg.TracingSampleRatio < 0 || cfg.TracingSampleRatio > 1 {
+		return cfg, fmt.Errorf("tracing sample ratio must be between 0 and 1")
+	}
+	if cfg.StreamClients <= 0 {
+		return cfg, fmt.Errorf("stream client limit must be positive")
+	}
+	if cfg.ChangeLogSize <= 0 || cfg.ChangeLogAge <= 0 {
+		return cfg, fmt.Errorf("change log size and age must be positive")
+	}
+	if cfg.LatencySamples <= 0 || cfg.LatencyWindow <= 0 {
+		return cfg, fmt.Errorf("latency samples and window must be positive")
+	}
+	if cfg.LatencyThreshold < 0 || cfg.LatencyAlertAfter < 0 {
+		return cfg, fmt.Errorf("latency threshold and alert delay must not be negative")
+	}
+	if cfg.AccessLogSampleRate < 0 || cfg.AccessLogSampleRate > 1 {
+		return cfg, fmt.Errorf("access log sample rate must be between 0 and 1")
+	}
+	if cfg.AccessLogSlow < 0 {
+		return cfg, fmt.Errorf("access log slow threshold must not be negative")
+	}
+	if cfg.FeedLimit <= 0 {
+		return cfg, fmt.Errorf("feed limit must be positive")
+	}
+	if cfg.SitemapURLTemplate != "" {
+		u, err := url.Parse(strings.ReplaceAll(cfg.SitemapURLTemplate, sitemapIDPlaceholder, "0"))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || !strings.Contains(cfg.SitemapURLTemplate, sitemapIDPlaceholder) {
+			return cfg, fmt.Errorf("invalid sitemap URL template %q: expected an http or https URL containing %s", cfg.SitemapURLTemplate, sitemapIDPlaceholder)
+		}
+	}
+	if cfg.CacheMaxEntries < 0 || cfg.CacheMaxBytes < 0 {
+		return cfg, fmt.Errorf("cache limits must not be negative")
+	}
+	if cfg.CacheSweepInterval <= 0 {
+		return cfg, fmt.Errorf("cache sweep interval must be positive")
+	}
+	if cfg.CacheSnapshot != "" && cfg.CacheBackend != "memory" {
+		return cfg, fmt.Errorf("cache snapshots require the memory cache backend")
+	}
+	if cfg.RecordFixtures && cfg.FixturesDir == "" {
+		return cfg, fmt.Errorf("recording fixtures requires a fixtures directory")
+	}
+	if err := loadUpstreamAuth(&cfg); err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+// Check the upstream credentials settings and read the secrets file
+func loadUpstreamAuth(cfg *Config) error {
+	if cfg.UpstreamAuthHeader == "" {
+		if cfg.UpstreamAuthValue != "" || cfg.UpstreamAuthFile != "" {
+			return fmt.Errorf("upstream auth value or file requires an upstream auth header")
+		}
+		return nil
+	}
+	if !validHeaderName(cfg.UpstreamAuthHeader) {
+		return fmt.Errorf("invalid upstream auth header %q", cfg.UpstreamAuthHeader)
+	}
+	if (cfg.UpstreamAuthValue == "") == (cfg.UpstreamAuthFile == "") {
+		return fmt.Errorf("upstream auth header needs exactly one of KSK_UPSTREAM_AUTH_VALUE and an auth file")
+	}
+	if cfg.UpstreamAuthFile != "" {
+		value, err := readSecretFile(cfg.UpstreamAuthFile)
+		if err != nil {
+			return err
+		}
+		cfg.UpstreamAuthValue = value
+	}
+	if strings.ContainsAny(cfg.UpstreamAuthValue, "\r\n") {
+		return fmt.Errorf("upstream auth value must be a single line")
+	}
+	return nil
+}
+
+// Report whether name is a valid HTTP header field name (RFC 9110 5.1)
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c > unicode.MaxASCII || !(unicode.IsLetter(c) || unicode.IsDigit(c) || strings.ContainsRune("!#$%&'*+-.^_`|~", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Check that the upstream is an absolute http(s) URL and normalize it
+func validateUpstream(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid upstream URL %q: %w", raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid upstream URL %q: scheme must be http or https", raw)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid upstream URL %q: missing host", raw)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("invalid upstream URL %q: must not contain a query or fragment", raw)
+	}
+
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// Return the environment variable or def if it is unset or empty
+func envString(key, def string) string {
+	if v, ok := lookupEnv(key); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+// Parse the environment variable as a duration or return def if it is unset
+func envDuration(key string, def time.Duration) (time.Duration, error) {
+	v, ok := lookupEnv(key)
+	if !ok || v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return d, nil
+}
+
+// Parse the environment variable as a boolean or return def if it is unset
+func envBool(key string, def bool) (bool, error) {
+	v, ok := lookupEnv(key)
+	if !ok || v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return b, nil
+}
+
+// Parse the environment variable as an integer or return def if it is unset
+func envInt(key string, def int) (int, error) {
+	v, ok := lookupEnv(key)
+	if !ok || v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return n, nil
+}
+
+// Split a comma-separated list, dropping empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Parse the environment variable as a float or return def if it is unset
+func envFloat(key string, def float64) (float64, error) {
+	v, ok := lookupEnv(key)
+	if !ok || v == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return f, nil
+}
+
+// Parse the environment variable as octal permissions or return def if it
+// is unset
+func envFileMode(key string, def os.FileMode) (os.FileMode, error) {
+	v, ok := lookupEnv(key)
+	if !ok || v == "" {
+		return def, nil
+	}
+	mode, err := parseFileMode(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return mode, nil
+}
+
+// Parse octal permission bits such as 0660
+func parseFileMode(s string) (os.FileMode, error) {
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || n > 0o777 {
+		return 0, fmt.Errorf("%q is not an octal file mode", s)
+	}
+	return os.FileMode(n), nil
+}
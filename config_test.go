@@ -0,0 +1,45 @@
+package ksk
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigRateLimitOff(t *testing.T) {
+	t.Setenv("KSK_RATE_LIMIT", "")
+	cfg, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.RateLimit != 0 {
+		t.Errorf("RateLimit %v by default, want 0", cfg.RateLimit)
+	}
+	if New(cfg).limiter != nil {
+		t.Error("rate limiter built by default")
+	}
+}
+
+func TestRecordFixturesDirCreatedAtStartup(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "fixtures")
+	cfg, err := LoadConfig([]string{"-fixtures", dir, "-record"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("LoadConfig created the fixtures directory: %v", err)
+	}
+
+	cfg.UpstreamURL = newFakeUpstream(t).URL
+	cfg.Prefetch = false
+	cfg.WarmTimeout = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := New(cfg).Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		t.Errorf("fixtures directory not created by Run: %v", err)
+	}
+}
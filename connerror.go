@@ -0,0 +1,93 @@
+package ksk
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"syscall"
+)
+
+// Classes of failed requests to the upstream
+var connErrorClasses = []string{"timeout", "dns", "connection_refused", "connection_reset", "tls", "connection"}
+
+// Classes of upstream errors as reported in error responses, metrics and
+// stats: why a request failed, or which upstream error sentinel a response
+// matched
+var upstreamErrorClasses = slices.Concat(connErrorClasses, []string{"status", "read", "invalid", "too_large", "unavailable"})
+
+// Failed request to the upstream, with the class of the failure and the
+// host it was sent to. It matches errUpstreamUnavailable with errors.Is,
+// and errUpstreamTimeout too if the request timed out.
+type upstreamConnError struct {
+	class string
+	host  string
+	// Underlying error, nil for a remembered failure
+	err error
+}
+
+func newUpstreamConnError(host string, err error) *upstreamConnError {
+	return &upstreamConnError{class: connErrorClass(err), host: host, err: err}
+}
+
+func (e *upstreamConnError) Error() string {
+	msg := errUpstreamUnavailable.Error() + ": " + e.class
+	if e.host != "" {
+		msg += " talking to " + e.host
+	}
+	if e.err != nil {
+		msg += ": " + e.err.Error()
+	}
+	return msg
+}
+
+func (e *upstreamConnError) Unwrap() error {
+	return e.err
+}
+
+func (e *upstreamConnError) Is(target error) bool {
+	return target == errUpstreamUnavailable || (target == errUpstreamTimeout && e.class == "timeout")
+}
+
+// Class of a transport error: DNS before timeouts, as a lookup that timed
+// out points at the resolver rather than the upstream
+func connErrorClass(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	switch {
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection_refused"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "connection_reset"
+	case errors.As(err, &certErr), errors.As(err, &recordErr), errors.As(err, &alertErr):
+		return "tls"
+	}
+	return "connection"
+}
+
+// Class of any upstream error, one of upstreamErrorClasses
+func upstreamErrorClass(err error) string {
+	var connErr *upstreamConnError
+	switch {
+	case errors.As(err, &connErr):
+		return connErr.class
+	case errors.Is(err, errUpstreamStatus):
+		return "status"
+	case errors.Is(err, errUpstreamRead):
+		return "read"
+	case errors.Is(err, errUpstreamInvalid):
+		return "invalid"
+	case errors.Is(err, errUpstreamTooLarge):
+		return "too_large"
+	}
+	return "unavailable"
+}
@@ -0,0 +1,142 @@
+package ksk
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Origins allowed to read gateway responses from a browser
+type corsPolicy struct {
+	allowAll bool
+	exact    map[string]bool
+	// Wildcard entries such as "*.berlin.de" or "https://*.berlin.de"
+	wildcards []originPattern
+	maxAge    string
+	// Let browsers send cookies and HTTP auth; browsers refuse this for
+	// "*", so LoadConfig requires explicit origins
+	credentials bool
+}
+
+type originPattern struct {
+	scheme string // empty matches any scheme
+	suffix string // host suffix including the leading dot
+}
+
+func newCORSPolicy(origins []string, maxAge time.Duration, credentials bool) corsPolicy {
+	p := corsPolicy{
+		exact:       map[string]bool{},
+		maxAge:      strconv.Itoa(int(maxAge.Seconds())),
+		credentials: credentials,
+	}
+
+	for _, origin := range origins {
+		origin = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(origin), "/"))
+		switch {
+		case origin == "":
+		case origin == "*":
+			p.allowAll = true
+		case strings.Contains(origin, "*."):
+			scheme, host, found := strings.Cut(origin, "://")
+			if !found {
+				scheme, host = "", origin
+			}
+			p.wildcards = append(p.wildcards, originPattern{
+				scheme: scheme,
+				suffix: strings.TrimPrefix(host, "*"),
+			})
+		default:
+			p.exact[origin] = true
+		}
+	}
+	return p
+}
+
+// Report whether the Origin header value may access responses
+func (p corsPolicy) allows(origin string) bool {
+	origin = strings.ToLower(origin)
+	if p.exact[origin] {
+		return true
+	}
+	if len(p.wildcards) == 0 {
+		return false
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	host := u.Hostname()
+	for _, w := range p.wildcards {
+		if (w.scheme == "" || w.scheme == u.Scheme) && strings.HasSuffix(host, w.suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Headers allowed in requests unless a preflight asks for others
+const corsAllowHeaders = "Content-Type, X-Request-ID"
+
+// Add CORS headers for allowed origins and answer preflight requests.
+// Requests skip reports, the admin routes, get neither; browsers have no
+// business calling them, and their handlers answer OPTIONS themselves.
+func withCORS(current func() *corsPolicy, skip func(*http.Request) bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if skip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		policy := current()
+		h := w.Header()
+		origin := r.Header.Get("Origin")
+
+		// The headers depend on these request headers, so shared caches
+		// must key on them
+		h.Add("Vary", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers")
+
+		switch {
+		case policy.allowAll:
+			h.Set("Access-Control-Allow-Origin", "*")
+		case origin != "" && policy.allows(origin):
+			h.Set("Access-Control-Allow-Origin", origin)
+			if policy.credentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+
+		if h.Get("Access-Control-Allow-Origin") != "" {
+			h.Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+			h.Set("Access-Control-Allow-Headers", allowedRequestHeaders(r.Header.Get("Access-Control-Request-Headers")))
+			h.Set("Access-Control-Expose-Headers", "X-Request-ID")
+		}
+
+		if r.Method == http.MethodOptions {
+			h.Set("Allow", "GET, HEAD, OPTIONS")
+			if h.Get("Access-Control-Allow-Origin") != "" {
+				h.Set("Access-Control-Max-Age", policy.maxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Headers to allow in answer to a preflight's Access-Control-Request-Headers:
+// the requested ones if they are all well-formed, the defaults otherwise
+func allowedRequestHeaders(requested string) string {
+	if requested == "" {
+		return corsAllowHeaders
+	}
+	names := splitList(requested)
+	for _, name := range names {
+		if !validHeaderName(name) {
+			return corsAllowHeaders
+		}
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,101 @@
+package ksk
+
+import (
+	"bytes"
+	"encoding/csv"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Column order of the CSV export; keep it stable for spreadsheets
+var csvColumns = []string{"id", "title", "start", "end", "venue", "genres", "accessibility", "description"}
+
+// Byte order mark that makes Excel read the export as UTF-8
+const utf8BOM = "\xef\xbb\xbf"
+
+// Handle /events.csv, the events list flattened for spreadsheets. It takes
+// the same from/to and genre filters as /events; ?bom=1 prefixes a UTF-8
+// byte order mark for Excel.
+func (s *Server) csvHandler(policy cachePolicy) http.HandlerFunc {
+	upstream := s.cfg.UpstreamURL + eventsPath
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isRead(r) {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		query := r.URL.Query()
+		filter, err := parseEventFilter(query, s.cfg.AccessFeatures)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_parameter", err.Error())
+			return
+		}
+		// Spreadsheets get the whole selection
+		filter.paginate = false
+		bom := query.Get("bom") == "1"
+
+		name := "csv"
+		if key := filter.key(); key != "" {
+			name += "&" + key
+		}
+		if bom {
+			name += "&bom"
+		}
+
+		w.Header().Set("Content-Disposition", `attachment; filename="events-`+time.Now().In(berlin).Format(time.DateOnly)+`.csv"`)
+		s.serveDerived(w, r, upstream, policy, derivedView{name: name, ttl: filter.ttl(), build: func(source cacheEntry) ([]byte, string, error) {
+			events, err := decodeEvents(source.data)
+			if err != nil {
+				return nil, "", err
+			}
+			var kept []event
+			for _, ev := range events {
+				if filter.match(ev) {
+					kept = append(kept, ev)
+				}
+			}
+			body, err := buildCSV(kept, bom)
+			return body, "text/csv; charset=utf-8", err
+		}})
+	}
+}
+
+// Write events as CSV rows under a header of csvColumns
+func buildCSV(events []event, bom bool) ([]byte, error) {
+	var buf bytes.Buffer
+	if bom {
+		buf.WriteString(utf8BOM)
+	}
+
+	cw := csv.NewWriter(&buf)
+	cw.UseCRLF = true
+	cw.Write(csvColumns)
+	for _, ev := range events {
+		cw.Write([]string{
+			ev.ID,
+			ev.Title,
+			csvTime(ev.Start, ev.AllDay),
+			csvTime(ev.End, ev.AllDay),
+			ev.Venue,
+			strings.Join(ev.Genres, ";"),
+			strings.Join(ev.Accessibility, ";"),
+			ev.Description,
+		})
+	}
+	cw.Flush()
+	return buf.Bytes(), cw.Error()
+}
+
+// Berlin local time in a format spreadsheets recognize, empty if unset
+func csvTime(t time.Time, dateOnly bool) string {
+	switch {
+	case t.IsZero():
+		return ""
+	case dateOnly:
+		return t.In(berlin).Format(time.DateOnly)
+	default:
+		return t.In(berlin).Format("2006-01-02 15:04")
+	}
+}
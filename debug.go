@@ -0,0 +1,184 @@
+package ksk
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Extra time granted beyond the requested duration of CPU profiles and
+// traces, which usually outlast the server's WriteTimeout
+const profileWriteSlack = 10 * time.Second
+
+// Runtime state reported by /debug/vars
+type debugVars struct {
+	Goroutines int        `json:"goroutines"`
+	Heap       heapVars   `json:"heap"`
+	GC         gcVars     `json:"gc"`
+	Cache      cacheStats `json:"cache"`
+}
+
+type heapVars struct {
+	Alloc    uint64 `json:"alloc_bytes"`
+	InUse    uint64 `json:"inuse_bytes"`
+	Idle     uint64 `json:"idle_bytes"`
+	Released uint64 `json:"released_bytes"`
+	Objects  uint64 `json:"objects"`
+	Sys      uint64 `json:"sys_bytes"`
+}
+
+type gcVars struct {
+	Count      uint32 `json:"count"`
+	PauseTotal string `json:"pause_total"`
+	// Most recent pauses, newest first
+	RecentPauses []string   `json:"recent_pauses"`
+	Last         *time.Time `json:"last,omitempty"`
+}
+
+// Register pprof and /debug/vars behind the admin checks. The pprof
+// endpoints are served by the gateway itself, as importing net/http/pprof
+// would register them on http.DefaultServeMux of the embedding program.
+// They serve what go tool pprof needs; there is no /debug/pprof/symbol,
+// which only legacy profiles without symbols use.
+func (s *Server) debugRoutes() {
+	s.handleAdmin("/debug/vars", s.debugVarsHandler)
+	s.handleAdmin("/debug/pprof/", pprofIndex)
+	s.handleAdmin("/debug/pprof/cmdline", pprofCmdline)
+	s.handleAdmin("/debug/pprof/profile", pprofCPU)
+	s.handleAdmin("/debug/pprof/trace", pprofTrace)
+}
+
+// Duration of a CPU profile or trace from ?seconds=, 30 by default as in
+// net/http/pprof. The write deadline is extended to cover it.
+func profileDuration(w http.ResponseWriter, r *http.Request) time.Duration {
+	seconds, err := strconv.Atoi(r.URL.Query().Get("seconds"))
+	if err != nil || seconds <= 0 {
+		seconds = 30
+	}
+	d := time.Duration(seconds) * time.Second
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(d + profileWriteSlack))
+	return d
+}
+
+// Wait for d or until the client goes away
+func sleepProfile(r *http.Request, d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-r.Context().Done():
+	}
+}
+
+// GET /debug/pprof/ lists the runtime's profiles; /debug/pprof/<name>
+// writes one, as text with ?debug=1 or 2
+func pprofIndex(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/debug/pprof/")
+	if name == "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, p := range pprof.Profiles() {
+			fmt.Fprintf(w, "%d\t%s\n", p.Count(), p.Name())
+		}
+		fmt.Fprint(w, "\tprofile\n\ttrace\n")
+		return
+	}
+
+	profile := pprof.Lookup(name)
+	if profile == nil {
+		writeError(w, r, http.StatusNotFound, "not_found", "Unknown profile: "+name)
+		return
+	}
+	debug, _ := strconv.Atoi(r.URL.Query().Get("debug"))
+	if name == "heap" && r.URL.Query().Get("gc") != "" {
+		runtime.GC()
+	}
+	if debug > 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	}
+	if err := profile.WriteTo(w, debug); err != nil {
+		log.Printf("Writing the %s profile failed: %v [request %s]", name, err, requestIDFrom(r.Context()))
+	}
+}
+
+// GET /debug/pprof/cmdline returns the command line, arguments separated
+// by NUL bytes
+func pprofCmdline(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, strings.Join(os.Args, "\x00"))
+}
+
+// GET /debug/pprof/profile?seconds=30 records a CPU profile
+func pprofCPU(w http.ResponseWriter, r *http.Request) {
+	d := profileDuration(w, r)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="profile"`)
+	if err := pprof.StartCPUProfile(w); err != nil {
+		// Only one CPU profile can run at a time
+		w.Header().Del("Content-Disposition")
+		writeError(w, r, http.StatusConflict, "profile_running", "Could not start the CPU profile: "+err.Error())
+		return
+	}
+	sleepProfile(r, d)
+	pprof.StopCPUProfile()
+}
+
+// GET /debug/pprof/trace?seconds=30 records an execution trace
+func pprofTrace(w http.ResponseWriter, r *http.Request) {
+	d := profileDuration(w, r)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="trace"`)
+	if err := trace.Start(w); err != nil {
+		w.Header().Del("Content-Disposition")
+		writeError(w, r, http.StatusConflict, "profile_running", "Could not start the trace: "+err.Error())
+		return
+	}
+	sleepProfile(r, d)
+	trace.Stop()
+}
+
+// GET /debug/vars reports goroutines, heap, GC pauses and cache size
+func (s *Server) debugVarsHandler(w http.ResponseWriter, r *http.Request) {
+	if !isRead(r) {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	gc := gcVars{
+		Count:      mem.NumGC,
+		PauseTotal: time.Duration(mem.PauseTotalNs).String(),
+	}
+	// PauseNs is a ring buffer with the latest pause at (NumGC+255)%256
+	for i := uint32(0); i < min(mem.NumGC, 10); i++ {
+		pause := mem.PauseNs[(mem.NumGC-1-i)%uint32(len(mem.PauseNs))]
+		gc.RecentPauses = append(gc.RecentPauses, time.Duration(pause).String())
+	}
+	if mem.LastGC != 0 {
+		last := time.Unix(0, int64(mem.LastGC)).UTC()
+		gc.Last = &last
+	}
+
+	writeJSON(w, http.StatusOK, debugVars{
+		Goroutines: runtime.NumGoroutine(),
+		Heap: heapVars{
+			Alloc:    mem.HeapAlloc,
+			InUse:    mem.HeapInuse,
+			Idle:     mem.HeapIdle,
+			Released: mem.HeapReleased,
+			Objects:  mem.HeapObjects,
+			Sys:      mem.Sys,
+		},
+		GC:    gc,
+		Cache: s.cache.Stats(),
+	})
+}
@@ -0,0 +1,74 @@
+package ksk
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// Builds another representation of a cached upstream body and returns it
+// with its media type
+type deriveFunc func(source cacheEntry) (body []byte, contentType string, err error)
+
+// Representation of a cached upstream response, such as the ICS feed of
+// the events list
+type derivedView struct {
+	// Distinguishes the view's cache entries from others of the same source
+	name  string
+	build deriveFunc
+	// How long a built view stays fresh, for views that depend on the
+	// current time; 0 shares the source's freshness
+	ttl time.Duration
+}
+
+// Serve a view of a cached upstream response. It is cached under its own
+// key that includes the source's ETag, so it is built once per upstream
+// version (and TTL, if the view has one).
+func (s *Server) serveDerived(w http.ResponseWriter, r *http.Request, upstream string, policy cachePolicy, view derivedView) {
+	upstream = s.localize(r, upstream)
+	source, status, ok := s.resolveOrFail(w, r, upstream, policy, nil)
+	if !ok {
+		return
+	}
+
+	entry, err := s.derived(upstream, view, source)
+	if err != nil {
+		log.Printf("Building %s of %s failed: %v [request %s]", view.name, upstream, err, requestIDFrom(r.Context()))
+		writeError(w, r, http.StatusBadGateway, "processing_failed", "Failed to process upstream response")
+		return
+	}
+	s.writeResolved(w, r, entry, status)
+}
+
+// Return the cached view of source, building and caching it if needed
+func (s *Server) derived(upstream string, view derivedView, source cacheEntry) (cacheEntry, error) {
+	key := parseCacheKey(upstream).withVariant(view.name + "@" + source.etag).String()
+
+	entry, ok := s.cache.Get(key)
+	if ok && view.ttl > 0 && !time.Now().Before(entry.until) {
+		ok = false
+	}
+	if !ok {
+		body, contentType, err := view.build(source)
+		if err != nil {
+			return cacheEntry{}, err
+		}
+		entry = cacheEntry{
+			data:        body,
+			gzipped:     compressBody(body),
+			stored:      time.Now(),
+			until:       time.Now().Add(view.ttl),
+			etag:        computeETag(body),
+			contentType: contentType,
+		}
+	}
+
+	// A revalidated source keeps its ETag but gets a new expiry
+	if view.ttl == 0 {
+		entry.stored, entry.until = source.stored, source.until
+	}
+	if !ok {
+		s.store(key, entry)
+	}
+	return entry, nil
+}
@@ -0,0 +1,21 @@
+// Package ksk is the calendar API gateway: it caches the upstream
+// calendar API and serves it along with derived views such as feeds,
+// search and exports. The ksk-gateway command runs it as a standalone server.
+//
+// Other Go services can mount the gateway instead of running a second
+// process. Each Server has its own cache, upstream client and metrics and
+// registers nothing globally, so several can be mounted side by side:
+//
+//	cfg := ksk.DefaultConfig()
+//	cfg.UpstreamURL = "https://calendar.example.org/api"
+//	cfg.PathPrefix = "/calendar"
+//	gateway := ksk.New(cfg)
+//	go gateway.Run(ctx)
+//
+//	mux := http.NewServeMux()
+//	mux.Handle("/calendar/", gateway.Handler())
+//
+// The handler expects the full request path, prefix included, so it is
+// mounted without http.StripPrefix. Routers such as chi mount it the same
+// way, with r.Mount("/calendar", gateway.Handler()).
+package ksk
@@ -0,0 +1,76 @@
+package ksk
+
+import (
+	"cmp"
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// NewHandler builds a gateway for cfg and returns its HTTP handler, for
+// services that mount the API on their own mux. Nothing is registered on
+// http.DefaultServeMux, and each call has its own cache, upstream client
+// and metrics. The handler serves without background work; use New and
+// Server.Run to also restore, warm and sweep the cache and prefetch.
+func NewHandler(cfg Config) http.Handler {
+	return New(cfg).Handler()
+}
+
+// Run does what ListenAndServe does besides serving, for a gateway whose
+// handler is served by another server: it restores and warms the cache,
+// then runs prefetching, cache sweeps and webhook deliveries until ctx is
+// cancelled. On return it has ended the open event streams, saved the
+// cache snapshot and exported the last spans. Unlike ListenAndServe it
+// leaves signals to the embedding program, so nothing reloads on SIGHUP.
+func (s *Server) Run(ctx context.Context) error {
+	if err := s.prepareFixtures(); err != nil {
+		return err
+	}
+	s.loadSnapshot()
+	if s.cfg.WarmTimeout > 0 && !s.offline() {
+		s.warmCache(s.statics, s.cfg.WarmTimeout)
+	}
+
+	var background sync.WaitGroup
+	s.startBackground(ctx, &background)
+	<-ctx.Done()
+
+	// The embedding server's shutdown would wait for streams to end
+	s.streams.close()
+	background.Wait()
+	s.saveSnapshot()
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), tracingFlushTimeout)
+	defer cancel()
+	if err := s.tracing.shutdown(flushCtx); err != nil {
+		log.Printf("Exporting the last spans failed: %v", err)
+	}
+	return nil
+}
+
+// Serve next under prefix, passing it paths with the prefix removed.
+// Unlike http.StripPrefix, /calendar2 does not match the prefix /calendar.
+func withPathPrefix(prefix string, next http.Handler) http.Handler {
+	if prefix == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, ok := strings.CutPrefix(r.URL.Path, prefix)
+		if !ok || (path != "" && path[0] != '/') {
+			http.NotFound(w, r)
+			return
+		}
+		rawPath, _ := strings.CutPrefix(r.URL.RawPath, prefix)
+
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = cmp.Or(path, "/")
+		r2.URL.RawPath = rawPath
+		next.ServeHTTP(w, r2)
+	})
+}
@@ -0,0 +1,267 @@
+package ksk
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 500
+
+	// How long a view of upcoming events stays fresh; events drop out of
+	// it as they end
+	upcomingTTL = time.Minute
+)
+
+// Narrowing and pagination of the events list, applied by the gateway on
+// the cached upstream body rather than by the upstream
+type eventFilter struct {
+	// Inclusive range of start dates as YYYY-MM-DD, empty for open ends
+	from, to string
+	// Keep events in any of these genres; sorted and without duplicates
+	genres []string
+	// Keep events offering all of these accessibility features; sorted and
+	// without duplicates
+	access []string
+	// Keep only events that have not ended by now, for ?show_past=false
+	upcoming bool
+	now      time.Time
+	// Return the window of limit events from offset in an eventPage
+	paginate      bool
+	limit, offset int
+}
+
+// Paginated events list
+type eventPage struct {
+	Total  int             `json:"total"`
+	Limit  int             `json:"limit"`
+	Offset int             `json:"offset"`
+	Items  json.RawMessage `json:"items"`
+}
+
+// Parse the filter parameters of an events list request. ?access= takes
+// the names in features.
+func parseEventFilter(query url.Values, features []string) (eventFilter, error) {
+	var f eventFilter
+	var err error
+	if f.from, err = dateParam(query, "from"); err != nil {
+		return f, err
+	}
+	if f.to, err = dateParam(query, "to"); err != nil {
+		return f, err
+	}
+
+	for _, id := range query["genre"] {
+		if id = strings.TrimSpace(id); id != "" {
+			f.genres = append(f.genres, id)
+		}
+	}
+	slices.Sort(f.genres)
+	f.genres = slices.Compact(f.genres)
+
+	for _, v := range query["access"] {
+		for _, name := range splitList(v) {
+			if !slices.Contains(features, name) {
+				return f, fmt.Errorf("Unknown access feature %q, expected any of: %s", name, strings.Join(features, ", "))
+			}
+			f.access = append(f.access, name)
+		}
+	}
+	slices.Sort(f.access)
+	f.access = slices.Compact(f.access)
+
+	if v := query.Get("show_past"); v != "" {
+		showPast, err := strconv.ParseBool(v)
+		if err != nil {
+			return f, fmt.Errorf("Invalid show_past %q, expected true or false", v)
+		}
+		f.upcoming, f.now = !showPast, time.Now()
+	}
+
+	return f, f.parsePage(query)
+}
+
+// Validate an optional YYYY-MM-DD query parameter
+func dateParam(query url.Values, name string) (string, error) {
+	v := query.Get(name)
+	if v == "" {
+		return "", nil
+	}
+	if _, err := time.Parse(time.DateOnly, v); err != nil {
+		return "", fmt.Errorf("Invalid %s date %q, expected YYYY-MM-DD", name, v)
+	}
+	return v, nil
+}
+
+// Parse ?limit=&offset= or ?page=&per_page= (pages start at 1)
+func (f *eventFilter) parsePage(query url.Values) error {
+	var limit, offset, page, perPage int
+	for _, p := range []struct {
+		name string
+		dst  *int
+		min  int
+	}{{"limit", &limit, 1}, {"offset", &offset, 0}, {"page", &page, 1}, {"per_page", &perPage, 1}} {
+		v := query.Get(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < p.min {
+			return fmt.Errorf("Invalid %s %q, expected an integer of at least %d", p.name, v, p.min)
+		}
+		*p.dst = n
+		f.paginate = true
+	}
+	if !f.paginate {
+		return nil
+	}
+
+	if perPage > 0 || page > 0 {
+		if limit > 0 || offset > 0 {
+			return fmt.Errorf("Use either limit/offset or page/per_page")
+		}
+		limit = perPage
+	}
+	if limit == 0 {
+		limit = defaultPageLimit
+	}
+	f.limit = min(limit, maxPageLimit)
+	f.offset = offset
+	if page > 0 {
+		// Clamped so the product cannot overflow
+		f.offset = (min(page, math.MaxInt32) - 1) * f.limit
+	}
+	return nil
+}
+
+// Report whether the filter keeps every event
+func (f eventFilter) empty() bool {
+	return f.from == "" && f.to == "" && len(f.genres) == 0 && len(f.access) == 0 && !f.upcoming && !f.paginate
+}
+
+// Freshness of views built with the filter; see derivedView.ttl
+func (f eventFilter) ttl() time.Duration {
+	if f.upcoming {
+		return upcomingTTL
+	}
+	return 0
+}
+
+// Cache view name identifying the filter; equivalent filters share it
+func (f eventFilter) key() string {
+	var parts []string
+	if f.from != "" || f.to != "" {
+		parts = append(parts, "range="+f.from+".."+f.to)
+	}
+	if len(f.genres) > 0 {
+		parts = append(parts, "genre="+strings.Join(f.genres, ","))
+	}
+	if len(f.access) > 0 {
+		parts = append(parts, "access="+strings.Join(f.access, ","))
+	}
+	if f.upcoming {
+		parts = append(parts, "upcoming")
+	}
+	if f.paginate {
+		parts = append(parts, "page="+strconv.Itoa(f.offset)+"+"+strconv.Itoa(f.limit))
+	}
+	return strings.Join(parts, "&")
+}
+
+func (f eventFilter) match(ev event) bool {
+	if f.upcoming && eventEnded(ev, f.now) {
+		return false
+	}
+	if f.from != "" || f.to != "" {
+		if ev.Start.IsZero() {
+			return false
+		}
+		// Dates compare correctly as strings
+		day := ev.Start.In(berlin).Format(time.DateOnly)
+		if (f.from != "" && day < f.from) || (f.to != "" && day > f.to) {
+			return false
+		}
+	}
+	if len(f.genres) > 0 && !slices.ContainsFunc(ev.Genres, func(id string) bool {
+		_, found := slices.BinarySearch(f.genres, id)
+		return found
+	}) {
+		return false
+	}
+	// Events without accessibility data offer nothing
+	for _, name := range f.access {
+		if !slices.Contains(ev.Accessibility, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// Report whether an event is over at now. Running events are not, nor are
+// all-day events on their last day. Without an end, events last until the
+// end of the day they start on in Berlin; without a start either, they
+// are never over.
+func eventEnded(ev event, now time.Time) bool {
+	end := ev.End
+	if end.IsZero() {
+		end = ev.Start
+	}
+	if end.IsZero() {
+		return false
+	}
+	if ev.AllDay || ev.End.IsZero() {
+		y, m, d := end.In(berlin).Date()
+		end = time.Date(y, m, d+1, 0, 0, 0, 0, berlin)
+	}
+	return !now.Before(end)
+}
+
+// Build the filtered list from the cached events; see deriveFunc
+func (f eventFilter) apply(source cacheEntry) ([]byte, string, error) {
+	events, err := decodeEvents(source.data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var kept []event
+	for _, ev := range events {
+		if f.match(ev) {
+			kept = append(kept, ev)
+		}
+	}
+	if !f.paginate {
+		return encodeEvents(kept), "", nil
+	}
+
+	lo := min(f.offset, len(kept))
+	hi := min(lo+f.limit, len(kept))
+	body, err := json.Marshal(eventPage{
+		Total:  len(kept),
+		Limit:  f.limit,
+		Offset: f.offset,
+		Items:  encodeEvents(kept[lo:hi]),
+	})
+	return body, "", err
+}
+
+// Encode events as a JSON array of their upstream objects, "[]" if empty
+func encodeEvents(events []event) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, ev := range events {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(ev.raw)
+	}
+	buf.WriteByte(']')
+	return buf.Bytes()
+}
@@ -0,0 +1,94 @@
+package ksk
+
+import (
+	"log"
+	"time"
+)
+
+// Events of the cached events list by ID, so detail requests for listed
+// events need no upstream call, and by venue ID. It is replaced as a whole
+// whenever the list is refilled and only used while the list entry is
+// fresh.
+type eventIndex struct {
+	etag   string
+	stored time.Time
+	until  time.Time
+	byID   map[string]cacheEntry
+	// Sorted by start time, see groupByVenue
+	byVenue map[string][]event
+}
+
+// Rebuild the ID, venue and search indexes from a freshly stored events
+// list
+func (s *Server) indexEvents(list cacheEntry) {
+	// A revalidated list only needs its new expiry
+	if cur := s.index.Load(); cur != nil && cur.etag == list.etag && s.search.currentETag() == list.etag {
+		next := *cur
+		next.stored, next.until = list.stored, list.until
+		s.index.Store(&next)
+		s.search.touch(list)
+		return
+	}
+
+	events, unparsed, err := decodeEventList(list.data)
+	if err != nil {
+		log.Printf("Indexing the events list failed: %v", err)
+		s.index.Store(nil)
+		return
+	}
+	// Logged once per list version, not by every view built from it
+	for _, err := range unparsed {
+		log.Printf("Leaving the time out of views that need it: %v", err)
+	}
+	s.changes.record(list.etag, events)
+	s.search.rebuild(list, events)
+
+	idx := &eventIndex{
+		etag:    list.etag,
+		stored:  list.stored,
+		until:   list.until,
+		byID:    make(map[string]cacheEntry, len(events)),
+		byVenue: groupByVenue(events),
+	}
+	for _, ev := range events {
+		if ev.ID == "" {
+			continue
+		}
+		idx.byID[ev.ID] = cacheEntry{
+			data:            ev.raw,
+			gzipped:         compressBody(ev.raw),
+			etag:            computeETag(ev.raw),
+			contentType:     list.contentType,
+			contentLanguage: list.contentLanguage,
+		}
+	}
+	s.index.Store(idx)
+}
+
+// Look up an event in the index. Entries share the list's freshness.
+func (s *Server) indexedEvent(id string) (cacheEntry, bool) {
+	idx := s.index.Load()
+	if idx == nil || !time.Now().Before(idx.until) {
+		// Another replica may have filled a shared cache
+		list, ok := s.cache.Get(s.cfg.UpstreamURL + eventsPath)
+		if !ok || list.notFound || !time.Now().Before(list.until) {
+			return cacheEntry{}, false
+		}
+		s.indexEvents(list)
+		if idx = s.index.Load(); idx == nil {
+			return cacheEntry{}, false
+		}
+	}
+	entry, ok := idx.byID[id]
+	if !ok {
+		return cacheEntry{}, false
+	}
+	entry.stored, entry.until = idx.stored, idx.until
+	return entry, true
+}
+
+// Drop the indexes together with the events list entry
+func (s *Server) invalidateEventIndex() {
+	s.index.Store(nil)
+	s.search.clear()
+}
@@ -0,0 +1,275 @@
+package ksk
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // Europe/Berlin must resolve in minimal containers
+)
+
+// The calendar's local time zone, assumed for upstream times without offset
+var berlin = mustLoadLocation("Europe/Berlin")
+
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}
+
+// Fields of an upstream event the gateway looks at. Handlers that return
+// events pass raw through, so fields unknown to the gateway are kept.
+type event struct {
+	raw json.RawMessage
+
+	ID          string
+	Title       string
+	Description string
+	Venue       string
+	// Upstream ID and coordinates of the venue, if it sent them
+	VenueID       string
+	VenueLocation *geoPoint
+	Genres        []string
+	// Names of the accessibility features the event offers
+	Accessibility []string
+	// Zero if the upstream did not send one
+	Start time.Time
+	End   time.Time
+	// Start and end are dates without a time of day
+	AllDay bool
+	// Last change upstream, zero if the upstream did not send it
+	Updated time.Time
+}
+
+// JSON shape of an upstream event
+type eventFields struct {
+	ID          flexString `json:"id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Start       string     `json:"start"`
+	End         string     `json:"end"`
+	UpdatedAt   string     `json:"updated_at"`
+	Venue       venueField `json:"venue"`
+	Genres      genreIDs   `json:"genres"`
+	// Accessibility flags as an object of booleans or a list of names
+	Accessibility accessibilityFlags `json:"accessibility"`
+}
+
+// String that the upstream sends either quoted or as a number
+type flexString string
+
+func (f *flexString) UnmarshalJSON(b []byte) error {
+	if bytes.Equal(b, []byte("null")) {
+		return nil
+	}
+	if len(b) > 0 && b[0] == '"' {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		*f = flexString(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return err
+	}
+	*f = flexString(n)
+	return nil
+}
+
+// Venue given as a plain name or as an object with name and address, and
+// optionally its ID and coordinates
+type venueField struct {
+	name     string
+	id       string
+	location *geoPoint
+}
+
+// WGS 84 coordinates in degrees
+type geoPoint struct {
+	Lat float64
+	Lon float64
+}
+
+func (v *venueField) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		return json.Unmarshal(b, &v.name)
+	}
+
+	var obj struct {
+		ID        flexString `json:"id"`
+		Name      string     `json:"name"`
+		Address   string     `json:"address"`
+		Lat       flexString `json:"lat"`
+		Latitude  flexString `json:"latitude"`
+		Lon       flexString `json:"lon"`
+		Lng       flexString `json:"lng"`
+		Longitude flexString `json:"longitude"`
+	}
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return err
+	}
+	v.name = strings.Trim(obj.Name+", "+obj.Address, ", ")
+	v.id = string(obj.ID)
+	v.location = parseGeoPoint(cmp.Or(obj.Lat, obj.Latitude), cmp.Or(obj.Lon, obj.Lng, obj.Longitude))
+	return nil
+}
+
+// Coordinates from their decimal strings, nil if either is missing or out
+// of range. 0,0 is taken for a placeholder rather than a venue in the
+// Gulf of Guinea.
+func parseGeoPoint(lat, lon flexString) *geoPoint {
+	la, err := strconv.ParseFloat(string(lat), 64)
+	if err != nil || la < -90 || la > 90 {
+		return nil
+	}
+	lo, err := strconv.ParseFloat(string(lon), 64)
+	if err != nil || lo < -180 || lo > 180 {
+		return nil
+	}
+	if la == 0 && lo == 0 {
+		return nil
+	}
+	return &geoPoint{Lat: la, Lon: lo}
+}
+
+// Genres given as IDs or as objects with an id
+type genreIDs []string
+
+func (g *genreIDs) UnmarshalJSON(b []byte) error {
+	var items []json.RawMessage
+	if err := json.Unmarshal(b, &items); err != nil {
+		return err
+	}
+
+	ids := make(genreIDs, 0, len(items))
+	for _, item := range items {
+		var id flexString
+		if len(item) > 0 && item[0] == '{' {
+			var obj struct {
+				ID flexString `json:"id"`
+			}
+			if err := json.Unmarshal(item, &obj); err != nil {
+				return err
+			}
+			id = obj.ID
+		} else if err := json.Unmarshal(item, &id); err != nil {
+			return err
+		}
+		ids = append(ids, string(id))
+	}
+	*g = ids
+	return nil
+}
+
+// Accessibility features given as {"wheelchair": true, ...} or as a list
+// of names; decoded to the sorted names of the features offered
+type accessibilityFlags []string
+
+func (a *accessibilityFlags) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '[' {
+		var names []string
+		if err := json.Unmarshal(b, &names); err != nil {
+			return err
+		}
+		*a = names
+		return nil
+	}
+
+	var flags map[string]bool
+	if err := json.Unmarshal(b, &flags); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(flags))
+	for name, set := range flags {
+		if set {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	*a = names
+	return nil
+}
+
+// Decode the upstream events list, a JSON array of event objects. A start
+// or end that cannot be parsed is left zero rather than failing every view
+// of the list, so the event stays in the filters and counts but not in the
+// views that need its times. Fetches that normalize times count such
+// times in ksk_event_times_unparsed_total.
+func decodeEvents(data []byte) ([]event, error) {
+	events, _, err := decodeEventList(data)
+	return events, err
+}
+
+// decodeEvents, also reporting the times it left zero
+func decodeEventList(data []byte) (events []event, unparsed []error, err error) {
+	var raws []json.RawMessage
+	if err := json.Unmarshal(data, &raws); err != nil {
+		return nil, nil, fmt.Errorf("decode events: %w", err)
+	}
+
+	events = make([]event, 0, len(raws))
+	for i, raw := range raws {
+		var f eventFields
+		if err := json.Unmarshal(raw, &f); err != nil {
+			return nil, nil, fmt.Errorf("decode event %d: %w", i, err)
+		}
+
+		ev := event{
+			raw:           raw,
+			ID:            string(f.ID),
+			Title:         f.Title,
+			Description:   f.Description,
+			Venue:         f.Venue.name,
+			VenueID:       f.Venue.id,
+			VenueLocation: f.Venue.location,
+			Genres:        f.Genres,
+			Accessibility: f.Accessibility,
+		}
+		var err error
+		if ev.Start, ev.AllDay, err = parseEventTime(f.Start); err != nil {
+			unparsed = append(unparsed, fmt.Errorf("event %s: start: %w", ev.ID, err))
+		}
+		if ev.End, _, err = parseEventTime(f.End); err != nil {
+			unparsed = append(unparsed, fmt.Errorf("event %s: end: %w", ev.ID, err))
+		}
+		// Only the sitemap looks at it, so a malformed one is left out
+		// rather than failing the list
+		ev.Updated, _, _ = parseEventTime(f.UpdatedAt)
+		events = append(events, ev)
+	}
+	return events, unparsed, nil
+}
+
+// Layouts of upstream times; those without offset are Berlin local time
+var eventTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+}
+
+// Parse an upstream time, reporting whether it is a date without time of
+// day. Empty strings yield the zero time.
+func parseEventTime(s string) (t time.Time, dateOnly bool, err error) {
+	if s == "" {
+		return time.Time{}, false, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, berlin); err == nil {
+		return t, true, nil
+	}
+	for _, layout := range eventTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, berlin); err == nil {
+			return t, false, nil
+		}
+	}
+	return time.Time{}, false, fmt.Errorf("unrecognized time %q", s)
+}
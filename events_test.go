@@ -0,0 +1,74 @@
+package ksk
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// testEvents with a third event whose start cannot be parsed
+const testEventsWithBadTime = `[
+	{"id": 1, "title": "Hamlet", "start": "2030-03-01T19:30:00", "end": "2030-03-01T22:00:00", "venue": {"id": 10, "name": "Theater"}, "genres": [3]},
+	{"id": 2, "title": "Orgelnacht", "start": "2030-03-02", "venue": {"id": 11, "name": "Dom"}, "genres": [4]},
+	{"id": 5, "title": "Kaputt", "start": "next Friday", "venue": {"id": 10, "name": "Theater"}, "genres": [3]}
+]`
+
+func TestDecodeEventsKeepsUnparsedTimes(t *testing.T) {
+	events, unparsed, err := decodeEventList([]byte(testEventsWithBadTime))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 || events[2].ID != "5" || !events[2].Start.IsZero() {
+		t.Errorf("decoded %d events %+v, want event 5 kept without a start", len(events), events)
+	}
+	if len(unparsed) != 1 || !strings.Contains(unparsed[0].Error(), "event 5: start") {
+		t.Errorf("unparsed %v, want the start of event 5", unparsed)
+	}
+
+	if _, err := decodeEvents([]byte(`{"id": 1}`)); err == nil {
+		t.Error("no error for a list that is no array")
+	}
+}
+
+func TestViewsServeListWithUnparsedTime(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	upstream.bodies["/events"] = testEventsWithBadTime
+	h := newTestServer(t, upstream.URL, nil).Handler()
+
+	tests := []struct {
+		target string
+		listed bool
+	}{
+		{"/api/v1/events", true},
+		{"/api/v1/events?genre=3", true},
+		{"/api/v1/events?limit=10", true},
+		{"/api/v1/search?q=Kaputt", true},
+		{"/api/v1/venues/10/events", true},
+		{"/api/v1/events.csv", true},
+		// Those need a start
+		{"/api/v1/events?from=2030-03-01", false},
+		{"/api/v1/events/by-day?from=2030-03-01&to=2030-03-03", false},
+		{"/api/v1/events.ics", false},
+		{"/api/v1/events.rss", false},
+	}
+	for _, tt := range tests {
+		w := serve(h, http.MethodGet, tt.target, nil)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status %d, want 200", tt.target, w.Code)
+			continue
+		}
+		if listed := strings.Contains(w.Body.String(), "Kaputt"); listed != tt.listed {
+			t.Errorf("%s: lists the event without a usable start: %v, want %v", tt.target, listed, tt.listed)
+		}
+	}
+
+	w := serve(h, http.MethodGet, "/api/v1/events?genre=3&limit=10", nil)
+	var page eventPage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("paginated body %q: %v", w.Body, err)
+	}
+	if page.Total != 2 {
+		t.Errorf("total %d, want 2 with the event without a usable start", page.Total)
+	}
+}
@@ -0,0 +1,62 @@
+package ksk
+
+import "time"
+
+// Time normalization modes, see Config.EventTimes
+const (
+	eventTimesOff    = "off"
+	eventTimesOffset = "offset"
+	eventTimesUTC    = "utc"
+)
+
+// Keys of event objects holding a date or time
+var eventTimeFields = []string{"start", "end", "updated_at"}
+
+// Rewrite the times of a list of events or a single event as RFC 3339 with
+// an explicit offset, in Europe/Berlin or UTC, so clients need not guess
+// the zone of naive upstream times. Dates without a time of day stay as
+// they are, as do values that do not parse, which are counted.
+func normalizeEventTimes(data []byte, utc bool) ([]byte, int, error) {
+	doc, err := decodeJSONDocument(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	unparsed := 0
+	normalize := func(v any) {
+		ev, ok := v.(map[string]any)
+		if !ok {
+			return
+		}
+		for _, key := range eventTimeFields {
+			s, ok := ev[key].(string)
+			if !ok || s == "" {
+				continue
+			}
+			t, dateOnly, err := parseEventTime(s)
+			switch {
+			case err != nil:
+				unparsed++
+			case dateOnly:
+			case utc:
+				ev[key] = t.UTC().Format(time.RFC3339Nano)
+			default:
+				ev[key] = t.In(berlin).Format(time.RFC3339Nano)
+			}
+		}
+	}
+	if list, ok := doc.([]any); ok {
+		for _, ev := range list {
+			normalize(ev)
+		}
+	} else {
+		normalize(doc)
+	}
+
+	out, err := encodeJSONDocument(doc)
+	return out, unparsed, err
+}
+
+// Report whether bodies fetched with policy get normalized times
+func (s *Server) normalizesTimes(policy cachePolicy) bool {
+	return s.cfg.EventTimes != eventTimesOff && policy.normalizeTimes && !policy.raw
+}
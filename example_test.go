@@ -0,0 +1,54 @@
+package ksk_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	ksk "github.com/Kulturleben/go-ksk"
+)
+
+func ExampleNewHandler() {
+	// Stand-in for the calendar API
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `[{"id": 1, "name": "Theater"}]`)
+	}))
+	defer upstream.Close()
+
+	cfg := ksk.DefaultConfig()
+	cfg.UpstreamURL = upstream.URL
+	cfg.PathPrefix = "/calendar"
+
+	mux := http.NewServeMux()
+	mux.Handle("/calendar/", ksk.NewHandler(cfg))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "the service's own page")
+	})
+
+	for _, target := range []string{"/calendar/api/v1/genres", "/calendar/api/v1/genres", "/"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		fmt.Println(w.Code, w.Header().Get("X-Cache"), w.Body.String())
+	}
+	// Output:
+	// 200 MISS [{"id":1,"name":"Theater"}]
+	// 200 HIT [{"id":1,"name":"Theater"}]
+	// 200  the service's own page
+}
+
+func ExampleServer_Run() {
+	cfg := ksk.DefaultConfig()
+	cfg.UpstreamURL = "https://calendar.example.org/api"
+	cfg.PathPrefix = "/calendar"
+	gateway := ksk.New(cfg)
+
+	mux := http.NewServeMux()
+	mux.Handle("/calendar/", gateway.Handler())
+
+	// Restore, warm and refresh the cache alongside the embedding server:
+	//
+	//	go gateway.Run(ctx)
+	//	http.ListenAndServe(":8080", mux)
+}
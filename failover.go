@@ -0,0 +1,81 @@
+package ksk
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// How long a fallback that answered keeps being tried first, sparing
+// requests the primary's timeout while it is down
+const preferFallbackFor = 30 * time.Second
+
+// Upstream base URLs, the primary first. Cache keys always use the
+// primary, so an entry does not depend on which mirror filled it.
+type upstreamPool struct {
+	bases []string
+
+	mu sync.Mutex
+	// Index of the fallback tried first until preferredUntil, 0 for none
+	preferred      int
+	preferredUntil time.Time
+}
+
+func newUpstreamPool(primary string, fallbacks []string) *upstreamPool {
+	return &upstreamPool{bases: append([]string{primary}, fallbacks...)}
+}
+
+// Base URLs in the order the next fetch tries them
+func (p *upstreamPool) order() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.preferred == 0 || !time.Now().Before(p.preferredUntil) {
+		p.preferred = 0
+		return p.bases
+	}
+	order := make([]string, 0, len(p.bases))
+	order = append(order, p.bases[p.preferred])
+	for i, base := range p.bases {
+		if i != p.preferred {
+			order = append(order, base)
+		}
+	}
+	return order
+}
+
+// Remember the base URL that answered a fetch
+func (p *upstreamPool) answered(base string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, b := range p.bases {
+		if b == base {
+			if i != p.preferred {
+				p.preferred = i
+				p.preferredUntil = time.Now().Add(preferFallbackFor)
+			}
+			return
+		}
+	}
+}
+
+// Fetch upstream, a URL below the primary base, from the first base URL
+// that is up. Connection errors, timeouts and 5xx move on to the next
+// one; any other outcome would be the same on every mirror.
+func (s *Server) fetchFailover(ctx context.Context, upstream string, policy cachePolicy, sink *streamSink) (entry cacheEntry, err error) {
+	bases := s.upstreams.order()
+	for i, base := range bases {
+		entry, err = s.fetchOnce(ctx, upstream, base, policy, sink)
+		if !upstreamUnhealthy(err) {
+			s.upstreams.answered(base)
+			return entry, err
+		}
+		if ctx.Err() != nil || i+1 == len(bases) {
+			break
+		}
+		log.Printf("Upstream %s failed: %v (failing over to %s)", base, err, bases[i+1])
+	}
+	return entry, err
+}
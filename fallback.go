@@ -0,0 +1,61 @@
+package ksk
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+// Largest fallback file accepted; they are meant to be small placeholders
+const maxFallbackSize = 1 << 20
+
+// Read the fallback files of Config.FallbackFiles by endpoint. Each must
+// hold a JSON document.
+func loadFallbacks(files map[string]string) (map[string][]byte, error) {
+	bodies := make(map[string][]byte, len(files))
+	for endpoint, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read %s fallback: %w", endpoint, err)
+		}
+		if len(b) > maxFallbackSize {
+			return nil, fmt.Errorf("%s fallback %s: larger than %d bytes", endpoint, file, maxFallbackSize)
+		}
+		if err := validateJSONResponse("", b); err != nil {
+			return nil, fmt.Errorf("%s fallback %s: %w", endpoint, file, err)
+		}
+		bodies[endpoint] = compactJSON(b)
+	}
+	return bodies, nil
+}
+
+// Cache entries of fallback bodies, ready to be written
+func fallbackEntries(bodies map[string][]byte) map[string]cacheEntry {
+	entries := make(map[string]cacheEntry, len(bodies))
+	for endpoint, body := range bodies {
+		entries[endpoint] = cacheEntry{
+			data:    body,
+			gzipped: compressBody(body),
+			etag:    computeETag(body),
+			// Clients should not keep the placeholder once the upstream is
+			// back
+			private: true,
+		}
+	}
+	return entries
+}
+
+// Answer a request whose upstream fetch failed, with nothing cached to
+// fall back on, with the endpoint's fallback body and X-Cache: FALLBACK.
+// It reports false if there is none.
+func (s *Server) serveFallback(w http.ResponseWriter, r *http.Request, policy cachePolicy, err error) bool {
+	entry, ok := s.live.Load().fallbacks[policy.endpoint]
+	if !ok {
+		return false
+	}
+	s.stats.counters(policy).fallbacks.Add(1)
+	log.Printf("Serving the %s fallback for %s: %v [request %s]", policy.endpoint, r.URL.Path, err, requestIDFrom(r.Context()))
+	s.writeResolved(w, r, entry, "FALLBACK")
+	return true
+}
@@ -0,0 +1,113 @@
+package ksk
+
+import (
+	"encoding/xml"
+	"html"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// RSS 2.0 document
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	Language      string    `xml:"language"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link,omitempty"`
+	Description string  `xml:"description,omitempty"`
+	PubDate     string  `xml:"pubDate"`
+	GUID        rssGUID `xml:"guid"`
+}
+
+type rssGUID struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+// Handle /events.rss, the next upcoming events as an RSS feed. The feed
+// depends on the current time, so it is cached with its own TTL.
+func (s *Server) rssHandler(policy cachePolicy) http.HandlerFunc {
+	build := func(source cacheEntry) ([]byte, string, error) {
+		events, err := decodeEvents(source.data)
+		if err != nil {
+			return nil, "", err
+		}
+		body, err := s.buildRSS(events, time.Now())
+		return body, "application/rss+xml; charset=utf-8", err
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isRead(r) {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+		// The feed TTL may be reloaded
+		view := derivedView{name: "rss", ttl: s.live.Load().ttls["feed"], build: build}
+		s.serveDerived(w, r, s.cfg.UpstreamURL+eventsPath, policy, view)
+	}
+}
+
+// Render the first FeedLimit events starting after now, soonest first
+func (s *Server) buildRSS(events []event, now time.Time) ([]byte, error) {
+	var upcoming []event
+	for _, ev := range events {
+		if !ev.Start.IsZero() && ev.Start.After(now) {
+			upcoming = append(upcoming, ev)
+		}
+	}
+	slices.SortStableFunc(upcoming, func(a, b event) int { return a.Start.Compare(b.Start) })
+	upcoming = upcoming[:min(len(upcoming), s.cfg.FeedLimit)]
+
+	link := s.cfg.FeedLinkBase
+	if link == "" {
+		link = s.cfg.UpstreamURL
+	}
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         "Upcoming events",
+			Link:          link,
+			Description:   "The next upcoming events of the calendar",
+			Language:      "de",
+			LastBuildDate: now.UTC().Format(time.RFC1123Z),
+			Items:         make([]rssItem, 0, len(upcoming)),
+		},
+	}
+	for _, ev := range upcoming {
+		item := rssItem{
+			Title:       ev.Title,
+			Description: htmlText(ev.Description),
+			PubDate:     ev.Start.Format(time.RFC1123Z),
+			GUID:        rssGUID{Value: "event-" + ev.ID},
+		}
+		if s.cfg.FeedLinkBase != "" {
+			item.Link = s.cfg.FeedLinkBase + ev.ID
+		}
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+
+	body, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+// Plain text as an HTML fragment for RSS descriptions, keeping line breaks
+func htmlText(s string) string {
+	return strings.ReplaceAll(html.EscapeString(s), "\n", "<br>\n")
+}
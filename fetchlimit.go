@@ -0,0 +1,69 @@
+package ksk
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// The gateway has too many upstream requests in flight. The upstream is
+// not at fault, so it neither trips the breaker nor is remembered.
+var errUpstreamBusy = errors.New("Too many upstream requests")
+
+// Semaphore bounding the upstream requests in flight, so a cold cache
+// does not open a connection per distinct miss. Cache hits never take a
+// slot.
+type fetchLimiter struct {
+	// nil for no limit
+	slots   chan struct{}
+	timeout time.Duration
+	// Requests in flight, counted with or without a limit
+	inFlight atomic.Int64
+	// Fetches that gave up waiting for a slot
+	rejected atomic.Uint64
+}
+
+// Limiter of limit concurrent requests, 0 for no limit
+func newFetchLimiter(limit int, timeout time.Duration) *fetchLimiter {
+	l := &fetchLimiter{timeout: timeout}
+	if limit > 0 {
+		l.slots = make(chan struct{}, limit)
+	}
+	return l
+}
+
+// Take a slot, waiting at most the queue timeout for one
+func (l *fetchLimiter) acquire(ctx context.Context) error {
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			if err := l.wait(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	l.inFlight.Add(1)
+	return nil
+}
+
+func (l *fetchLimiter) wait(ctx context.Context) error {
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	l.rejected.Add(1)
+	return errUpstreamBusy
+}
+
+func (l *fetchLimiter) release() {
+	l.inFlight.Add(-1)
+	if l.slots != nil {
+		<-l.slots
+	}
+}
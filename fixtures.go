@@ -0,0 +1,104 @@
+package ksk
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Report whether upstream URLs are answered from fixture files instead of
+// the upstream
+func (s *Server) offline() bool {
+	return s.cfg.FixturesDir != "" && !s.cfg.RecordFixtures
+}
+
+// File holding the fixture of an upstream URL: its path and query relative
+// to the upstream URL, escaped into a single file name, so
+// /events?show_past=true is events%3Fshow_past=true.json
+func (s *Server) fixturePath(upstream string) string {
+	rel := strings.TrimPrefix(strings.TrimPrefix(upstream, s.cfg.UpstreamURL), "/")
+	return filepath.Join(s.cfg.FixturesDir, url.PathEscape(rel)+".json")
+}
+
+// Entry for an upstream URL read from its fixture. A missing fixture is
+// reported as errUpstreamNotFound.
+func (s *Server) loadFixture(upstream string, policy cachePolicy) (cacheEntry, error) {
+	data, err := os.ReadFile(s.fixturePath(upstream))
+	if errors.Is(err, fs.ErrNotExist) {
+		return cacheEntry{}, errUpstreamNotFound
+	}
+	if err != nil {
+		return cacheEntry{}, fmt.Errorf("%w: %v", errUpstreamRead, err)
+	}
+	if err := validateJSONResponse("", data); err != nil {
+		return cacheEntry{}, fmt.Errorf("%w: fixture %s: %v", errUpstreamInvalid, s.fixturePath(upstream), err)
+	}
+	if s.stripFields != nil {
+		if data, err = stripJSONFields(data, s.stripFields); err != nil {
+			return cacheEntry{}, fmt.Errorf("%w: %v", errUpstreamInvalid, err)
+		}
+	}
+	// Not counted, as fixtures are read again on every request
+	if s.normalizesTimes(policy) {
+		if data, _, err = normalizeEventTimes(data, s.cfg.EventTimes == eventTimesUTC); err != nil {
+			return cacheEntry{}, fmt.Errorf("%w: %v", errUpstreamInvalid, err)
+		}
+	}
+	if s.sanitizes(policy) {
+		if data, err = s.sanitizer.sanitizeJSON(data); err != nil {
+			return cacheEntry{}, fmt.Errorf("%w: %v", errUpstreamInvalid, err)
+		}
+	}
+
+	// Read on every request, so edited fixtures show up at once
+	return cacheEntry{
+		data:   data,
+		stored: time.Now(),
+		until:  time.Now().Add(s.policyTTL(policy)),
+		etag:   computeETag(data),
+	}, nil
+}
+
+// Create the fixtures directory when recording into it. Done at startup
+// rather than in LoadConfig, which every reload runs again.
+func (s *Server) prepareFixtures() error {
+	if !s.cfg.RecordFixtures {
+		return nil
+	}
+	if err := os.MkdirAll(s.cfg.FixturesDir, 0o755); err != nil {
+		return fmt.Errorf("create fixtures directory: %w", err)
+	}
+	return nil
+}
+
+// Save a fetched upstream body as the fixture of its URL
+func (s *Server) recordFixture(upstream string, body []byte) {
+	if !s.cfg.RecordFixtures {
+		return
+	}
+
+	path := s.fixturePath(upstream)
+	// Write and rename, so a concurrent reader never sees half a file
+	tmp, err := os.CreateTemp(s.cfg.FixturesDir, ".record-*")
+	if err == nil {
+		_, err = tmp.Write(body)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), path)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}
+	if err != nil {
+		log.Printf("Recording fixture for %s failed: %v", upstream, err)
+	}
+}
@@ -0,0 +1,65 @@
+package ksk
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+)
+
+// In-progress or completed upstream fetch shared by concurrent callers
+type flightCall struct {
+	done chan struct{}
+	res  fetchResult
+	err  error
+}
+
+// Coalesces concurrent fetches of the same key into a single call
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// Run fn once per key at a time. Callers arriving while a fetch is in
+// progress wait for it and receive its result; shared reports whether
+// the result came from another caller's fetch. fn runs detached from the
+// callers, so it completes and fills the cache even if every caller's ctx
+// is cancelled first; those callers return ctx's error.
+func (g *flightGroup) do(ctx context.Context, key string, fn func() (fetchResult, error)) (res fetchResult, shared bool, err error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*flightCall{}
+	}
+	c, shared := g.calls[key]
+	if !shared {
+		c = &flightCall{done: make(chan struct{})}
+		g.calls[key] = c
+		go g.run(key, c, fn)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.res, shared, c.err
+	case <-ctx.Done():
+		return fetchResult{}, shared, ctx.Err()
+	}
+}
+
+func (g *flightGroup) run(key string, c *flightCall, fn func() (fetchResult, error)) {
+	// Release waiters even if fn panics, which must not take down the
+	// process from this goroutine
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("Fetch of %s panicked: %v\n%s", key, p, debug.Stack())
+			c.err = fmt.Errorf("%w: internal error", errUpstreamUnavailable)
+		}
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+
+	c.res, c.err = fn()
+}
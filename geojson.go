@@ -0,0 +1,138 @@
+package ksk
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// GeoJSON FeatureCollection of venues (RFC 7946)
+type geoFeatureCollection struct {
+	Type     string       `json:"type"`
+	Features []geoFeature `json:"features"`
+}
+
+type geoFeature struct {
+	Type string `json:"type"`
+	// Upstream venue ID, if any
+	ID         string          `json:"id,omitempty"`
+	Geometry   geoGeometry     `json:"geometry"`
+	Properties venueProperties `json:"properties"`
+}
+
+type geoGeometry struct {
+	Type string `json:"type"`
+	// Longitude first, as GeoJSON orders them
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+type venueProperties struct {
+	Venue  string       `json:"venue"`
+	Events []venueEvent `json:"events"`
+}
+
+// Event at a venue, as listed in the venue's feature
+type venueEvent struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	// RFC 3339 in Berlin time, or a date for all-day events; empty if the
+	// upstream sent no start
+	Start         string   `json:"start"`
+	Accessibility []string `json:"accessibility"`
+}
+
+// Handle /events.geojson, the venues of the events list as a GeoJSON
+// FeatureCollection for map views. It takes the same from/to and genre
+// filters as /events.
+func (s *Server) geoJSONHandler(policy cachePolicy) http.HandlerFunc {
+	upstream := s.cfg.UpstreamURL + eventsPath
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isRead(r) {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		filter, err := parseEventFilter(r.URL.Query(), s.cfg.AccessFeatures)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_parameter", err.Error())
+			return
+		}
+		// Maps show every venue of the selection
+		filter.paginate = false
+
+		name := "geojson"
+		if key := filter.key(); key != "" {
+			name += "&" + key
+		}
+		s.serveDerived(w, r, upstream, policy, derivedView{name: name, ttl: filter.ttl(), build: func(source cacheEntry) ([]byte, string, error) {
+			events, err := decodeEvents(source.data)
+			if err != nil {
+				return nil, "", err
+			}
+			var kept []event
+			for _, ev := range events {
+				if filter.match(ev) {
+					kept = append(kept, ev)
+				}
+			}
+			body, err := buildGeoJSON(kept)
+			return body, "application/geo+json", err
+		}})
+	}
+}
+
+// One Point feature per venue with the events taking place there, in
+// list order. Events whose venue has no coordinates are left out.
+func buildGeoJSON(events []event) ([]byte, error) {
+	collection := geoFeatureCollection{Type: "FeatureCollection", Features: []geoFeature{}}
+	byVenue := map[string]int{}
+	for _, ev := range events {
+		loc := ev.VenueLocation
+		if loc == nil {
+			continue
+		}
+
+		// Venues without an ID are told apart by where they are
+		key := "id:" + ev.VenueID
+		if ev.VenueID == "" {
+			key = strconv.FormatFloat(loc.Lat, 'f', -1, 64) + "," + strconv.FormatFloat(loc.Lon, 'f', -1, 64)
+		}
+		i, ok := byVenue[key]
+		if !ok {
+			i = len(collection.Features)
+			byVenue[key] = i
+			collection.Features = append(collection.Features, geoFeature{
+				Type:       "Feature",
+				ID:         ev.VenueID,
+				Geometry:   geoGeometry{Type: "Point", Coordinates: [2]float64{loc.Lon, loc.Lat}},
+				Properties: venueProperties{Venue: ev.Venue},
+			})
+		}
+
+		accessibility := ev.Accessibility
+		if accessibility == nil {
+			accessibility = []string{}
+		}
+		props := &collection.Features[i].Properties
+		props.Events = append(props.Events, venueEvent{
+			ID:            ev.ID,
+			Title:         ev.Title,
+			Start:         geoJSONTime(ev.Start, ev.AllDay),
+			Accessibility: accessibility,
+		})
+	}
+	return json.Marshal(collection)
+}
+
+func geoJSONTime(t time.Time, dateOnly bool) string {
+	switch {
+	case t.IsZero():
+		return ""
+	case dateOnly:
+		return t.In(berlin).Format(time.DateOnly)
+	default:
+		return t.In(berlin).Format(time.RFC3339)
+	}
+}
@@ -0,0 +1,96 @@
+package ksk
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// How soon an upstream list refused by the shrink guard is fetched again
+const shrinkRetryInterval = 30 * time.Second
+
+// Report whether the shrink guard checks bodies fetched with policy
+func (s *Server) guards(policy cachePolicy) bool {
+	return policy.shrinkGuard && s.cfg.ShrinkLimit > 0
+}
+
+// Why a fetched list looks like an upstream glitch, such as the empty
+// list it returns during maintenance, rather than real data: it is empty
+// or has more than ShrinkLimit percent fewer elements than the previous
+// one. "" if it looks fine or either body is not a JSON array.
+func (s *Server) shrinkReason(previous, next []byte) string {
+	before, ok := jsonArrayLen(previous)
+	if !ok || before == 0 {
+		return ""
+	}
+	after, ok := jsonArrayLen(next)
+	switch {
+	case !ok:
+		return ""
+	case after == 0:
+		return fmt.Sprintf("an empty list instead of %d elements", before)
+	case (before-after)*100 > before*s.cfg.ShrinkLimit:
+		return fmt.Sprintf("%d elements instead of %d", after, before)
+	}
+	return ""
+}
+
+// Number of elements of a JSON array, false if data is not one
+func jsonArrayLen(data []byte) (int, bool) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return 0, false
+	}
+	var elements []json.RawMessage
+	if err := json.Unmarshal(data, &elements); err != nil {
+		return 0, false
+	}
+	return len(elements), true
+}
+
+// Keep serving previous instead of a list refused by the shrink guard,
+// trying the upstream again soon
+func (s *Server) refuseShrunk(upstream string, previous cacheEntry, policy cachePolicy, ttl time.Duration, reason string) cacheEntry {
+	key := strings.TrimPrefix(upstream, s.cfg.UpstreamURL)
+	log.Printf("Keeping the previous %s: the upstream returned %s (POST /admin/cache/accept?key=%s accepts it)", key, reason, key)
+
+	s.refused.Store(upstream, policy)
+	previous.until = time.Now().Add(min(ttl, shrinkRetryInterval))
+	s.store(upstream, previous)
+	return previous
+}
+
+// POST /admin/cache/accept?key=/events?show_past=true fetches a list the
+// shrink guard refused again and caches it whatever its size, for when
+// the shrink is legitimate
+func (s *Server) acceptHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	key := r.URL.Query().Get("key")
+	if !strings.HasPrefix(key, "/") {
+		key = "/" + key
+	}
+	upstream := s.cfg.UpstreamURL + key
+	refused, ok := s.refused.Load(upstream)
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "nothing_refused", "No refused list for key: "+key)
+		return
+	}
+
+	policy := refused.(cachePolicy)
+	policy.acceptShrink = true
+	res, err := s.fetchUpstream(r.Context(), upstream, policy, nil)
+	if err != nil {
+		writeUpstreamError(w, r, err)
+		return
+	}
+	elements, _ := jsonArrayLen(res.entry.data)
+	writeJSON(w, http.StatusOK, map[string]any{"status": "ok", "key": key, "elements": elements})
+}
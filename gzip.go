@@ -0,0 +1,115 @@
+package ksk
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Bodies below this size are not worth compressing
+	gzipMinSize = 1024
+
+	// Most bytes a compressed upstream body may inflate to if its endpoint
+	// has no body limit, so that a gzip bomb cannot exhaust memory
+	maxInflatedBody = 256 << 20
+)
+
+// Compress a body for the cache, or return nil if it is too small or the
+// compressed copy would not be smaller
+func compressBody(data []byte) []byte {
+	if len(data) < gzipMinSize {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if _, err := zw.Write(data); err != nil {
+		return nil
+	}
+	if err := zw.Close(); err != nil {
+		return nil
+	}
+	if buf.Len() >= len(data) {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// Report whether an Accept-Encoding header allows gzip
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.TrimSpace(coding)
+		if !strings.EqualFold(coding, "gzip") && coding != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// Distinct strong ETag for the gzip representation of a body
+func gzipETag(etag string) string {
+	return strings.TrimSuffix(etag, `"`) + `-gzip"`
+}
+
+// Replace the body of a gzip-encoded upstream response with the
+// decompressed one, so the cache only ever holds the canonical body.
+// Bodies sent without compression, although gzip was asked for, are left
+// as they are. Inflating fails with errUpstreamTooLarge past limit bytes,
+// or past maxInflatedBody if limit is not positive.
+func decodeUpstreamBody(resp *http.Response, limit int64) error {
+	switch coding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))); coding {
+	case "", "identity":
+		return nil
+	case "gzip", "x-gzip":
+	default:
+		return fmt.Errorf("%w: unsupported content encoding %q", errUpstreamInvalid, coding)
+	}
+
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%w: gzip: %v", errUpstreamInvalid, err)
+	}
+	if limit <= 0 {
+		limit = maxInflatedBody
+	}
+	resp.Body = &inflatedBody{zr: zr, raw: resp.Body, limit: limit}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+// Decompressed upstream body that fails once it exceeds its limit
+type inflatedBody struct {
+	zr    *gzip.Reader
+	raw   io.ReadCloser
+	read  int64
+	limit int64
+}
+
+func (b *inflatedBody) Read(p []byte) (int, error) {
+	n, err := b.zr.Read(p)
+	b.read += int64(n)
+	if b.read > b.limit {
+		return n, fmt.Errorf("%w: more than %d bytes decompressed", errUpstreamTooLarge, b.limit)
+	}
+	return n, err
+}
+
+func (b *inflatedBody) Close() error {
+	b.zr.Close()
+	return b.raw.Close()
+}
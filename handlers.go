@@ -0,0 +1,161 @@
+package ksk
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Only allow numeric event and location IDs that fit an int64
+var idRegex = regexp.MustCompile(`^[0-9]{1,18}$`)
+
+// Split the path of r below base into an ID and the segment after it, if
+// that is one of suffixes, ignoring a trailing slash. ok is false for any
+// other shape, including percent-encoded characters, which no valid path
+// contains; such paths are answered with a 404 and never reach the
+// upstream.
+func idPath(r *http.Request, base string, suffixes ...string) (id, suffix string, ok bool) {
+	rest, found := strings.CutPrefix(r.URL.Path, base)
+	if !found || r.URL.RawPath != "" {
+		return "", "", false
+	}
+	id, suffix, more := strings.Cut(strings.TrimSuffix(rest, "/"), "/")
+	if id == "" || more && !slices.Contains(suffixes, suffix) {
+		return "", "", false
+	}
+	return id, suffix, true
+}
+
+// Proxy static endpoints. Query parameters in path are defaults; clients
+// may override them and add any of the allowed parameters, everything else
+// is dropped so arbitrary query strings never reach the upstream.
+func (s *Server) proxyStatic(path string, policy cachePolicy, allowed ...string) http.HandlerFunc {
+	path, rawDefaults, _ := strings.Cut(path, "?")
+	defaults, _ := url.ParseQuery(rawDefaults)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isRead(r) {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+		s.serveCached(w, r, s.cfg.UpstreamURL+path+normalizedQuery(defaults, r.URL.Query(), allowed), policy)
+	}
+}
+
+// Handle /events. Clients may narrow the list to events starting between
+// ?from= and ?to= (inclusive dates), to those in any of the repeatable
+// ?genre= IDs and to those offering all ?access= features, and page
+// through it with ?limit=&offset= or ?page=&per_page=, which wraps the
+// result in an eventPage. With ?show_past=false it lists only events that
+// have not ended yet. All of this is applied to the cached upstream list,
+// so every variant shares one upstream entry, as the X-Filtered: local
+// header tells.
+func (s *Server) eventsHandler(policy cachePolicy) http.HandlerFunc {
+	upstream := s.cfg.UpstreamURL + eventsPath
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isRead(r) {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		filter, err := parseEventFilter(r.URL.Query(), s.cfg.AccessFeatures)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_parameter", err.Error())
+			return
+		}
+
+		if filter.empty() {
+			s.serveCached(w, r, upstream, policy)
+			return
+		}
+		w.Header().Set("X-Filtered", "local")
+		s.serveDerived(w, r, upstream, policy, derivedView{name: filter.key(), build: filter.apply, ttl: filter.ttl()})
+	}
+}
+
+// Merge the allowed client parameters over the defaults and encode them with
+// sorted keys and values, so equivalent queries map to the same cache key
+func normalizedQuery(defaults, client url.Values, allowed []string) string {
+	merged := url.Values{}
+	for key, values := range defaults {
+		merged[key] = append([]string(nil), values...)
+	}
+	for _, key := range allowed {
+		if values, ok := client[key]; ok {
+			merged[key] = append([]string(nil), values...)
+		}
+	}
+	if len(merged) == 0 {
+		return ""
+	}
+
+	for _, values := range merged {
+		sort.Strings(values)
+	}
+	return "?" + merged.Encode()
+}
+
+// Handle /event/{id}
+func (s *Server) eventHandler(policy cachePolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isRead(r) {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		id, suffix, ok := idPath(r, "/api/v1/event/", "accessibility")
+		if !ok {
+			writeNotFound(w, r)
+			return
+		}
+		isAccessibility := suffix == "accessibility"
+
+		if !idRegex.MatchString(id) {
+			writeError(w, r, http.StatusBadRequest, "invalid_event_id", "Invalid event id")
+			return
+		}
+
+		// Most requested events are part of the cached events list, which
+		// is in the default language
+		if !isAccessibility && !s.offline() && !wantsRaw(r) && !s.bypasses(r) && s.defaultLanguage(r) {
+			if entry, ok := s.indexedEvent(id); ok {
+				s.stats.record(policy, "HIT")
+				s.writeResolved(w, r, entry, "HIT")
+				return
+			}
+		}
+
+		upstream := s.cfg.UpstreamURL + "/event/" + id
+		if isAccessibility {
+			upstream += "/accessibility"
+		}
+
+		s.serveCached(w, r, upstream, policy)
+	}
+}
+
+// Handle /location/{id}
+func (s *Server) locationHandler(policy cachePolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isRead(r) {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		id, _, ok := idPath(r, "/api/v1/location/")
+		if !ok {
+			writeNotFound(w, r)
+			return
+		}
+		if !idRegex.MatchString(id) {
+			writeError(w, r, http.StatusBadRequest, "invalid_location_id", "Invalid location id")
+			return
+		}
+
+		s.serveCached(w, r, s.cfg.UpstreamURL+"/location/"+id, policy)
+	}
+}
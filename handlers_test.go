@@ -0,0 +1,135 @@
+package ksk
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestEventPaths(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	upstream.bodies["/event/1/accessibility"] = `{"wheelchair": true}`
+	var mu sync.Mutex
+	var queries []string
+	upstream.handle(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RawQuery != "" {
+			mu.Lock()
+			queries = append(queries, r.URL.RawQuery)
+			mu.Unlock()
+		}
+		upstream.serve(w, r)
+	})
+	h := newTestServer(t, upstream.URL, nil).Handler()
+
+	const redirect = 0
+	tests := []struct {
+		target string
+		status int
+	}{
+		{"/api/v1/event/1", http.StatusOK},
+		{"/api/v1/event/1/", http.StatusOK},
+		{"/api/v1/event/1?fields=all&upstream=evil", http.StatusOK},
+		{"/api/v1/event/1/accessibility", http.StatusOK},
+		{"/api/v1/event/1/accessibility/", http.StatusOK},
+		{"/api/v1/event/1/tickets", http.StatusNotFound},
+		{"/api/v1/event/1/accessibility/extra", http.StatusNotFound},
+		{"/api/v1/event/1//", redirect},
+		{"/api/v1/event/", http.StatusNotFound},
+		{"/api/v1/event", http.StatusNotFound},
+		{"/api/v1/event/123456789012345678", http.StatusNotFound},
+		{"/api/v1/event/1234567890123456789", http.StatusBadRequest},
+		{"/api/v1/event/1;ls", http.StatusBadRequest},
+		{"/api/v1/event/%31", http.StatusNotFound},
+		{"/api/v1/event/..%2f..%2fgenres", http.StatusNotFound},
+		{"/api/v1/event/1%2f..%2f..%2fgenres", http.StatusNotFound},
+		{"/api/v1/event/%2e%2e%2fgenres", http.StatusNotFound},
+		{"/api/v1/event/..%252fgenres", http.StatusBadRequest},
+		{"/api/v1/event/1/..%2f..%2f..%2fadmin", http.StatusNotFound},
+		{"/api/v1/event/../genres", redirect},
+		{"/api/v1/location/10/", http.StatusOK},
+		{"/api/v1/location/10/events", http.StatusNotFound},
+		{"/api/v1/location/..%2fevents", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := serve(h, http.MethodGet, tt.target, nil)
+		if tt.status == redirect {
+			// The mux redirects to the cleaned path
+			if w.Code < 300 || w.Code > 399 {
+				t.Errorf("%s: status %d, want a redirect", tt.target, w.Code)
+			}
+			continue
+		}
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.target, w.Code, tt.status)
+		}
+	}
+
+	// Nothing but the valid IDs reached the upstream, and no query
+	allowed := map[string]bool{"/event/1": true, "/event/1/accessibility": true, "/event/123456789012345678": true, "/location/10": true}
+	upstream.mu.Lock()
+	defer upstream.mu.Unlock()
+	for path := range upstream.hits {
+		if !allowed[path] {
+			t.Errorf("upstream got a request for %s", path)
+		}
+	}
+	for path, n := range upstream.hits {
+		if n != 1 {
+			t.Errorf("upstream got %d requests for %s, want 1 with the rest from the cache", n, path)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(queries) > 0 {
+		t.Errorf("client queries passed upstream: %q", queries)
+	}
+}
+
+func TestNearMissPaths(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestServer(t, upstream.URL, func(cfg *Config) {
+		cfg.CORSOrigins = []string{"https://www.example.org"}
+	}).Handler()
+
+	for _, target := range []string{
+		"/api/v1/evens",
+		"/api/v1/eventsomething",
+		"/api/v1/eventsx",
+		"/api/v1/event",
+		"/api/v1/location",
+		"/api/v1/venues",
+		"/api/v1/venues/10",
+		"/api/v1/venues/10/event",
+		"/api/v1/genre",
+		"/api/v1/genres/",
+		"/api/v1/genres/3",
+		"/api/v1/events/batches",
+		"/api/v1/events.json",
+		"/api/v1/",
+		"/api/v1",
+		"/api/v2/events",
+		"/api/V1/events",
+		"/API/v1/events",
+	} {
+		w := serve(h, http.MethodGet, target, http.Header{"Origin": {"https://www.example.org"}})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", target, w.Code)
+			continue
+		}
+		if !strings.HasPrefix(target, "/api/v1/") {
+			continue
+		}
+		if code := errorCode(w.Body.String()); code != "not_found" {
+			t.Errorf("%s: body %q, want the JSON error envelope", target, w.Body)
+		}
+		if origin := w.Header().Get("Access-Control-Allow-Origin"); origin != "https://www.example.org" {
+			t.Errorf("%s: Access-Control-Allow-Origin %q", target, origin)
+		}
+	}
+	upstream.mu.Lock()
+	defer upstream.mu.Unlock()
+	if len(upstream.hits) > 0 {
+		t.Errorf("near misses reached the upstream: %v", upstream.hits)
+	}
+}
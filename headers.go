@@ -0,0 +1,167 @@
+package ksk
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Placeholder in configured header values for the seconds until the
+// served cache entry expires
+const headerTTLParam = "{ttl}"
+
+// Headers the gateway computes itself, which configured headers may not
+// set. Access-Control-* headers belong to CORS and are refused as well.
+var computedHeaders = []string{
+	"Age", "Allow", "Content-Encoding", "Content-Language", "Content-Length",
+	"Content-Type", "ETag", "Location", "Retry-After", "Vary", "Warning",
+	"WWW-Authenticate", "X-Cache", "X-Cache-Expires", "X-Filtered",
+	"X-Gateway-Version", "X-Request-ID", "X-Upstream",
+}
+
+// ResponseHeaders are added to responses on top of what the gateway sets,
+// such as security headers or a Cache-Control for CDNs
+type ResponseHeaders struct {
+	// Sent on every response
+	Global http.Header
+	// By the pattern a route is registered with, such as /api/v1/genres or
+	// /api/v1/event/, replacing global headers of the same name
+	Routes map[string]http.Header
+}
+
+// Headers file: {"global": {"Name": "value"}, "routes": {"/path": {...}}}
+type responseHeadersSpec struct {
+	Global map[string]string            `json:"global"`
+	Routes map[string]map[string]string `json:"routes"`
+}
+
+// Read and check the headers file
+func loadResponseHeaders(file string) (ResponseHeaders, error) {
+	var headers ResponseHeaders
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return headers, fmt.Errorf("read headers file: %w", err)
+	}
+
+	var spec responseHeadersSpec
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&spec); err != nil {
+		return headers, fmt.Errorf("parse headers file %s: %w", file, err)
+	}
+
+	if headers.Global, err = headerSpec(spec.Global); err != nil {
+		return headers, fmt.Errorf("global headers: %w", err)
+	}
+	headers.Routes = make(map[string]http.Header, len(spec.Routes))
+	for pattern, values := range spec.Routes {
+		if !strings.HasPrefix(pattern, "/") {
+			return headers, fmt.Errorf("headers of route %q: expected a path starting with /", pattern)
+		}
+		if headers.Routes[pattern], err = headerSpec(values); err != nil {
+			return headers, fmt.Errorf("headers of route %q: %w", pattern, err)
+		}
+	}
+	return headers, nil
+}
+
+func headerSpec(values map[string]string) (http.Header, error) {
+	h := make(http.Header, len(values))
+	for name, value := range values {
+		if name == "" || strings.ContainsFunc(name, func(r rune) bool {
+			return r <= ' ' || r >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r)
+		}) {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("header %s: value must be a single line", name)
+		}
+		name = http.CanonicalHeaderKey(name)
+		for _, computed := range computedHeaders {
+			if strings.EqualFold(name, computed) {
+				return nil, fmt.Errorf("header %s is set by the gateway", name)
+			}
+		}
+		if strings.HasPrefix(name, "Access-Control-") {
+			return nil, fmt.Errorf("header %s is set by the CORS configuration", name)
+		}
+		h.Set(name, value)
+	}
+	return h, nil
+}
+
+// Add the configured headers to every response as the wrapped handler
+// writes its header. Route headers replace global ones of the same name,
+// and both replace what the handler set, except that a no-store
+// Cache-Control, as on errors and admin responses, is kept. Values with
+// {ttl} are only sent with responses served from the cache.
+func withResponseHeaders(headers ResponseHeaders, mux *http.ServeMux, next http.Handler) http.Handler {
+	if len(headers.Global) == 0 && len(headers.Routes) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		next.ServeHTTP(&headerWriter{ResponseWriter: w, global: headers.Global, route: headers.Routes[pattern]}, r)
+	})
+}
+
+// Applies configured headers right before the response header is written
+type headerWriter struct {
+	http.ResponseWriter
+	global, route http.Header
+	written       bool
+}
+
+func (w *headerWriter) WriteHeader(status int) {
+	w.apply()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *headerWriter) Write(b []byte) (int, error) {
+	w.apply()
+	return w.ResponseWriter.Write(b)
+}
+
+// Lets http.ResponseController reach the underlying writer
+func (w *headerWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *headerWriter) apply() {
+	if w.written {
+		return
+	}
+	w.written = true
+
+	h := w.Header()
+	ttl := ""
+	if until, err := time.Parse(time.RFC3339, h.Get("X-Cache-Expires")); err == nil {
+		ttl = strconv.Itoa(max(int(time.Until(until).Seconds()), 0))
+	}
+	noStore := strings.Contains(h.Get("Cache-Control"), "no-store")
+	set := func(name, value string) {
+		if strings.Contains(value, headerTTLParam) {
+			if ttl == "" {
+				return
+			}
+			value = strings.ReplaceAll(value, headerTTLParam, ttl)
+		}
+		if name == "Cache-Control" && noStore {
+			return
+		}
+		h.Set(name, value)
+	}
+	for name, values := range w.global {
+		if _, ok := w.route[name]; !ok {
+			set(name, values[0])
+		}
+	}
+	for name, values := range w.route {
+		set(name, values[0])
+	}
+}
@@ -0,0 +1,89 @@
+package ksk
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const readyProbeTimeout = 2 * time.Second
+
+type healthStatus struct {
+	Status          string     `json:"status"`
+	Uptime          string     `json:"uptime"`
+	LastUpstreamOK  *time.Time `json:"last_upstream_success"`
+	CircuitBreaker  string     `json:"circuit_breaker"`
+	UpstreamProblem string     `json:"upstream_error,omitempty"`
+}
+
+func (s *Server) currentHealth() healthStatus {
+	st := healthStatus{
+		Status:         "ok",
+		Uptime:         time.Since(s.started).Round(time.Second).String(),
+		CircuitBreaker: s.breaker.currentState().String(),
+	}
+	if ns := s.lastUpstreamSuccess.Load(); ns != 0 {
+		t := time.Unix(0, ns).UTC()
+		st.LastUpstreamOK = &t
+	}
+	return st
+}
+
+// Liveness: report process state without touching the upstream or cache
+func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
+	if !isRead(r) {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.currentHealth())
+}
+
+// Readiness: like /healthz but fails with 503 if the upstream is unreachable
+func (s *Server) readyHandler() http.HandlerFunc {
+	probeURL := s.cfg.UpstreamURL + "/genres"
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isRead(r) {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		st := s.currentHealth()
+		if err := s.probeUpstream(r.Context(), probeURL); err != nil {
+			st.Status = "unavailable"
+			st.UpstreamProblem = err.Error()
+			writeJSON(w, http.StatusServiceUnavailable, st)
+			return
+		}
+		writeJSON(w, http.StatusOK, st)
+	}
+}
+
+// Issue a short HEAD request; the upstream is ready unless it fails or returns 5xx
+func (s *Server) probeUpstream(ctx context.Context, probeURL string) error {
+	ctx, cancel := context.WithTimeout(ctx, readyProbeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, probeURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errUpstreamUnavailable
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return errUpstreamStatus
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
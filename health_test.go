@@ -0,0 +1,35 @@
+package ksk
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+// Cache that counts the calls of Stats
+type statsCountingCache struct {
+	cacheStore
+	calls atomic.Int32
+}
+
+func (c *statsCountingCache) Stats() cacheStats {
+	c.calls.Add(1)
+	return c.cacheStore.Stats()
+}
+
+func TestHealthSkipsCacheStats(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	s := newTestServer(t, upstream.URL, nil)
+	cache := &statsCountingCache{cacheStore: s.cache}
+	s.cache = cache
+	h := s.Handler()
+
+	for _, target := range []string{"/healthz", "/readyz"} {
+		if w := serve(h, http.MethodGet, target, nil); w.Code != http.StatusOK {
+			t.Errorf("%s: status %d, want 200", target, w.Code)
+		}
+	}
+	if n := cache.calls.Load(); n != 0 {
+		t.Errorf("health probes read the cache stats %d times", n)
+	}
+}
@@ -0,0 +1,134 @@
+package ksk
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// Duration assumed for events the upstream sends without an end time
+	defaultEventDuration = 2 * time.Hour
+
+	icsTimeLayout = "20060102T150405Z"
+	icsDateLayout = "20060102"
+	// Maximum content line length in octets before folding (RFC 5545 3.1)
+	icsLineLimit = 75
+)
+
+// Handle /events.ics, the events list as an iCalendar feed
+func (s *Server) icsHandler(policy cachePolicy) http.HandlerFunc {
+	uidDomain := "ksk"
+	if u, err := url.Parse(s.cfg.UpstreamURL); err == nil && u.Hostname() != "" {
+		uidDomain = u.Hostname()
+	}
+
+	view := derivedView{name: "ics", build: func(source cacheEntry) ([]byte, string, error) {
+		events, err := decodeEvents(source.data)
+		if err != nil {
+			return nil, "", err
+		}
+		return buildICS(events, uidDomain, source.stored), "text/calendar; charset=utf-8", nil
+	}}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isRead(r) {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+		s.serveDerived(w, r, s.cfg.UpstreamURL+eventsPath, policy, view)
+	}
+}
+
+// Render events as a VCALENDAR with one VEVENT each. Events without a start
+// time are left out. stamp becomes every DTSTAMP, so the output only
+// changes when the events do.
+func buildICS(events []event, uidDomain string, stamp time.Time) []byte {
+	var b icsWriter
+	b.line("BEGIN:VCALENDAR")
+	b.line("VERSION:2.0")
+	b.line("PRODID:-//Kulturleben//go-ksk//DE")
+	b.line("CALSCALE:GREGORIAN")
+	b.line("METHOD:PUBLISH")
+	b.line("X-WR-TIMEZONE:Europe/Berlin")
+
+	for _, ev := range events {
+		if ev.Start.IsZero() {
+			continue
+		}
+
+		b.line("BEGIN:VEVENT")
+		b.line("UID:event-" + ev.ID + "@" + uidDomain)
+		b.line("DTSTAMP:" + stamp.UTC().Format(icsTimeLayout))
+
+		if ev.AllDay {
+			// Dates are floating, DTEND is exclusive
+			end := ev.End
+			if end.IsZero() || !end.After(ev.Start) {
+				end = ev.Start
+			}
+			b.line("DTSTART;VALUE=DATE:" + ev.Start.Format(icsDateLayout))
+			b.line("DTEND;VALUE=DATE:" + end.AddDate(0, 0, 1).Format(icsDateLayout))
+		} else {
+			end := ev.End
+			if end.IsZero() || !end.After(ev.Start) {
+				end = ev.Start.Add(defaultEventDuration)
+			}
+			b.line("DTSTART:" + ev.Start.UTC().Format(icsTimeLayout))
+			b.line("DTEND:" + end.UTC().Format(icsTimeLayout))
+		}
+
+		b.line("SUMMARY:" + icsEscape(ev.Title))
+		if ev.Description != "" {
+			b.line("DESCRIPTION:" + icsEscape(ev.Description))
+		}
+		if ev.Venue != "" {
+			b.line("LOCATION:" + icsEscape(ev.Venue))
+		}
+		b.line("END:VEVENT")
+	}
+
+	b.line("END:VCALENDAR")
+	return b.Bytes()
+}
+
+var icsEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	";", `\;`,
+	",", `\,`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+	"\r", `\n`,
+)
+
+// Escape a TEXT property value (RFC 5545 3.3.11)
+func icsEscape(s string) string {
+	return icsEscaper.Replace(s)
+}
+
+// Accumulates CRLF-terminated content lines, folding long ones
+type icsWriter struct {
+	bytes.Buffer
+}
+
+// Write one content line, folded at icsLineLimit octets without splitting
+// UTF-8 sequences
+func (w *icsWriter) line(s string) {
+	limit := icsLineLimit
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		w.WriteString(s[:cut])
+		w.WriteString("\r\n ")
+		s = s[cut:]
+		// Continuation lines start with the folding space
+		limit = icsLineLimit - 1
+	}
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
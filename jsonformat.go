@@ -0,0 +1,46 @@
+package ksk
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Remove insignificant whitespace from a JSON body before it is cached.
+// Numbers and strings keep their exact text. If the body cannot be
+// compacted it is kept as it is.
+func compactJSON(data []byte) []byte {
+	var buf bytes.Buffer
+	buf.Grow(len(data))
+	if err := json.Compact(&buf, data); err != nil {
+		log.Printf("Caching upstream body as sent, compacting it failed: %v", err)
+		return data
+	}
+	return buf.Bytes()
+}
+
+// Report whether the client asked for indented JSON with ?pretty=1
+func wantsPretty(r *http.Request) bool {
+	pretty, _ := strconv.ParseBool(r.URL.Query().Get("pretty"))
+	return pretty
+}
+
+// Copy of a JSON entry with its body indented for humans, with its own
+// ETag so it is never confused with the compact body. The copy is built
+// per request, so it is only gzipped if gzipped is what the client gets.
+func prettyEntry(entry cacheEntry, compress bool) cacheEntry {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, entry.data, "", "  "); err != nil {
+		return entry
+	}
+	buf.WriteByte('\n')
+	entry.data = buf.Bytes()
+	entry.gzipped = nil
+	if compress {
+		entry.gzipped = compressBody(entry.data)
+	}
+	entry.etag = computeETag(entry.data)
+	return entry
+}
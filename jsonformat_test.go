@@ -0,0 +1,63 @@
+package ksk
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// JSON list large enough to be gzipped
+func largeGenres() string {
+	var genres []string
+	for i := range 100 {
+		genres = append(genres, fmt.Sprintf(`{"id": %d, "name": "Genre %d"}`, i, i))
+	}
+	return "[" + strings.Join(genres, ",") + "]"
+}
+
+func TestPrettyEntryCompressedOnlyOnRequest(t *testing.T) {
+	entry := cacheEntry{data: compactJSON([]byte(largeGenres()))}
+	if pretty := prettyEntry(entry, false); pretty.gzipped != nil {
+		t.Error("pretty body compressed for a client without gzip")
+	}
+	if pretty := prettyEntry(entry, true); pretty.gzipped == nil {
+		t.Error("pretty body not compressed for a client accepting gzip")
+	}
+}
+
+func TestPrettyServed(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	upstream.bodies["/genres"] = largeGenres()
+	h := newTestServer(t, upstream.URL, nil).Handler()
+
+	plain := serve(h, http.MethodGet, "/api/v1/genres?pretty=1", nil)
+	if plain.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Content-Encoding %q without Accept-Encoding", plain.Header().Get("Content-Encoding"))
+	}
+	if !strings.HasPrefix(plain.Body.String(), "[\n  {") {
+		t.Errorf("body not indented: %.40q", plain.Body)
+	}
+
+	gzipped := serve(h, http.MethodGet, "/api/v1/genres?pretty=1", http.Header{"Accept-Encoding": {"gzip"}})
+	if gzipped.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding %q, want gzip", gzipped.Header().Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(gzipped.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != plain.Body.String() {
+		t.Error("gzipped pretty body differs from the plain one")
+	}
+	if plain.Header().Get("ETag") == gzipped.Header().Get("ETag") {
+		t.Error("plain and gzipped pretty bodies share an ETag")
+	}
+}
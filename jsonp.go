@@ -0,0 +1,62 @@
+package ksk
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+)
+
+// Longest JSONP callback name accepted
+const maxJSONPCallback = 64
+
+// JSONP callback names: JavaScript identifiers, optionally dotted like
+// jQuery.cb or widget.onEvents
+var jsonpCallbackPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*(\.[A-Za-z_$][A-Za-z0-9_$]*)*$`)
+
+type jsonpKey struct{}
+
+// Accept ?callback= on a JSON endpoint if JSONP is enabled, for widgets
+// that cannot use CORS. Invalid names get a 400; valid ones make
+// writeEntry wrap the body. Without JSONP the parameter is ignored.
+func (s *Server) withJSONP(next http.HandlerFunc) http.HandlerFunc {
+	if !s.cfg.JSONP {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		callback := r.URL.Query().Get("callback")
+		if callback == "" {
+			next(w, r)
+			return
+		}
+		if len(callback) > maxJSONPCallback || !jsonpCallbackPattern.MatchString(callback) {
+			writeError(w, r, http.StatusBadRequest, "invalid_parameter", "Invalid callback name, expected a JavaScript identifier")
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), jsonpKey{}, callback)))
+	}
+}
+
+// JSONP callback withJSONP accepted for r, empty if none
+func jsonpCallback(r *http.Request) string {
+	callback, _ := r.Context().Value(jsonpKey{}).(string)
+	return callback
+}
+
+// Copy of a JSON entry wrapped in a call to callback, with its own ETag so
+// it is never confused with the plain body. The leading comment keeps the
+// response from starting with attacker-chosen bytes. Like prettyEntry's,
+// the copy is only gzipped if compress says the client takes gzip.
+func jsonpEntry(entry cacheEntry, callback string, compress bool) cacheEntry {
+	data := make([]byte, 0, len(entry.data)+len(callback)+8)
+	data = append(data, "/**/"+callback+"("...)
+	data = append(data, entry.data...)
+	data = append(data, ");"...)
+	entry.data = data
+	entry.gzipped = nil
+	if compress {
+		entry.gzipped = compressBody(entry.data)
+	}
+	entry.etag = computeETag(entry.data)
+	entry.contentType = "application/javascript; charset=utf-8"
+	return entry
+}
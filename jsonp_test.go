@@ -0,0 +1,53 @@
+package ksk
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestJSONPEntryCompressedOnlyOnRequest(t *testing.T) {
+	entry := cacheEntry{data: compactJSON([]byte(largeGenres()))}
+	entry.gzipped = compressBody(entry.data)
+	if wrapped := jsonpEntry(entry, "cb", false); wrapped.gzipped != nil {
+		t.Error("wrapped body compressed for a client without gzip")
+	}
+	if wrapped := jsonpEntry(entry, "cb", true); wrapped.gzipped == nil {
+		t.Error("wrapped body not compressed for a client accepting gzip")
+	}
+}
+
+func TestJSONPServed(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	upstream.bodies["/genres"] = largeGenres()
+	h := newTestServer(t, upstream.URL, func(cfg *Config) { cfg.JSONP = true }).Handler()
+
+	for _, target := range []string{"/api/v1/genres?callback=widget.cb", "/api/v1/genres?callback=widget.cb&pretty=1"} {
+		plain := serve(h, http.MethodGet, target, nil)
+		if plain.Header().Get("Content-Encoding") != "" {
+			t.Errorf("%s: Content-Encoding %q without Accept-Encoding", target, plain.Header().Get("Content-Encoding"))
+		}
+		if !strings.HasPrefix(plain.Body.String(), "/**/widget.cb([") || !strings.HasSuffix(plain.Body.String(), ");") {
+			t.Errorf("%s: body not wrapped: %.40q", target, plain.Body)
+		}
+
+		gzipped := serve(h, http.MethodGet, target, http.Header{"Accept-Encoding": {"gzip"}})
+		if gzipped.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("%s: Content-Encoding %q, want gzip", target, gzipped.Header().Get("Content-Encoding"))
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(gzipped.Body.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != plain.Body.String() {
+			t.Errorf("%s: gzipped body differs from the plain one", target)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package ksk
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Language of r: ?lang= if it names a configured language, otherwise the
+// configured one the Accept-Language header prefers most, otherwise the
+// default. Unsupported languages fall back rather than fail. "" if no
+// languages are configured.
+func (s *Server) requestLanguage(r *http.Request) string {
+	if len(s.cfg.Languages) == 0 {
+		return ""
+	}
+	if lang := primaryLanguage(r.URL.Query().Get("lang")); slices.Contains(s.cfg.Languages, lang) {
+		return lang
+	}
+	for _, lang := range acceptedLanguages(r.Header.Get("Accept-Language")) {
+		if slices.Contains(s.cfg.Languages, lang) {
+			return lang
+		}
+	}
+	return s.cfg.Languages[0]
+}
+
+// Report whether r asks for the default language, the only one the
+// event index and prefetching hold
+func (s *Server) defaultLanguage(r *http.Request) bool {
+	return len(s.cfg.Languages) == 0 || s.requestLanguage(r) == s.cfg.Languages[0]
+}
+
+// Upstream URL for r's language. The default language keeps the plain
+// URL; others add a lang parameter, which also keeps their cache entries
+// apart.
+func (s *Server) localize(r *http.Request, upstream string) string {
+	if s.defaultLanguage(r) {
+		return upstream
+	}
+	return cacheKey{upstream: upstream, lang: s.requestLanguage(r)}.String()
+}
+
+// Language an upstream URL made by localize asks for
+func (s *Server) upstreamLanguage(upstream string) string {
+	if len(s.cfg.Languages) == 0 {
+		return ""
+	}
+	if lang := parseCacheKey(upstream).lang; lang != "" {
+		return lang
+	}
+	return s.cfg.Languages[0]
+}
+
+// Primary subtag of a language tag in lower case: "en" for "en-GB"
+func primaryLanguage(tag string) string {
+	primary, _, _ := strings.Cut(strings.TrimSpace(tag), "-")
+	primary, _, _ = strings.Cut(primary, "_")
+	return strings.ToLower(primary)
+}
+
+// Primary languages of an Accept-Language header, most preferred first,
+// leaving out those with q=0 and the * wildcard
+func acceptedLanguages(header string) []string {
+	type weighted struct {
+		lang string
+		q    float64
+	}
+	var langs []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if lang := primaryLanguage(tag); lang != "" && lang != "*" && q > 0 {
+			langs = append(langs, weighted{lang, q})
+		}
+	}
+	slices.SortStableFunc(langs, func(a, b weighted) int { return cmp.Compare(b.q, a.q) })
+
+	out := make([]string, len(langs))
+	for i, l := range langs {
+		out[i] = l.lang
+	}
+	return out
+}
+
+// Add Vary: Accept-Language to responses of next when they depend on it
+func (s *Server) withLanguageVary(next http.HandlerFunc) http.HandlerFunc {
+	if len(s.cfg.Languages) < 2 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Language")
+		next(w, r)
+	}
+}
+
+// Report whether lang is a primary language subtag: 2 to 8 ASCII letters
+func validLanguage(lang string) bool {
+	if len(lang) < 2 || len(lang) > 8 {
+		return false
+	}
+	for _, c := range lang {
+		if c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return true
+}
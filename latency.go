@@ -0,0 +1,182 @@
+package ksk
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// How often the latency tracker compares the p95 with the threshold
+const latencyCheckInterval = 15 * time.Second
+
+// Upstream fetch duration and when the fetch ended
+type latencySample struct {
+	at time.Time
+	d  time.Duration
+}
+
+// Ring buffer of the latest fetch durations of one endpoint
+type latencyRing struct {
+	mu      sync.Mutex
+	samples []latencySample
+	// Index the next sample goes to and how many slots are filled
+	next, n int
+	// Start of the current breach of the threshold, zero if there is none,
+	// and whether it has been logged
+	breachSince time.Time
+	alerted     bool
+}
+
+// Rolling upstream latency per endpoint, for early warning when the
+// upstream slows down gradually. Endpoints are those of requestStats.
+type latencyTracker struct {
+	window     time.Duration
+	threshold  time.Duration
+	alertAfter time.Duration
+	// Fixed when built, so lookups need no lock
+	rings map[string]*latencyRing
+}
+
+func newLatencyTracker(cfg Config) *latencyTracker {
+	t := &latencyTracker{
+		window:     cfg.LatencyWindow,
+		threshold:  cfg.LatencyThreshold,
+		alertAfter: cfg.LatencyAlertAfter,
+		rings:      map[string]*latencyRing{},
+	}
+	for _, endpoint := range slices.Concat(upstreamEndpoints, []string{routesStatsName}) {
+		t.rings[endpoint] = &latencyRing{samples: make([]latencySample, cfg.LatencySamples)}
+	}
+	return t
+}
+
+func (t *latencyTracker) ring(policy cachePolicy) *latencyRing {
+	if r, ok := t.rings[policy.endpoint]; ok {
+		return r
+	}
+	return t.rings[routesStatsName]
+}
+
+// Add a fetch that took d, overwriting the oldest sample once full
+func (t *latencyTracker) observe(policy cachePolicy, d time.Duration) {
+	r := t.ring(policy)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.samples[r.next] = latencySample{at: time.Now(), d: d}
+	r.next = (r.next + 1) % len(r.samples)
+	r.n = min(r.n+1, len(r.samples))
+}
+
+// Percentiles of the fetches of one endpoint within the window, in
+// milliseconds, and the breach of the threshold if one is going on
+type latencyStats struct {
+	Samples int     `json:"samples"`
+	P50     float64 `json:"p50_ms"`
+	P95     float64 `json:"p95_ms"`
+	P99     float64 `json:"p99_ms"`
+	// Set once the p95 has been above the threshold for the alert delay
+	Breached    bool       `json:"breached"`
+	BreachSince *time.Time `json:"breach_since,omitempty"`
+}
+
+// Percentiles of a ring's samples within the window. The caller holds
+// r.mu.
+func (t *latencyTracker) percentiles(r *latencyRing, now time.Time) latencyStats {
+	durations := make([]time.Duration, 0, r.n)
+	for _, sample := range r.samples[:r.n] {
+		if now.Sub(sample.at) <= t.window {
+			durations = append(durations, sample.d)
+		}
+	}
+	slices.Sort(durations)
+	// Nearest rank
+	rank := func(p float64) float64 {
+		if len(durations) == 0 {
+			return 0
+		}
+		i := int(math.Ceil(p*float64(len(durations)))) - 1
+		return float64(durations[max(i, 0)]) / float64(time.Millisecond)
+	}
+	stats := latencyStats{Samples: len(durations), P50: rank(0.5), P95: rank(0.95), P99: rank(0.99), Breached: r.alerted}
+	if r.alerted {
+		since := r.breachSince.UTC()
+		stats.BreachSince = &since
+	}
+	return stats
+}
+
+// Latency of every endpoint, for /admin/stats
+func (t *latencyTracker) read() map[string]latencyStats {
+	now := time.Now()
+	stats := make(map[string]latencyStats, len(t.rings))
+	for name, r := range t.rings {
+		r.mu.Lock()
+		stats[name] = t.percentiles(r, now)
+		r.mu.Unlock()
+	}
+	return stats
+}
+
+// Drop every sample and breach, as after POST /admin/stats/reset
+func (t *latencyTracker) reset() {
+	for _, r := range t.rings {
+		r.mu.Lock()
+		clear(r.samples)
+		r.next, r.n = 0, 0
+		r.breachSince, r.alerted = time.Time{}, false
+		r.mu.Unlock()
+	}
+}
+
+// Compare each endpoint's p95 with the threshold. A breach lasting the
+// alert delay is logged once as a warning, its end as info.
+func (t *latencyTracker) check(logger *slog.Logger, now time.Time) {
+	for name, r := range t.rings {
+		r.mu.Lock()
+		stats := t.percentiles(r, now)
+		above := stats.Samples > 0 && stats.P95 > float64(t.threshold)/float64(time.Millisecond)
+		switch {
+		case !above:
+			if r.alerted {
+				logger.Info("upstream latency back below threshold",
+					slog.String("event", "upstream_latency_slo_recovered"),
+					slog.String("endpoint", name),
+					slog.Float64("p95_ms", stats.P95),
+					slog.Duration("breach", now.Sub(r.breachSince)))
+			}
+			r.breachSince, r.alerted = time.Time{}, false
+		case r.breachSince.IsZero():
+			r.breachSince = now
+		}
+		if above && !r.alerted && now.Sub(r.breachSince) >= t.alertAfter {
+			r.alerted = true
+			logger.Warn("upstream latency above threshold",
+				slog.String("event", "upstream_latency_slo_breach"),
+				slog.String("endpoint", name),
+				slog.Float64("p50_ms", stats.P50),
+				slog.Float64("p95_ms", stats.P95),
+				slog.Float64("p99_ms", stats.P99),
+				slog.Int("samples", stats.Samples),
+				slog.Float64("threshold_ms", float64(t.threshold)/float64(time.Millisecond)),
+				slog.Time("breach_since", r.breachSince))
+		}
+		r.mu.Unlock()
+	}
+}
+
+// Check the threshold until ctx is done
+func (t *latencyTracker) checkLoop(ctx context.Context, logger *slog.Logger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			t.check(logger, now)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package ksk
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServeOnUnixSocket(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	path := filepath.Join(t.TempDir(), "gateway.sock")
+
+	// Left behind by a previous process
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	s := newTestServer(t, upstream.URL, func(cfg *Config) {
+		cfg.ListenAddr = "unix:" + path
+		cfg.SocketMode = 0o600
+		cfg.ShutdownTimeout = time.Second
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- s.ListenAndServe(ctx) }()
+	defer func() {
+		cancel()
+		<-served
+	}()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+	eventually(t, "the socket", func() bool {
+		resp, err := client.Get("http://gateway/healthz")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return true
+	})
+
+	for _, target := range []string{"/healthz", "/metrics", "/api/v1/genres"} {
+		resp, err := client.Get("http://gateway" + target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: status %d, want 200", target, resp.StatusCode)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o600 {
+		t.Errorf("socket mode %o, want 600", mode)
+	}
+
+	cancel()
+	if err := <-served; err != nil {
+		t.Errorf("ListenAndServe: %v", err)
+	}
+	served <- nil
+	if _, err := os.Lstat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("socket file left after shutdown: %v", err)
+	}
+}
+
+func TestListenRefusesOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.sock")
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if ln, err := listen("unix:"+path, 0o600); err == nil {
+		ln.Close()
+		t.Fatal("listened in place of a regular file")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("regular file removed: %v", err)
+	}
+}
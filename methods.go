@@ -0,0 +1,55 @@
+package ksk
+
+import (
+	"io"
+	"net/http"
+)
+
+// Largest body a read request may carry; the gateway reads none, so this
+// only tolerates clients that send an empty JSON object or the like
+const maxReadBodySize = 1 << 10
+
+// Check methods before the handlers run. TRACE and CONNECT are refused on
+// every path with a 501. Read routes, which are all but the admin routes,
+// take GET, HEAD and OPTIONS and answer other methods with a 405, and
+// reject reads with a body above maxReadBodySize. Admin routes check their
+// methods themselves.
+func (s *Server) withMethods(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodTrace || r.Method == http.MethodConnect {
+			writeError(w, r, http.StatusNotImplemented, "method_not_implemented", "Method not implemented")
+			return
+		}
+		if s.adminRoute(mux, r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !isRead(r) && r.Method != http.MethodOptions {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+		if !readBodyAllowed(r) {
+			// The rest of the body is left unread
+			w.Header().Set("Connection", "close")
+			writeError(w, r, http.StatusBadRequest, "body_not_allowed", "Request body not allowed")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Report whether mux routes r to an admin route
+func (s *Server) adminRoute(mux *http.ServeMux, r *http.Request) bool {
+	_, pattern := mux.Handler(r)
+	return s.adminRoutes[pattern]
+}
+
+// Report whether the body of a read request is within maxReadBodySize,
+// reading it if its length is unknown
+func readBodyAllowed(r *http.Request) bool {
+	if r.ContentLength >= 0 {
+		return r.ContentLength <= maxReadBodySize
+	}
+	n, _ := io.Copy(io.Discard, io.LimitReader(r.Body, maxReadBodySize+1))
+	return n <= maxReadBodySize
+}
@@ -0,0 +1,128 @@
+package ksk
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var allMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodOptions,
+	http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+	http.MethodTrace, http.MethodConnect,
+}
+
+// Status and Allow header a route answers method with, given the methods
+// it takes and their status
+func expectedMethodResult(method, allow string, ok int) (int, string) {
+	switch {
+	case method == http.MethodTrace || method == http.MethodConnect:
+		return http.StatusNotImplemented, ""
+	case method == http.MethodOptions && strings.Contains(allow, http.MethodOptions):
+		return http.StatusNoContent, allow
+	case strings.Contains(allow, method):
+		return ok, ""
+	default:
+		return http.StatusMethodNotAllowed, allow
+	}
+}
+
+func TestMethodsPerRoute(t *testing.T) {
+	const (
+		read      = "GET, HEAD, OPTIONS"
+		adminRead = "GET, HEAD"
+		adminPost = "POST"
+	)
+	routes := []struct {
+		target string
+		allow  string
+		ok     int
+	}{
+		{"/api/v1/events", read, http.StatusOK},
+		{"/api/v1/genres", read, http.StatusOK},
+		{"/api/v1/locations", read, http.StatusOK},
+		{"/api/v1/event/1", read, http.StatusOK},
+		{"/api/v1/location/10", read, http.StatusOK},
+		{"/api/v1/venues/10/events", read, http.StatusOK},
+		{"/api/v1/events/summary", read, http.StatusOK},
+		{"/api/v1/search?q=Hamlet", read, http.StatusOK},
+		{"/api/v1/events.ics", read, http.StatusOK},
+		{"/api/v1/events.csv", read, http.StatusOK},
+		{"/api/v1/openapi.json", read, http.StatusOK},
+		{"/api/v1/unknown", read, http.StatusNotFound},
+		{"/healthz", read, http.StatusOK},
+		{"/readyz", read, http.StatusOK},
+		{"/version", read, http.StatusOK},
+		{"/admin/stats", adminRead, http.StatusOK},
+		{"/admin/cache/keys", adminRead, http.StatusOK},
+		{"/admin/stats/reset", adminPost, http.StatusOK},
+		{"/admin/cache/purge", adminPost, http.StatusOK},
+		{"/admin/reload", adminPost, http.StatusConflict},
+	}
+
+	for _, listener := range []string{"shared", "admin"} {
+		t.Run(listener, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			s := newTestServer(t, upstream.URL, func(cfg *Config) {
+				cfg.AdminToken = "secret"
+				cfg.AdminAllow, _ = parseIPPrefixes("", []string{"192.0.2.0/24"})
+				if listener == "admin" {
+					cfg.AdminAddr = "127.0.0.1:0"
+				}
+			})
+			for _, route := range routes {
+				h := s.Handler()
+				if listener == "admin" && strings.HasPrefix(route.target, "/admin/") {
+					h = s.adminHandler
+				}
+				for _, method := range allMethods {
+					w := serve(h, method, route.target, http.Header{"Authorization": {"Bearer secret"}})
+					status, allow := expectedMethodResult(method, route.allow, route.ok)
+					if w.Code != status {
+						t.Errorf("%s %s: status %d, want %d", method, route.target, w.Code, status)
+					}
+					if got := w.Header().Get("Allow"); got != allow {
+						t.Errorf("%s %s: Allow %q, want %q", method, route.target, got, allow)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestReadBodyLimit(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestServer(t, upstream.URL, nil).Handler()
+
+	tests := []struct {
+		size    int
+		chunked bool
+		status  int
+	}{
+		{2, false, http.StatusOK},
+		{maxReadBodySize, false, http.StatusOK},
+		{maxReadBodySize + 1, false, http.StatusBadRequest},
+		{maxReadBodySize, true, http.StatusOK},
+		{maxReadBodySize + 1, true, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/genres", strings.NewReader(strings.Repeat(" ", tt.size)))
+		if tt.chunked {
+			r.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%d bytes, chunked %v: status %d, want %d", tt.size, tt.chunked, w.Code, tt.status)
+		}
+		if tt.status == http.StatusBadRequest {
+			if code := errorCode(w.Body.String()); code != "body_not_allowed" {
+				t.Errorf("%d bytes: error code %q", tt.size, code)
+			}
+			if w.Header().Get("Connection") != "close" {
+				t.Errorf("%d bytes: connection kept open with the body unread", tt.size)
+			}
+		}
+	}
+}
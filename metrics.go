@@ -0,0 +1,177 @@
+package ksk
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus instrumentation of one Server, kept in its own registry so
+// several servers can run in one process
+type metrics struct {
+	registry         *prometheus.Registry
+	requests         *prometheus.CounterVec
+	cacheResults     map[string]prometheus.Counter
+	upstreamDuration prometheus.Histogram
+	upstreamFailures *prometheus.CounterVec
+	rejectedBodies   prometheus.Counter
+	unparsedTimes    prometheus.Counter
+	panics           prometheus.Counter
+	timeouts         prometheus.Counter
+}
+
+func newMetrics(s *Server) *metrics {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	factory := promauto.With(reg)
+
+	m := &metrics{
+		registry: reg,
+		requests: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "ksk_http_requests_total",
+			Help: "HTTP requests by route and response status.",
+		}, []string{"route", "status"}),
+
+		cacheResults: map[string]prometheus.Counter{
+			"HIT": factory.NewCounter(prometheus.CounterOpts{
+				Name: "ksk_cache_hits_total",
+				Help: "Requests served from a fresh cache entry.",
+			}),
+			"MISS": factory.NewCounter(prometheus.CounterOpts{
+				Name: "ksk_cache_misses_total",
+				Help: "Requests that fetched from the upstream.",
+			}),
+			"COALESCED": factory.NewCounter(prometheus.CounterOpts{
+				Name: "ksk_cache_coalesced_total",
+				Help: "Cache misses that waited for another request's upstream fetch.",
+			}),
+			"STALE": factory.NewCounter(prometheus.CounterOpts{
+				Name: "ksk_cache_stale_total",
+				Help: "Requests served from an expired entry because the upstream failed.",
+			}),
+			"FALLBACK": factory.NewCounter(prometheus.CounterOpts{
+				Name: "ksk_cache_fallbacks_total",
+				Help: "Requests answered with the configured fallback body because the upstream failed and nothing was cached.",
+			}),
+		},
+
+		upstreamDuration: factory.NewHistogram(prometheus.HistogramOpts{
+			Name:    "ksk_upstream_request_duration_seconds",
+			Help:    "Duration of upstream fetches including the body read.",
+			Buckets: prometheus.DefBuckets,
+		}),
+
+		upstreamFailures: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "ksk_upstream_failures_total",
+			Help: "Failed upstream fetches by reason: timeout, dns, connection_refused, connection_reset, tls or connection if the request failed, else status, read, invalid or too_large.",
+		}, []string{"reason"}),
+
+		rejectedBodies: factory.NewCounter(prometheus.CounterOpts{
+			Name: "ksk_upstream_rejected_bodies_total",
+			Help: "Upstream responses not cached because they were not JSON.",
+		}),
+
+		unparsedTimes: factory.NewCounter(prometheus.CounterOpts{
+			Name: "ksk_event_times_unparsed_total",
+			Help: "Event times left as sent because they could not be parsed for normalization.",
+		}),
+
+		panics: factory.NewCounter(prometheus.CounterOpts{
+			Name: "ksk_handler_panics_total",
+			Help: "Requests whose handler panicked.",
+		}),
+
+		timeouts: factory.NewCounter(prometheus.CounterOpts{
+			Name: "ksk_request_timeouts_total",
+			Help: "Requests answered with a 504 because their route's deadline passed.",
+		}),
+	}
+
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "ksk_cache_entries",
+		Help: "Number of entries currently cached.",
+	}, func() float64 { return float64(s.cache.Stats().Entries) })
+
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "ksk_cache_bytes",
+		Help: "Total size of cached bodies in bytes.",
+	}, func() float64 { return float64(s.cache.Stats().Bytes) })
+
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "ksk_upstream_circuit_state",
+		Help: "Upstream circuit breaker state: 0 closed, 1 open, 2 half-open.",
+	}, func() float64 { return float64(s.breaker.currentState()) })
+
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "ksk_upstream_in_flight",
+		Help: "Upstream requests currently in flight.",
+	}, func() float64 { return float64(s.fetchLimit.inFlight.Load()) })
+
+	factory.NewCounterFunc(prometheus.CounterOpts{
+		Name: "ksk_upstream_busy_total",
+		Help: "Fetches answered with a 503 because the upstream concurrency limit was reached.",
+	}, func() float64 { return float64(s.fetchLimit.rejected.Load()) })
+
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "ksk_event_streams",
+		Help: "Event streams currently open.",
+	}, func() float64 { return float64(s.streams.count()) })
+
+	factory.NewCounterFunc(prometheus.CounterOpts{
+		Name: "ksk_cache_evictions_total",
+		Help: "Entries evicted to stay within the cache limits.",
+	}, func() float64 { return float64(s.cache.Stats().Evictions) })
+
+	factory.NewCounterFunc(prometheus.CounterOpts{
+		Name: "ksk_cache_expired_total",
+		Help: "Entries removed by the cache sweep after their retention passed.",
+	}, func() float64 { return float64(s.cache.Stats().Expired) })
+
+	return m
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Set the X-Cache header and count the cache decision
+func (s *Server) setCacheStatus(w http.ResponseWriter, result string) {
+	w.Header().Set("X-Cache", result)
+	if c, ok := s.metrics.cacheResults[result]; ok {
+		c.Inc()
+	}
+}
+
+// Record the outcome of an upstream fetch that started at start
+func (m *metrics) observeUpstream(start time.Time, err error) {
+	m.upstreamDuration.Observe(time.Since(start).Seconds())
+	if err == nil || errors.Is(err, errUpstreamNotFound) {
+		return
+	}
+
+	m.upstreamFailures.WithLabelValues(upstreamErrorClass(err)).Inc()
+}
+
+// Count requests by the mux pattern they matched, which keeps the route
+// label bounded no matter which paths clients request
+func (m *metrics) wrap(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		m.requests.WithLabelValues(route, strconv.Itoa(rec.code())).Inc()
+	})
+}
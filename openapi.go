@@ -0,0 +1,311 @@
+package ksk
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Public API route: how it is registered on the mux and what the OpenAPI
+// document says about it
+type apiRoute struct {
+	// Mux pattern
+	pattern string
+	handler http.HandlerFunc
+	// Deadline of its requests, 0 for none
+	timeout time.Duration
+	// Operations served under the pattern, usually one
+	docs []apiOperation
+}
+
+// GET operation in the OpenAPI document
+type apiOperation struct {
+	// OpenAPI path, such as /api/v1/event/{id}
+	path    string
+	summary string
+	params  []apiParam
+	// Media type of successful responses, JSON when empty
+	contentType string
+	// The route answers 404 for unknown IDs
+	notFound bool
+}
+
+type apiParam struct {
+	name        string
+	description string
+	// JSON schema type, with the format after a colon: "string:date"
+	schema   string
+	required bool
+	// May be given several times
+	repeated bool
+	// Part of the path rather than the query
+	inPath bool
+}
+
+// Parameters shared by several operations
+var (
+	pathIDParam = apiParam{name: "id", description: "Numeric ID of up to 18 digits", schema: "string", required: true, inPath: true}
+	prettyParam = apiParam{name: "pretty", description: "Indent the JSON response for humans", schema: "boolean"}
+
+	eventFilterParams = []apiParam{
+		{name: "from", description: "Only events starting on or after this date", schema: "string:date"},
+		{name: "to", description: "Only events starting on or before this date", schema: "string:date"},
+		{name: "genre", description: "Only events in any of these genre IDs", schema: "string", repeated: true},
+		{name: "access", description: "Only events offering all of these comma-separated accessibility features, such as wheelchair,hearing_loop", schema: "string"},
+		{name: "show_past", description: "Include events that have ended (default true); false keeps upcoming and running events", schema: "boolean"},
+	}
+	eventPageParams = []apiParam{
+		{name: "limit", description: "Page size, wraps the result in a page object", schema: "integer"},
+		{name: "offset", description: "Events to skip", schema: "integer"},
+		{name: "page", description: "Page number starting at 1, instead of offset", schema: "integer"},
+		{name: "per_page", description: "Page size for page", schema: "integer"},
+	}
+)
+
+// Subset of OpenAPI 3.0 the gateway's document uses
+type openAPIDoc struct {
+	OpenAPI    string                                 `json:"openapi"`
+	Info       openAPIInfo                            `json:"info"`
+	Servers    []openAPIServer                        `json:"servers,omitempty"`
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components openAPIComponents                      `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIServer struct {
+	URL string `json:"url"`
+}
+
+type openAPIOperation struct {
+	Summary    string                     `json:"summary"`
+	Parameters []openAPIParameter         `json:"parameters,omitempty"`
+	Responses  map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name        string     `json:"name"`
+	In          string     `json:"in"`
+	Description string     `json:"description,omitempty"`
+	Required    bool       `json:"required,omitempty"`
+	Schema      jsonSchema `json:"schema"`
+	Explode     *bool      `json:"explode,omitempty"`
+}
+
+type openAPIResponse struct {
+	Ref         string                      `json:"$ref,omitempty"`
+	Description string                      `json:"description,omitempty"`
+	Headers     map[string]openAPIHeader    `json:"headers,omitempty"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIHeader struct {
+	Ref         string      `json:"$ref,omitempty"`
+	Description string      `json:"description,omitempty"`
+	Schema      *jsonSchema `json:"schema,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema jsonSchema `json:"schema"`
+}
+
+type openAPIComponents struct {
+	Schemas   map[string]jsonSchema      `json:"schemas"`
+	Responses map[string]openAPIResponse `json:"responses"`
+	Headers   map[string]openAPIHeader   `json:"headers"`
+}
+
+type jsonSchema struct {
+	Ref        string                `json:"$ref,omitempty"`
+	Type       string                `json:"type,omitempty"`
+	Format     string                `json:"format,omitempty"`
+	Items      *jsonSchema           `json:"items,omitempty"`
+	Properties map[string]jsonSchema `json:"properties,omitempty"`
+	Required   []string              `json:"required,omitempty"`
+}
+
+// Headers of every response served through the cache
+var cacheHeaderNames = []string{"X-Cache", "Age", "X-Cache-Expires", "ETag", "X-Request-ID"}
+
+// Build the OpenAPI document of the given routes, served under prefix
+func buildOpenAPI(routes []apiRoute, prefix string) openAPIDoc {
+	str := &jsonSchema{Type: "string"}
+	doc := openAPIDoc{
+		OpenAPI: "3.0.3",
+		Info:    openAPIInfo{Title: "Calendar API Gateway", Version: "1"},
+		Paths:   map[string]map[string]openAPIOperation{},
+		Components: openAPIComponents{
+			Schemas: map[string]jsonSchema{
+				"Error": {
+					Type:     "object",
+					Required: []string{"error"},
+					Properties: map[string]jsonSchema{"error": {
+						Type:     "object",
+						Required: []string{"code", "message", "status"},
+						Properties: map[string]jsonSchema{
+							"code":       {Type: "string"},
+							"message":    {Type: "string"},
+							"status":     {Type: "integer"},
+							"class":      {Type: "string"},
+							"request_id": {Type: "string"},
+						},
+					}},
+				},
+			},
+			Responses: map[string]openAPIResponse{},
+			Headers: map[string]openAPIHeader{
+				"X-Cache": {
+					Description: "Where the response came from: HIT, MISS, COALESCED (waited for another request's fetch), STALE (expired copy served because the upstream failed) or FIXTURE",
+					Schema:      str,
+				},
+				"Age":             {Description: "Seconds since the data was fetched from the upstream", Schema: &jsonSchema{Type: "integer"}},
+				"X-Cache-Expires": {Description: "When the cached data expires", Schema: &jsonSchema{Type: "string", Format: "date-time"}},
+				"ETag":            {Description: "Validator for If-None-Match", Schema: str},
+				"X-Request-ID":    {Description: "ID of the request, also in error bodies and logs", Schema: str},
+			},
+		},
+	}
+
+	errorResponses := map[string]string{
+		"400": "Invalid parameter",
+		"404": "Unknown ID",
+		"502": "Upstream failed",
+		"504": "Upstream did not respond in time",
+	}
+	for status, description := range errorResponses {
+		doc.Components.Responses[status] = openAPIResponse{
+			Description: description,
+			Content: map[string]openAPIMediaType{
+				"application/json": {Schema: jsonSchema{Ref: "#/components/schemas/Error"}},
+			},
+		}
+	}
+
+	headers := map[string]openAPIHeader{}
+	for _, name := range cacheHeaderNames {
+		headers[name] = openAPIHeader{Ref: "#/components/headers/" + name}
+	}
+
+	for _, route := range routes {
+		for _, op := range route.docs {
+			contentType := op.contentType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			params := op.params
+			if op.contentType == "" {
+				params = append(params[:len(params):len(params)], prettyParam)
+			}
+
+			responses := map[string]openAPIResponse{
+				"200": {
+					Description: "Success",
+					Headers:     headers,
+					Content:     map[string]openAPIMediaType{contentType: {}},
+				},
+				"304": {Description: "Not modified since the ETag in If-None-Match"},
+				"502": {Ref: "#/components/responses/502"},
+				"504": {Ref: "#/components/responses/504"},
+			}
+			if len(op.params) > 0 {
+				responses["400"] = openAPIResponse{Ref: "#/components/responses/400"}
+			}
+			if op.notFound {
+				responses["404"] = openAPIResponse{Ref: "#/components/responses/404"}
+			}
+
+			doc.Paths[op.path] = map[string]openAPIOperation{"get": {
+				Summary:    op.summary,
+				Parameters: openAPIParameters(params),
+				Responses:  responses,
+			}}
+		}
+	}
+	// Paths are relative to the prefix
+	if prefix != "" {
+		doc.Servers = []openAPIServer{{URL: prefix}}
+	}
+	return doc
+}
+
+func openAPIParameters(params []apiParam) []openAPIParameter {
+	var out []openAPIParameter
+	for _, p := range params {
+		typ, format, _ := strings.Cut(p.schema, ":")
+		param := openAPIParameter{
+			Name:        p.name,
+			In:          "query",
+			Description: p.description,
+			Required:    p.required,
+			Schema:      jsonSchema{Type: typ, Format: format},
+		}
+		if p.inPath {
+			param.In = "path"
+		}
+		if p.repeated {
+			explode := true
+			param.Schema = jsonSchema{Type: "array", Items: &jsonSchema{Type: typ, Format: format}}
+			param.Explode = &explode
+		}
+		out = append(out, param)
+	}
+	return out
+}
+
+// Operation of a route from the route table
+func (route Route) apiOperation() apiOperation {
+	upstream, _, _ := strings.Cut(route.Upstream, "?")
+	op := apiOperation{path: route.Local, summary: "Proxy of the upstream's " + upstream}
+	if route.IDPattern != nil {
+		op.params = []apiParam{pathIDParam}
+		op.notFound = true
+	}
+	return op
+}
+
+// Serve the OpenAPI document, built once from the registered routes
+func openAPIHandler(routes []apiRoute, prefix string) http.HandlerFunc {
+	body, err := json.Marshal(buildOpenAPI(routes, prefix))
+	if err != nil {
+		panic(err)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isRead(r) {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		w.Write(body)
+	}
+}
+
+// Swagger UI for the OpenAPI document, loaded from a CDN
+const apiDocsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Calendar API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: "openapi.json", dom_id: "#swagger-ui"});</script>
+</body>
+</html>
+`
+
+func apiDocsHandler(w http.ResponseWriter, r *http.Request) {
+	if !isRead(r) {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write([]byte(apiDocsPage))
+}
@@ -0,0 +1,69 @@
+package ksk
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const prefetchMinBackoff = 5 * time.Second
+
+// Upstream URL kept warm by the background refresher and warm-up
+type staticTarget struct {
+	upstream string
+	policy   cachePolicy
+}
+
+// Keep the given targets warm until ctx is cancelled. Each refresher is
+// tracked in wg so shutdown can wait for it to stop.
+func (s *Server) startPrefetch(ctx context.Context, wg *sync.WaitGroup, targets []staticTarget) {
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target staticTarget) {
+			defer wg.Done()
+			s.refreshLoop(ctx, target)
+		}(target)
+	}
+}
+
+// Refresh one cache entry shortly before it expires, backing off on
+// failures up to the entry's TTL
+func (s *Server) refreshLoop(ctx context.Context, target staticTarget) {
+	upstream := target.upstream
+	var backoff time.Duration
+
+	for {
+		wait := backoff
+		if wait == 0 {
+			wait = s.refreshDelay(upstream, s.policyTTL(target.policy))
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		// Share the fetch with any client request missing at the same time
+		if _, _, err := s.fetches.do(ctx, upstream, func() (fetchResult, error) {
+			return s.fetchUpstream(ctx, upstream, target.policy, nil)
+		}); err != nil {
+			backoff = min(max(backoff*2, prefetchMinBackoff), max(s.policyTTL(target.policy), prefetchMinBackoff))
+			log.Printf("Prefetch of %s failed: %v (retrying in %s)", upstream, err, backoff)
+			continue
+		}
+		backoff = 0
+	}
+}
+
+// Time until the entry reaches 80% of its TTL, or zero if it is not cached
+func (s *Server) refreshDelay(upstream string, ttl time.Duration) time.Duration {
+	entry, ok := s.cache.Get(upstream)
+	if !ok {
+		return 0
+	}
+	return max(time.Until(entry.until)-ttl/5, 0)
+}
@@ -0,0 +1,245 @@
+package ksk
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Logged for requests whose client went away before the response was
+// ready, as nginx does
+const statusClientClosedRequest = 499
+
+// Per-endpoint behaviour of serveCached
+type cachePolicy struct {
+	// Built-in endpoint whose configured TTL applies, see policyTTL
+	endpoint string
+	// How long a successful response stays fresh unless the upstream's
+	// Cache-Control or Expires says otherwise, for policies without an
+	// endpoint
+	ttl time.Duration
+	// Answer upstream 404/410 with a 404 instead of a 502
+	notFound bool
+	// Largest upstream body accepted, 0 for no limit
+	maxBody int64
+	// Stream fetched bodies of at least this size to the client while
+	// they are read, 0 to always buffer them
+	streamMin int64
+	// Sanitize HTML in the body before caching it, see Config.Sanitize
+	sanitize bool
+	// Normalize event times before caching, see Config.EventTimes
+	normalizeTimes bool
+	// Fetch for one request only, neither revalidated nor cached
+	uncached bool
+	// Answer failures with the endpoint's fallback body, for the
+	// endpoint's own responses rather than views of them
+	fallback bool
+	// Fetch for ?raw=1: uncached and neither sanitized nor normalized
+	raw bool
+	// Keep the previous list if a fetched one is empty or shrank by more
+	// than ShrinkLimit, unless acceptShrink is set
+	shrinkGuard  bool
+	acceptShrink bool
+	// Notify webhooks and event streams when a fetched body differs from
+	// the cached one
+	notifyChanges bool
+}
+
+// Serve response through the configured cache
+func (s *Server) serveCached(w http.ResponseWriter, r *http.Request, upstream string, policy cachePolicy) {
+	upstream = s.localize(r, upstream)
+	if policy.sanitize && s.sanitizer != nil && wantsRaw(r) {
+		s.serveRaw(w, r, upstream, policy)
+		return
+	}
+	if s.bypasses(r) {
+		s.serveBypass(w, r, upstream, policy)
+		return
+	}
+	policy.fallback = true
+	entry, status, ok := s.resolveOrFail(w, r, upstream, policy, s.newStreamSink(w, r, policy))
+	if !ok {
+		return
+	}
+	s.writeResolved(w, r, entry, status)
+}
+
+// Resolve an upstream URL for a client request, answering upstream
+// failures and unknown resources directly. ok is false if a response has
+// been written, including one streamed to sink by the fetch.
+func (s *Server) resolveOrFail(w http.ResponseWriter, r *http.Request, upstream string, policy cachePolicy, sink *streamSink) (entry cacheEntry, status string, ok bool) {
+	entry, status, attempts, err := s.resolve(r.Context(), upstream, policy, sink)
+	s.stats.record(policy, status)
+	if sink.close() {
+		// Anything but the fetch's own success means the client got a
+		// truncated or invalid body; dropping the connection tells it so
+		if status != "MISS" || err != nil {
+			panic(http.ErrAbortHandler)
+		}
+		return entry, status, false
+	}
+	if r.Context().Err() != nil {
+		s.writeCanceled(w, r)
+		return entry, status, false
+	}
+	if attempts > 0 {
+		w.Header().Set("X-Upstream-Attempts", strconv.Itoa(attempts))
+	}
+	if errors.Is(err, errUpstreamNotFound) {
+		s.setCacheStatus(w, status)
+		writeNotFound(w, r)
+		return entry, status, false
+	}
+	if err != nil {
+		if !policy.fallback || !s.serveFallback(w, r, policy, err) {
+			writeUpstreamError(w, r, err)
+		}
+		return entry, status, false
+	}
+	return entry, status, true
+}
+
+// Write an entry returned by resolve along with its cache status, age,
+// expiry and, if configured, the upstream it came from
+func (s *Server) writeResolved(w http.ResponseWriter, r *http.Request, entry cacheEntry, status string) {
+	s.setCacheStatus(w, status)
+	if status == "STALE" {
+		w.Header().Set("Warning", `110 - "Response is Stale"`)
+	}
+	if !entry.stored.IsZero() {
+		w.Header().Set("Age", strconv.Itoa(max(int(time.Since(entry.stored).Seconds()), 0)))
+	}
+	if !entry.until.IsZero() {
+		w.Header().Set("X-Cache-Expires", entry.until.UTC().Format(time.RFC3339))
+	}
+	if s.cfg.UpstreamHeader && entry.source != "" {
+		w.Header().Set("X-Upstream", entry.source)
+	}
+	writeEntry(w, r, entry)
+}
+
+// Look up an upstream URL in the cache, fetching it on a miss and falling
+// back to the expired copy while it is within the stale window. status is
+// the X-Cache value: HIT, MISS, COALESCED or STALE, or FIXTURE offline;
+// serveCached adds FALLBACK. Unknown resources are
+// reported as errUpstreamNotFound. A fetch made for this caller streams a
+// large body to sink, if not nil.
+func (s *Server) resolve(ctx context.Context, upstream string, policy cachePolicy, sink *streamSink) (entry cacheEntry, status string, attempts int, err error) {
+	if s.offline() {
+		entry, err = s.loadFixture(upstream, policy)
+		return entry, "FIXTURE", 0, err
+	}
+
+	entry, ok := s.cache.Get(upstream)
+	if ok && time.Now().Before(entry.until) {
+		if entry.notFound {
+			return entry, "HIT", 0, errUpstreamNotFound
+		}
+		return entry, "HIT", 0, nil
+	}
+
+	// Fail fast while a recent fetch failure is remembered
+	if err := s.recentFailure(upstream); err != nil {
+		if ok && !entry.notFound && time.Now().Before(entry.until.Add(s.cfg.StaleTTL)) {
+			return entry, "STALE", 0, nil
+		}
+		return entry, "", 0, err
+	}
+
+	res, shared, err := s.fetches.do(ctx, upstream, func() (fetchResult, error) {
+		return s.fetchUpstream(ctx, upstream, policy, sink)
+	})
+	if ctx.Err() != nil {
+		return entry, "", 0, ctx.Err()
+	}
+	if errors.Is(err, errUpstreamNotFound) {
+		return res.entry, "MISS", res.attempts, err
+	}
+	if err != nil {
+		if ok && !entry.notFound && time.Now().Before(entry.until.Add(s.cfg.StaleTTL)) {
+			return entry, "STALE", res.attempts, nil
+		}
+		return res.entry, "", res.attempts, err
+	}
+
+	if shared {
+		return res.entry, "COALESCED", res.attempts, nil
+	}
+	return res.entry, "MISS", res.attempts, nil
+}
+
+// Write a cached body with its validators, gzipped if the client accepts
+// it, indented if it asks for ?pretty=1 and wrapped for a JSONP callback.
+// Clients may cache it for the entry's remaining TTL and get a 304 when
+// their copy is still current. HEAD requests get the headers only.
+func writeEntry(w http.ResponseWriter, r *http.Request, entry cacheEntry) {
+	gzipOK := acceptsGzip(r.Header.Get("Accept-Encoding"))
+	callback := jsonpCallback(r)
+	if wantsPretty(r) && checkJSONMediaType(entry.contentType) == nil {
+		// A body about to be wrapped is compressed once, after wrapping
+		entry = prettyEntry(entry, gzipOK && callback == "")
+	}
+	if callback != "" && checkJSONMediaType(entry.contentType) == nil {
+		entry = jsonpEntry(entry, callback, gzipOK)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+	}
+	maxAge := max(int(time.Until(entry.until).Seconds()), 0)
+
+	body, etag := entry.data, entry.etag
+	useGzip := entry.gzipped != nil && gzipOK
+	if useGzip {
+		body, etag = entry.gzipped, gzipETag(entry.etag)
+	}
+
+	h := w.Header()
+	h.Set("ETag", etag)
+	if entry.private {
+		h.Set("Cache-Control", "private, no-store")
+	} else {
+		h.Set("Cache-Control", "public, max-age="+strconv.Itoa(maxAge))
+	}
+	h.Add("Vary", "Accept-Encoding")
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	contentType := entry.contentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	h.Set("Content-Type", contentType)
+	if entry.contentLanguage != "" {
+		h.Set("Content-Language", entry.contentLanguage)
+	}
+	if useGzip {
+		h.Set("Content-Encoding", "gzip")
+	}
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	if r.Method != http.MethodHead {
+		w.Write(body)
+	}
+}
+
+// Report whether an If-None-Match header matches etag (weak comparison)
+func etagMatches(header, etag string) bool {
+	if header == "" || etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// JSON 404 for resources the upstream does not know
+func writeNotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusNotFound, "not_found", "Not found")
+}
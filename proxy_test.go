@@ -0,0 +1,117 @@
+package ksk
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Wait up to a second for cond to hold
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestClientCancelDuringSlowFetch(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	requested := make(chan struct{})
+	release := make(chan struct{})
+	upstream.handle(func(w http.ResponseWriter, r *http.Request) {
+		close(requested)
+		<-release
+		upstream.serve(w, r)
+	})
+	s := newTestServer(t, upstream.URL, nil)
+	h := s.Handler()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/genres", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(w, r)
+		close(done)
+	}()
+
+	<-requested
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handler still waiting for the upstream after the client left")
+	}
+	if w.Code != statusClientClosedRequest {
+		t.Errorf("status %d, want %d", w.Code, statusClientClosedRequest)
+	}
+
+	// The fetch outlives the client and fills the cache
+	close(release)
+	eventually(t, "the cache fill", func() bool {
+		_, ok := s.cache.Get(upstream.URL + genresPath)
+		return ok
+	})
+	w2 := serve(h, http.MethodGet, "/api/v1/genres", nil)
+	if w2.Code != http.StatusOK || w2.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("next request: status %d, X-Cache %q, want 200 and HIT", w2.Code, w2.Header().Get("X-Cache"))
+	}
+	if n := upstream.count("/genres"); n != 1 {
+		t.Errorf("upstream got %d requests, want 1", n)
+	}
+}
+
+func TestHeadUsesCache(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestServer(t, upstream.URL, nil).Handler()
+
+	get := serve(h, http.MethodGet, "/api/v1/genres", nil)
+	if get.Header().Get("ETag") == "" || get.Header().Get("Content-Length") == "" {
+		t.Fatalf("GET lacks validators: %v", get.Header())
+	}
+	for i, want := range []string{"HIT", "HIT"} {
+		head := serve(h, http.MethodHead, "/api/v1/genres", nil)
+		if head.Code != http.StatusOK {
+			t.Fatalf("HEAD %d: status %d, want 200", i, head.Code)
+		}
+		if got := head.Header().Get("X-Cache"); got != want {
+			t.Errorf("HEAD %d: X-Cache %q, want %q", i, got, want)
+		}
+		for _, key := range []string{"Content-Type", "ETag", "Content-Length"} {
+			if got, want := head.Header().Get(key), get.Header().Get(key); got != want {
+				t.Errorf("HEAD %d: %s %q, GET had %q", i, key, got, want)
+			}
+		}
+		if head.Body.Len() != 0 {
+			t.Errorf("HEAD %d: body %q", i, head.Body)
+		}
+	}
+	if n := upstream.count("/genres"); n != 1 {
+		t.Errorf("upstream got %d requests, want 1", n)
+	}
+}
+
+func TestHeadFillsCache(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestServer(t, upstream.URL, nil).Handler()
+
+	for _, tt := range []struct{ method, status string }{
+		{http.MethodHead, "MISS"},
+		{http.MethodHead, "HIT"},
+		{http.MethodGet, "HIT"},
+	} {
+		w := serve(h, tt.method, "/api/v1/event/1", nil)
+		if w.Code != http.StatusOK || w.Header().Get("X-Cache") != tt.status {
+			t.Errorf("%s: status %d, X-Cache %q, want 200 and %s", tt.method, w.Code, w.Header().Get("X-Cache"), tt.status)
+		}
+	}
+	if n := upstream.count("/event/1"); n != 1 {
+		t.Errorf("upstream got %d requests, want 1", n)
+	}
+}
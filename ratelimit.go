@@ -0,0 +1,117 @@
+package ksk
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Per-client token buckets keyed by IP address
+type rateLimiter struct {
+	mu      sync.Mutex
+	clients map[string]*tokenBucket
+	rate    float64 // tokens per second
+	burst   float64
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rps float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		clients: map[string]*tokenBucket{},
+		rate:    rps,
+		burst:   float64(max(burst, 1)),
+	}
+}
+
+// Change the rate and burst, keeping every client's remaining tokens
+func (l *rateLimiter) setLimits(rps float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = rps
+	l.burst = float64(max(burst, 1))
+}
+
+// Take a token for key, or report how long until one becomes available
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.clients[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.clients[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// Drop buckets that have refilled completely, i.e. clients idle long enough
+// that forgetting them changes nothing
+func (l *rateLimiter) evictIdle() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	cutoff := time.Now().Add(-full)
+
+	for key, b := range l.clients {
+		if b.last.Before(cutoff) {
+			delete(l.clients, key)
+		}
+	}
+}
+
+func (l *rateLimiter) evictLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.evictIdle()
+		}
+	}
+}
+
+// Paths that are never rate limited
+var rateLimitExempt = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// Reject clients exceeding their request budget with 429
+func withRateLimit(l *rateLimiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions || rateLimitExempt[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ok, wait := l.allow(clientIP(r))
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, r, http.StatusTooManyRequests, "rate_limited", "Too many requests")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
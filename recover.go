@@ -0,0 +1,47 @@
+package ksk
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// Headers describing a representation the panicking handler may have set
+// before it failed; they would not match the error body
+var representationHeaders = []string{
+	"Cache-Control", "Content-Encoding", "Content-Length", "Content-Type",
+	"ETag", "Last-Modified", "Warning",
+}
+
+// Turn a panic in next into a logged stack trace and, if nothing has been
+// sent yet, a 500 error response, so one bad request cannot take the
+// connection down without an answer
+func (s *Server) withRecovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			// net/http's way of aborting a response on purpose
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+
+			log.Printf("Panic serving %s %s: %v [request %s]\n%s", r.Method, r.URL.Path, err, requestIDFrom(r.Context()), debug.Stack())
+			s.metrics.panics.Inc()
+
+			if rec.status != 0 {
+				// Too late for an error response; drop the connection so
+				// the client does not take the partial body as complete
+				panic(http.ErrAbortHandler)
+			}
+			for _, name := range representationHeaders {
+				w.Header().Del(name)
+			}
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
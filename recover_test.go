@@ -0,0 +1,69 @@
+package ksk
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRecoverFromPanic(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	s := newTestServer(t, upstream.URL, func(cfg *Config) {
+		cfg.CORSOrigins = []string{"https://www.example.org"}
+	})
+	s.mux.HandleFunc("/api/v1/panic", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"partial"`)
+		panic("transformation bug")
+	})
+	s.mux.HandleFunc("/api/v1/panic-late", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"partial":`)
+		// Sent, so the client cannot retry the request
+		http.NewResponseController(w).Flush()
+		panic("transformation bug")
+	})
+	gateway := httptest.NewServer(s.Handler())
+	defer gateway.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, gateway.URL+"/api/v1/panic", nil)
+	req.Header.Set("Origin", "https://www.example.org")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError || errorCode(string(body)) != "internal_error" {
+		t.Errorf("status %d, body %s, want a 500 internal_error", resp.StatusCode, body)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "https://www.example.org" {
+		t.Errorf("Access-Control-Allow-Origin %q on the error response", got)
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		t.Errorf("ETag %q of the failed handler kept", etag)
+	}
+
+	// A panic after the body started drops the connection instead
+	if resp, err := http.Get(gateway.URL + "/api/v1/panic-late"); err == nil {
+		_, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err == nil {
+			t.Error("partial body read without error")
+		}
+	}
+
+	// The server is still up
+	resp, err = http.Get(gateway.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("/healthz after the panics: status %d", resp.StatusCode)
+	}
+	if n := testutil.ToFloat64(s.metrics.panics); n != 2 {
+		t.Errorf("ksk_handler_panics_total %v, want 2", n)
+	}
+}
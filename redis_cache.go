@@ -0,0 +1,172 @@
+package ksk
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisTimeout     = 500 * time.Millisecond
+	redisLogInterval = 10 * time.Second
+	// How long Stats reuses its count of the keys, which takes a SCAN of
+	// the whole prefix
+	redisStatsTTL = 30 * time.Second
+)
+
+// Cache shared between gateway replicas. Entries are stored as JSON under
+// prefix+key with a Redis TTL matching their retention. The prefix ends in
+// the cache namespace, so replicas of another schema version do not see
+// each other's entries.
+type redisCache struct {
+	client *redis.Client
+	prefix string
+
+	// Unix nanoseconds of the last logged error, to avoid flooding the log
+	// while Redis is down
+	lastErrLog atomic.Int64
+
+	// Last count of the keys and when it was taken
+	statsMu sync.Mutex
+	stats   cacheStats
+	statsAt time.Time
+}
+
+func newRedisCache(addr, password string, db int, prefix string) *redisCache {
+	return &redisCache{
+		client: redis.NewClient(&redis.Options{
+			Addr:         addr,
+			Password:     password,
+			DB:           db,
+			DialTimeout:  redisTimeout,
+			ReadTimeout:  redisTimeout,
+			WriteTimeout: redisTimeout,
+		}),
+		prefix: prefix,
+	}
+}
+
+func (c *redisCache) logError(op string, err error) {
+	now := time.Now().UnixNano()
+	last := c.lastErrLog.Load()
+	if now-last < int64(redisLogInterval) || !c.lastErrLog.CompareAndSwap(last, now) {
+		return
+	}
+	log.Printf("Redis cache %s failed, falling back to upstream: %v", op, err)
+}
+
+func (c *redisCache) ctx() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), redisTimeout)
+}
+
+func (c *redisCache) Get(key string) (cacheEntry, bool) {
+	ctx, cancel := c.ctx()
+	defer cancel()
+
+	b, err := c.client.Get(ctx, c.prefix+key).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			c.logError("get", err)
+		}
+		return cacheEntry{}, false
+	}
+
+	entry, err := unmarshalEntry(b)
+	if err != nil {
+		c.logError("decode", err)
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+func (c *redisCache) Set(key string, entry cacheEntry, retain time.Duration) {
+	if retain <= 0 {
+		return
+	}
+	b, err := marshalEntry(entry)
+	if err != nil {
+		c.logError("encode", err)
+		return
+	}
+
+	ctx, cancel := c.ctx()
+	defer cancel()
+
+	if err := c.client.Set(ctx, c.prefix+key, b, retain).Err(); err != nil {
+		c.logError("set", err)
+	}
+}
+
+func (c *redisCache) Delete(key string) bool {
+	ctx, cancel := c.ctx()
+	defer cancel()
+
+	n, err := c.client.Del(ctx, c.prefix+key).Result()
+	if err != nil {
+		c.logError("delete", err)
+	}
+	return n > 0
+}
+
+// Visit all keys under the prefix
+func (c *redisCache) scan(fn func(ctx context.Context, key string)) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*redisTimeout)
+	defer cancel()
+
+	iter := c.client.Scan(ctx, 0, c.prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		fn(ctx, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		c.logError("scan", err)
+	}
+}
+
+func (c *redisCache) Purge() int {
+	c.statsMu.Lock()
+	c.statsAt = time.Time{}
+	c.statsMu.Unlock()
+
+	purged := 0
+	c.scan(func(ctx context.Context, key string) {
+		if n, err := c.client.Del(ctx, key).Result(); err == nil {
+			purged += int(n)
+		}
+	})
+	return purged
+}
+
+func (c *redisCache) Keys() []cacheKeyInfo {
+	now := time.Now()
+
+	infos := []cacheKeyInfo{}
+	c.scan(func(ctx context.Context, key string) {
+		b, err := c.client.Get(ctx, key).Bytes()
+		if err != nil {
+			return
+		}
+		if entry, err := unmarshalEntry(b); err == nil {
+			infos = append(infos, newKeyInfo(key[len(c.prefix):], entry, now))
+		}
+	})
+	return infos
+}
+
+// Stats counts the keys under the prefix, at most once per redisStatsTTL;
+// sizes and evictions are managed by Redis and not reported
+func (c *redisCache) Stats() cacheStats {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+
+	if time.Since(c.statsAt) < redisStatsTTL {
+		return c.stats
+	}
+	var st cacheStats
+	c.scan(func(context.Context, string) { st.Entries++ })
+	c.stats, c.statsAt = st, time.Now()
+	return st
+}
@@ -0,0 +1,280 @@
+package ksk
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Local path of the public API and the upstream path it is served from,
+// so admin endpoints can find the cache key behind a local path
+type localTarget struct {
+	// Local and upstream path, with {id} where the ID goes if id is set
+	local    string
+	upstream string
+	// Valid IDs, nil for a fixed path
+	id     *regexp.Regexp
+	policy cachePolicy
+}
+
+// Upstream path for a local path, false if the target does not serve it
+func (t localTarget) match(path string) (string, bool) {
+	if t.id == nil {
+		return t.upstream, path == t.local
+	}
+	id, ok := matchIDPattern(t.local, path, t.id)
+	if !ok {
+		return "", false
+	}
+	return strings.ReplaceAll(t.upstream, routeIDParam, url.PathEscape(id)), true
+}
+
+// Report whether the target serves an upstream path, query included
+func (t localTarget) matchUpstream(path string) bool {
+	if t.id == nil {
+		return path == t.upstream
+	}
+	_, ok := matchIDPattern(t.upstream, path, t.id)
+	return ok
+}
+
+// ID where pattern has {id}, false if path does not fit the pattern
+func matchIDPattern(pattern, path string, valid *regexp.Regexp) (string, bool) {
+	prefix, suffix, _ := strings.Cut(pattern, routeIDParam)
+	id, ok := strings.CutPrefix(path, prefix)
+	if !ok {
+		return "", false
+	}
+	if id, ok = strings.CutSuffix(id, suffix); !ok {
+		return "", false
+	}
+	if unescaped, err := url.PathUnescape(id); err == nil {
+		id = unescaped
+	}
+	if strings.Contains(id, "/") || !valid.MatchString(id) || id == "." || id == ".." {
+		return "", false
+	}
+	return id, true
+}
+
+// Upstream URL and policy behind a local URL such as /api/v1/event/123,
+// in the language its ?lang= asks for. The path may start with the
+// configured path prefix.
+func (s *Server) localUpstream(local *url.URL) (string, cachePolicy, bool) {
+	path := local.Path
+	if s.cfg.PathPrefix != "" {
+		if rest, ok := strings.CutPrefix(path, s.cfg.PathPrefix); ok && strings.HasPrefix(rest, "/") {
+			path = rest
+		}
+	}
+	for _, t := range s.locals {
+		if upstream, ok := t.match(path); ok {
+			r := &http.Request{URL: local, Header: http.Header{}}
+			return s.localize(r, s.cfg.UpstreamURL+upstream), t.policy, true
+		}
+	}
+	return "", cachePolicy{}, false
+}
+
+// Cached entry as reported by the refresh endpoint
+type refreshedEntry struct {
+	Size    int64     `json:"size"`
+	Hash    string    `json:"hash"`
+	Expires time.Time `json:"expires"`
+}
+
+func newRefreshedEntry(entry cacheEntry) *refreshedEntry {
+	return &refreshedEntry{Size: entry.size(), Hash: strings.Trim(entry.etag, `"`), Expires: entry.until.UTC()}
+}
+
+// Outcome of refreshing one cache entry
+type refreshOutcome struct {
+	// Key relative to the upstream base URL
+	Upstream  string          `json:"upstream"`
+	Old       *refreshedEntry `json:"old"`
+	New       *refreshedEntry `json:"new"`
+	Changed   bool            `json:"changed"`
+	Coalesced bool            `json:"coalesced"`
+	// The shrink guard kept the old list; see /admin/cache/accept
+	Refused bool `json:"refused"`
+}
+
+// Refresh that failed, in a prefix refresh
+type refreshFailure struct {
+	Upstream string `json:"upstream"`
+	Code     string `json:"code"`
+	Class    string `json:"class,omitempty"`
+}
+
+// POST /admin/cache/refresh?key=/api/v1/event/123 fetches the upstream
+// behind a local path now and caches the result, cached before or not.
+// With ?prefix=/event/123 instead it refreshes every cached upstream body
+// whose key starts with the prefix, relative to the upstream base URL as
+// for purges: all languages of the event and its accessibility details.
+// A failed fetch leaves the cached entry alone, so visitors keep getting
+// it instead of the error. Refreshes coalesce with each other and with
+// concurrent misses of the same key.
+func (s *Server) refreshHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Has("prefix") {
+		if query.Has("key") {
+			writeError(w, r, http.StatusBadRequest, "invalid_parameter", "Expected either key or prefix")
+			return
+		}
+		s.refreshPrefix(w, r, query.Get("prefix"))
+		return
+	}
+
+	key := query.Get("key")
+	local, err := url.Parse(key)
+	if err != nil || !strings.HasPrefix(local.Path, "/") || local.Fragment != "" {
+		writeError(w, r, http.StatusBadRequest, "invalid_key", "Expected a local path such as /api/v1/events as key")
+		return
+	}
+	for param := range local.Query() {
+		if param != "lang" {
+			writeError(w, r, http.StatusBadRequest, "invalid_key", "Keys may only have a lang parameter")
+			return
+		}
+	}
+	upstream, policy, ok := s.localUpstream(local)
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "unknown_key", "No endpoint serves key: "+key)
+		return
+	}
+
+	outcome, err := s.refresh(r.Context(), upstream, policy)
+	if r.Context().Err() != nil {
+		s.writeCanceled(w, r)
+		return
+	}
+	if err != nil {
+		if errors.Is(err, errUpstreamNotFound) {
+			writeError(w, r, http.StatusNotFound, "not_found", "The upstream has no resource for key: "+key)
+			return
+		}
+		writeUpstreamError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":    "ok",
+		"key":       key,
+		"upstream":  outcome.Upstream,
+		"old":       outcome.Old,
+		"new":       outcome.New,
+		"changed":   outcome.Changed,
+		"coalesced": outcome.Coalesced,
+		"refused":   outcome.Refused,
+	})
+}
+
+// Refresh the cached upstream bodies below prefix one after the other.
+// Derived views need no refresh; they are rebuilt from the new bodies.
+func (s *Server) refreshPrefix(w http.ResponseWriter, r *http.Request, prefix string) {
+	if !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+
+	refreshed, failed := []refreshOutcome{}, []refreshFailure{}
+	for _, key := range s.cachedUnder(prefix) {
+		upstream := parseCacheKey(key)
+		if upstream.variant != "" {
+			continue
+		}
+		policy, ok := s.upstreamPolicy(upstream)
+		if !ok {
+			// Left over from a route that is no longer configured
+			continue
+		}
+		outcome, err := s.refresh(r.Context(), key, policy)
+		if r.Context().Err() != nil {
+			s.writeCanceled(w, r)
+			return
+		}
+		if err != nil {
+			code, _ := upstreamErrorCode(err)
+			failure := refreshFailure{Upstream: strings.TrimPrefix(key, s.cfg.UpstreamURL), Code: code}
+			if errors.Is(err, errUpstreamNotFound) {
+				failure.Code = "not_found"
+			} else {
+				failure.Class = upstreamErrorClass(err)
+			}
+			failed = append(failed, failure)
+			continue
+		}
+		refreshed = append(refreshed, outcome)
+	}
+
+	if len(refreshed) == 0 && len(failed) == 0 {
+		writeError(w, r, http.StatusNotFound, "key_not_cached", "Nothing cached under prefix: "+prefix)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":    "ok",
+		"prefix":    prefix,
+		"refreshed": refreshed,
+		"failed":    failed,
+	})
+}
+
+// Fetch upstream now and cache the result, coalescing with other fetches
+// of it
+func (s *Server) refresh(ctx context.Context, upstream string, policy cachePolicy) (refreshOutcome, error) {
+	var old *refreshedEntry
+	if previous, ok := s.cache.Get(upstream); ok && !previous.notFound {
+		old = newRefreshedEntry(previous)
+	}
+	res, shared, err := s.fetches.do(ctx, upstream, func() (fetchResult, error) {
+		return s.fetchUpstream(ctx, upstream, policy, nil)
+	})
+	if err != nil {
+		return refreshOutcome{}, err
+	}
+
+	next := newRefreshedEntry(res.entry)
+	_, refused := s.refused.Load(upstream)
+	return refreshOutcome{
+		Upstream:  strings.TrimPrefix(upstream, s.cfg.UpstreamURL),
+		Old:       old,
+		New:       next,
+		Changed:   old == nil || old.Hash != next.Hash,
+		Coalesced: shared,
+		Refused:   refused,
+	}, nil
+}
+
+// Policy of the endpoint an upstream body is cached for
+func (s *Server) upstreamPolicy(key cacheKey) (cachePolicy, bool) {
+	path, ok := strings.CutPrefix(key.upstream, s.cfg.UpstreamURL)
+	if !ok {
+		return cachePolicy{}, false
+	}
+	for _, t := range s.locals {
+		if t.matchUpstream(path) {
+			return t.policy, true
+		}
+	}
+	return cachePolicy{}, false
+}
+
+// Cached keys below prefix, a path relative to the upstream base URL; see
+// keyHasPrefix
+func (s *Server) cachedUnder(prefix string) []string {
+	var keys []string
+	for _, info := range s.cache.Keys() {
+		if keyHasPrefix(info.Key, s.cfg.UpstreamURL, prefix) {
+			keys = append(keys, info.Key)
+		}
+	}
+	return keys
+}
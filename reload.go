@@ -0,0 +1,183 @@
+package ksk
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// Serializes LoadConfig, which points lookupEnv at the config file
+	configMu sync.Mutex
+	// Source of KSK_* variables for the env* helpers
+	lookupEnv = os.LookupEnv
+)
+
+// Config fields a reload applies to the running gateway
+var reloadableSettings = []string{
+	"CORSOrigins", "CORSMaxAge", "CORSCredentials",
+	"TTLs", "TTLMin", "TTLMax", "TTLJitter",
+	"RateLimit", "RateBurst",
+	"FallbackFiles", "Fallbacks",
+}
+
+// Settings that can change while the gateway runs
+type liveSettings struct {
+	cors      corsPolicy
+	ttls      map[string]time.Duration
+	ttlMin    time.Duration
+	ttlMax    time.Duration
+	ttlJitter float64
+	// Fallback bodies by endpoint
+	fallbacks map[string]cacheEntry
+}
+
+func newLiveSettings(cfg Config) *liveSettings {
+	return &liveSettings{
+		cors:      newCORSPolicy(cfg.CORSOrigins, cfg.CORSMaxAge, cfg.CORSCredentials),
+		ttls:      cfg.TTLs,
+		ttlMin:    cfg.TTLMin,
+		ttlMax:    cfg.TTLMax,
+		ttlJitter: cfg.TTLJitter,
+		fallbacks: fallbackEntries(cfg.Fallbacks),
+	}
+}
+
+// Read a config file of KSK_NAME=value lines. Blank lines and lines
+// starting with # are skipped; values may be double-quoted.
+func readConfigFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+	defer f.Close()
+
+	vars := map[string]string{}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || !strings.HasPrefix(key, "KSK_") || key == "KSK_CONFIG_FILE" {
+			return nil, fmt.Errorf("config file %s line %d: expected KSK_NAME=value", path, n)
+		}
+		if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+			value = value[1 : len(value)-1]
+		}
+		vars[key] = value
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+	return vars, nil
+}
+
+// Reloading a configuration that LoadConfig did not build, which would
+// replace it with defaults
+var errReloadUnsupported = errors.New("configuration was not loaded from flags and environment; embedders reload with Server.Reload")
+
+// Load the configuration again with the arguments the gateway started
+// with and apply its reloadable settings. An invalid configuration leaves
+// everything as it was. It returns the restart-only settings that changed
+// and were ignored.
+func (s *Server) reloadConfig() (ignored []string, err error) {
+	if !s.cfg.loaded {
+		log.Printf("Keeping the previous configuration: %v", errReloadUnsupported)
+		return nil, errReloadUnsupported
+	}
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	cfg, err := LoadConfig(s.cfg.args)
+	if err != nil {
+		log.Printf("Keeping the previous configuration: %v", err)
+		return nil, err
+	}
+	return s.applyConfig(cfg), nil
+}
+
+// Reload applies the reloadable settings of cfg, such as CORS, TTLs, rate
+// limits and fallbacks, to the running gateway, for embedders that build
+// their Config in code. It returns the names of the other settings that
+// differ from the ones the Server was built with; they are ignored until
+// a new Server is built.
+func (s *Server) Reload(cfg Config) (restartRequired []string) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	return s.applyConfig(cfg)
+}
+
+// Apply the reloadable settings of cfg. The caller holds s.reloadMu.
+func (s *Server) applyConfig(cfg Config) (ignored []string) {
+	s.live.Store(newLiveSettings(cfg))
+	ignored = changedSettings(s.cfg, cfg)
+	switch {
+	case s.limiter != nil && cfg.RateLimit > 0:
+		s.limiter.setLimits(cfg.RateLimit, cfg.RateBurst)
+	case (s.limiter != nil) != (cfg.RateLimit > 0):
+		// The limiter middleware is only installed at startup
+		ignored = append(ignored, "RateLimit")
+	}
+	if len(ignored) > 0 {
+		log.Printf("Configuration changes to %s take effect after a restart", strings.Join(ignored, ", "))
+	}
+	log.Printf("Reloaded configuration")
+	return ignored
+}
+
+// Names of the restart-only settings that differ between old and new
+func changedSettings(old, new Config) []string {
+	var changed []string
+	ov, nv := reflect.ValueOf(old), reflect.ValueOf(new)
+	for i := range ov.NumField() {
+		field := ov.Type().Field(i)
+		if !field.IsExported() || slices.Contains(reloadableSettings, field.Name) {
+			continue
+		}
+		if !reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+			changed = append(changed, field.Name)
+		}
+	}
+	return changed
+}
+
+// Result of POST /admin/reload
+type reloadResult struct {
+	Reloaded bool `json:"reloaded"`
+	// Changed settings that need a restart
+	RestartRequired []string `json:"restart_required"`
+}
+
+// POST /admin/reload reloads the configuration like SIGHUP
+func (s *Server) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	ignored, err := s.reloadConfig()
+	if errors.Is(err, errReloadUnsupported) {
+		writeError(w, r, http.StatusConflict, "reload_unsupported", err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, "invalid_config", err.Error())
+		return
+	}
+	if ignored == nil {
+		ignored = []string{}
+	}
+	writeJSON(w, http.StatusOK, reloadResult{Reloaded: true, RestartRequired: ignored})
+}
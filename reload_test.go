@@ -0,0 +1,81 @@
+package ksk
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Access-Control-Allow-Origin h answers a request from origin with
+func allowedOrigin(h http.Handler, origin string) string {
+	w := serve(h, http.MethodGet, "/api/v1/genres", http.Header{"Origin": {origin}})
+	return w.Header().Get("Access-Control-Allow-Origin")
+}
+
+func TestReloadProgrammaticConfig(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	s := newTestServer(t, upstream.URL, func(cfg *Config) {
+		cfg.CORSOrigins = []string{"https://a.example"}
+		cfg.TTLs["genres"] = 7 * time.Minute
+		cfg.AdminToken = "secret"
+		cfg.AdminAllow, _ = parseIPPrefixes("", []string{"192.0.2.0/24"})
+	})
+	h := s.Handler()
+
+	w := serve(h, http.MethodPost, "/admin/reload", http.Header{"Authorization": {"Bearer secret"}})
+	if w.Code != http.StatusConflict || errorCode(w.Body.String()) != "reload_unsupported" {
+		t.Errorf("reload: status %d, code %q, want 409 reload_unsupported", w.Code, errorCode(w.Body.String()))
+	}
+	if got := allowedOrigin(h, "https://b.example"); got != "" {
+		t.Errorf("after reload, other origins are allowed: %q", got)
+	}
+	if got := allowedOrigin(h, "https://a.example"); got != "https://a.example" {
+		t.Errorf("after reload, the configured origin gets %q", got)
+	}
+	if ttl := s.live.Load().ttls["genres"]; ttl != 7*time.Minute {
+		t.Errorf("after reload, genres TTL %v, want 7m", ttl)
+	}
+
+	// Embedders pass the new settings themselves
+	cfg := s.cfg
+	cfg.CORSOrigins = []string{"https://b.example"}
+	if changed := s.Reload(cfg); len(changed) != 0 {
+		t.Errorf("restart required for %v", changed)
+	}
+	if got := allowedOrigin(h, "https://b.example"); got != "https://b.example" {
+		t.Errorf("after Reload, the new origin gets %q", got)
+	}
+	if ttl := s.live.Load().ttls["genres"]; ttl != 7*time.Minute {
+		t.Errorf("after Reload, genres TTL %v, want 7m", ttl)
+	}
+}
+
+func TestReloadLoadedConfig(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	t.Setenv("KSK_UPSTREAM_URL", upstream.URL)
+	t.Setenv("KSK_CORS_ORIGINS", "https://a.example")
+	t.Setenv("KSK_ADMIN_TOKEN", "secret")
+	t.Setenv("KSK_ADMIN_ALLOW", "192.0.2.0/24")
+	cfg, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := New(cfg).Handler()
+
+	t.Setenv("KSK_CORS_ORIGINS", "https://b.example")
+	w := serve(h, http.MethodPost, "/admin/reload", http.Header{"Authorization": {"Bearer secret"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("reload: status %d, want 200: %s", w.Code, w.Body)
+	}
+	var result reloadResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.RestartRequired) != 0 {
+		t.Errorf("restart required for %v", result.RestartRequired)
+	}
+	if got := allowedOrigin(h, "https://b.example"); got != "https://b.example" {
+		t.Errorf("after reload, the new origin gets %q", got)
+	}
+}
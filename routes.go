@@ -0,0 +1,192 @@
+package ksk
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// Placeholder for the path segment routes may pass to the upstream
+	routeIDParam = "{id}"
+	// IDs accepted by routes that do not configure a pattern
+	defaultRouteIDPattern = `^[0-9]+$`
+)
+
+// Extra proxied endpoint from the route table, so new upstream endpoints
+// need no code change
+type Route struct {
+	// Local path, such as /api/v1/venues or /api/v1/venue/{id}
+	Local string
+	// Upstream path relative to the upstream URL, with {id} where the
+	// local path has it and optionally a query of fixed parameters
+	Upstream string
+	TTL      time.Duration
+	// Deadline of requests to the route, 0 for none
+	Timeout time.Duration
+	// IDs outside this pattern are rejected with a 400; nil if the route
+	// has no {id}
+	IDPattern *regexp.Regexp
+}
+
+// Entry of the route table file
+type routeSpec struct {
+	Local     string `json:"local"`
+	Upstream  string `json:"upstream"`
+	TTL       string `json:"ttl"`
+	Timeout   string `json:"timeout"`
+	IDPattern string `json:"idPattern"`
+}
+
+// Read the route table, a JSON array of routeSpec, and check every route
+// against the upstream URL
+func loadRoutes(file, upstream string) ([]Route, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read route table: %w", err)
+	}
+
+	var specs []routeSpec
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&specs); err != nil {
+		return nil, fmt.Errorf("parse route table %s: %w", file, err)
+	}
+
+	routes := make([]Route, 0, len(specs))
+	seen := map[string]bool{}
+	for _, spec := range specs {
+		route, err := spec.route(upstream)
+		if err != nil {
+			return nil, fmt.Errorf("route %q: %w", spec.Local, err)
+		}
+		if seen[route.Local] {
+			return nil, fmt.Errorf("route %q: defined twice", spec.Local)
+		}
+		seen[route.Local] = true
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+func (spec routeSpec) route(upstream string) (Route, error) {
+	route := Route{Local: spec.Local, Upstream: spec.Upstream, TTL: defaultCacheTTL}
+
+	if !strings.HasPrefix(spec.Local, "/") || strings.ContainsAny(spec.Local, " ?#") {
+		return route, fmt.Errorf("local path must be a plain absolute path")
+	}
+	hasID := strings.HasSuffix(spec.Local, "/"+routeIDParam)
+	braces := 0
+	if hasID {
+		braces = 2
+	}
+	if strings.Count(spec.Local, "{")+strings.Count(spec.Local, "}") != braces {
+		return route, fmt.Errorf("local path may only contain {id} as its last segment")
+	}
+	if strings.Contains(spec.Upstream, routeIDParam) != hasID {
+		return route, fmt.Errorf("upstream path must contain {id} exactly when the local path does")
+	}
+	if err := checkUpstreamPath(upstream, spec.Upstream); err != nil {
+		return route, err
+	}
+	// Same cache key as proxyStatic derives from it
+	if path, query, ok := strings.Cut(spec.Upstream, "?"); ok {
+		params, err := url.ParseQuery(query)
+		if err != nil {
+			return route, fmt.Errorf("invalid upstream query: %w", err)
+		}
+		route.Upstream = path + normalizedQuery(params, nil, nil)
+	}
+
+	if spec.TTL != "" {
+		ttl, err := time.ParseDuration(spec.TTL)
+		if err != nil || ttl <= 0 {
+			return route, fmt.Errorf("invalid TTL %q", spec.TTL)
+		}
+		route.TTL = ttl
+	}
+	if spec.Timeout != "" {
+		timeout, err := time.ParseDuration(spec.Timeout)
+		if err != nil || timeout < 0 {
+			return route, fmt.Errorf("invalid timeout %q", spec.Timeout)
+		}
+		route.Timeout = timeout
+	}
+
+	if hasID {
+		pattern := spec.IDPattern
+		if pattern == "" {
+			pattern = defaultRouteIDPattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return route, fmt.Errorf("invalid idPattern: %w", err)
+		}
+		route.IDPattern = re
+	} else if spec.IDPattern != "" {
+		return route, fmt.Errorf("idPattern requires {id} in the local path")
+	}
+	return route, nil
+}
+
+// Make sure an upstream path stays below the upstream URL, so the route
+// table cannot turn the gateway into an open proxy
+func checkUpstreamPath(upstream, path string) error {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.Contains(path, `\`) {
+		return fmt.Errorf("upstream path %q must be an absolute path", path)
+	}
+
+	base, err := url.Parse(upstream)
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(upstream + strings.ReplaceAll(path, routeIDParam, "0"))
+	if err != nil {
+		return fmt.Errorf("invalid upstream path %q: %w", path, err)
+	}
+	if u.Scheme != base.Scheme || u.Host != base.Host || u.User != nil || u.Fragment != "" ||
+		!strings.HasPrefix(u.Path, base.Path+"/") {
+		return fmt.Errorf("upstream path %q leaves the upstream URL", path)
+	}
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment == "." || segment == ".." {
+			return fmt.Errorf("upstream path %q leaves the upstream URL", path)
+		}
+	}
+	return nil
+}
+
+// Cache policy of the route's upstream responses
+func (route Route) policy() cachePolicy {
+	// Unknown IDs are a 404 rather than an upstream failure
+	return cachePolicy{ttl: route.TTL, maxBody: defaultMaxBodySize, notFound: route.IDPattern != nil}
+}
+
+// Serve a route from the route table through the cache. Fixed routes are
+// proxied like the built-in static endpoints.
+func (s *Server) routeHandler(route Route) http.HandlerFunc {
+	policy := route.policy()
+	if route.IDPattern == nil {
+		return s.proxyStatic(route.Upstream, policy)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isRead(r) {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		id := r.PathValue("id")
+		if !route.IDPattern.MatchString(id) || id == "." || id == ".." {
+			writeError(w, r, http.StatusBadRequest, "invalid_id", "Invalid id")
+			return
+		}
+		upstream := strings.ReplaceAll(route.Upstream, routeIDParam, url.PathEscape(id))
+		s.serveCached(w, r, s.cfg.UpstreamURL+upstream, policy)
+	}
+}
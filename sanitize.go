@@ -0,0 +1,315 @@
+package ksk
+
+import (
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Sanitization modes, see Config.Sanitize
+const (
+	sanitizeOff  = "off"
+	sanitizeText = "text"
+	sanitizeHTML = "html"
+)
+
+// Elements dropped together with their content
+var rawTextElements = map[string]bool{"script": true, "style": true}
+
+// Elements the html mode keeps; a keeps only an http(s), mailto or
+// relative href and no other attribute has any
+var allowedElements = map[string]bool{"p": true, "br": true, "a": true, "strong": true, "em": true}
+
+// Rewrites HTML in the string values of some JSON keys, such as event
+// descriptions entered by venue staff
+type sanitizer struct {
+	mode   string
+	fields map[string]bool
+}
+
+// Sanitizer for cfg, nil if sanitization is off
+func newSanitizer(cfg Config) *sanitizer {
+	if cfg.Sanitize == sanitizeOff || len(cfg.SanitizeFields) == 0 {
+		return nil
+	}
+	z := &sanitizer{mode: cfg.Sanitize, fields: map[string]bool{}}
+	for _, field := range cfg.SanitizeFields {
+		z.fields[field] = true
+	}
+	return z
+}
+
+// Sanitize the string values of the configured keys at any depth of a JSON
+// document. Other values, including non-string values of those keys, are
+// left as they are.
+func (z *sanitizer) sanitizeJSON(data []byte) ([]byte, error) {
+	doc, err := decodeJSONDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	return encodeJSONDocument(z.value(doc))
+}
+
+func (z *sanitizer) value(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, child := range v {
+			if s, ok := child.(string); ok && z.fields[key] {
+				v[key] = z.sanitize(s)
+				continue
+			}
+			v[key] = z.value(child)
+		}
+	case []any:
+		for i, child := range v {
+			v[i] = z.value(child)
+		}
+	}
+	return v
+}
+
+func (z *sanitizer) sanitize(s string) string {
+	if !strings.ContainsAny(s, "<&") {
+		return s
+	}
+	if z.mode == sanitizeText {
+		return htmlToText(s)
+	}
+	return sanitizeHTMLString(s)
+}
+
+// Plain text of an HTML fragment, with line breaks for br and after each
+// paragraph
+func htmlToText(s string) string {
+	var b strings.Builder
+	scanHTML(s, func(text string) { b.WriteString(text) }, func(t htmlTag) {
+		if t.name == "br" || t.name == "p" && t.closing {
+			b.WriteByte('\n')
+		}
+	})
+	return strings.TrimSpace(b.String())
+}
+
+// HTML fragment reduced to the allowed elements, with all text escaped and
+// every element it opens closed
+func sanitizeHTMLString(s string) string {
+	var b strings.Builder
+	var open []string
+	scanHTML(s, func(text string) { b.WriteString(html.EscapeString(text)) }, func(t htmlTag) {
+		switch {
+		case !allowedElements[t.name]:
+		case t.name == "br":
+			if !t.closing {
+				b.WriteString("<br>")
+			}
+		case !t.closing:
+			open = append(open, t.name)
+			b.WriteString("<" + t.name)
+			if t.name == "a" && t.href != "" && safeHref(t.href) {
+				b.WriteString(` href="` + html.EscapeString(t.href) + `"`)
+			}
+			b.WriteString(">")
+		default:
+			// Close everything opened since, ignoring stray end tags
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] != t.name {
+					continue
+				}
+				for len(open) > i {
+					b.WriteString("</" + open[len(open)-1] + ">")
+					open = open[:len(open)-1]
+				}
+				break
+			}
+		}
+	})
+	for len(open) > 0 {
+		b.WriteString("</" + open[len(open)-1] + ">")
+		open = open[:len(open)-1]
+	}
+	return b.String()
+}
+
+// Report whether href is a link without script: http(s), mailto or relative
+func safeHref(href string) bool {
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https", "mailto":
+		return true
+	}
+	return false
+}
+
+// Start or end tag found by scanHTML
+type htmlTag struct {
+	// Lower-case element name
+	name    string
+	closing bool
+	// Unescaped href attribute, empty if there is none
+	href string
+}
+
+// Split an HTML fragment into unescaped text and tags. Comments, doctypes
+// and the content of script and style are skipped; a < that does not
+// start a complete tag is text.
+func scanHTML(s string, text func(string), tag func(htmlTag)) {
+	for s != "" {
+		i := strings.IndexByte(s, '<')
+		if i < 0 {
+			text(html.UnescapeString(s))
+			return
+		}
+		if i > 0 {
+			text(html.UnescapeString(s[:i]))
+		}
+		s = s[i:]
+
+		switch {
+		case strings.HasPrefix(s, "<!--"):
+			end := strings.Index(s[4:], "-->")
+			if end < 0 {
+				return
+			}
+			s = s[4+end+3:]
+		case strings.HasPrefix(s, "<!"), strings.HasPrefix(s, "<?"):
+			end := strings.IndexByte(s, '>')
+			if end < 0 {
+				return
+			}
+			s = s[end+1:]
+		default:
+			t, n, ok := parseTag(s)
+			if !ok {
+				text("<")
+				s = s[1:]
+				continue
+			}
+			s = s[n:]
+			if rawTextElements[t.name] && !t.closing {
+				end := indexEndTag(s, t.name)
+				if end < 0 {
+					return
+				}
+				// The end tag is scanned next and dropped like the start tag
+				s = s[end:]
+				continue
+			}
+			tag(t)
+		}
+	}
+}
+
+// Parse the tag at the start of s, returning its length
+func parseTag(s string) (t htmlTag, n int, ok bool) {
+	i := 1
+	if i < len(s) && s[i] == '/' {
+		t.closing = true
+		i++
+	}
+	start := i
+	for i < len(s) && (isASCIILetter(s[i]) || i > start && (s[i] >= '0' && s[i] <= '9' || s[i] == '-')) {
+		i++
+	}
+	if i == start {
+		return t, 0, false
+	}
+	t.name = strings.ToLower(s[start:i])
+
+	for {
+		for i < len(s) && (isHTMLSpace(s[i]) || s[i] == '/') {
+			i++
+		}
+		if i == len(s) {
+			return t, 0, false
+		}
+		if s[i] == '>' {
+			return t, i + 1, true
+		}
+
+		start = i
+		for i < len(s) && !isHTMLSpace(s[i]) && !strings.ContainsRune("/>=", rune(s[i])) {
+			i++
+		}
+		name := strings.ToLower(s[start:i])
+		for i < len(s) && isHTMLSpace(s[i]) {
+			i++
+		}
+		if i == len(s) || s[i] != '=' {
+			continue
+		}
+		i++
+		for i < len(s) && isHTMLSpace(s[i]) {
+			i++
+		}
+		if i == len(s) {
+			return t, 0, false
+		}
+
+		var value string
+		if q := s[i]; q == '"' || q == '\'' {
+			end := strings.IndexByte(s[i+1:], q)
+			if end < 0 {
+				return t, 0, false
+			}
+			value = s[i+1 : i+1+end]
+			i += end + 2
+		} else {
+			start = i
+			for i < len(s) && !isHTMLSpace(s[i]) && s[i] != '>' {
+				i++
+			}
+			value = s[start:i]
+		}
+		if name == "href" {
+			t.href = html.UnescapeString(value)
+		}
+	}
+}
+
+// Index of the end tag of element name in s, matched case-insensitively
+func indexEndTag(s, name string) int {
+	for i := 0; ; i += 2 {
+		j := strings.Index(s[i:], "</")
+		if j < 0 {
+			return -1
+		}
+		i += j
+		if end := i + 2 + len(name); end <= len(s) && strings.EqualFold(s[i+2:end], name) {
+			return i
+		}
+	}
+}
+
+func isASCIILetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// Report whether bodies fetched with policy are sanitized
+func (s *Server) sanitizes(policy cachePolicy) bool {
+	return s.sanitizer != nil && policy.sanitize && !policy.raw
+}
+
+// Report whether r asks for the unsanitized upstream body
+func wantsRaw(r *http.Request) bool {
+	return r.URL.Query().Get("raw") == "1"
+}
+
+// Answer ?raw=1 with the upstream body as it is, fetched for this request
+// only and never cached. It is meant for debugging sanitization, so only
+// admin token holders may use it.
+func (s *Server) serveRaw(w http.ResponseWriter, r *http.Request, upstream string, policy cachePolicy) {
+	if !s.isAdmin(r) {
+		writeError(w, r, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return
+	}
+	policy.raw, policy.uncached = true, true
+	s.serveUncached(w, r, upstream, policy)
+}
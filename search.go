@@ -0,0 +1,222 @@
+package ksk
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	searchMinQuery   = 2
+	searchMaxResults = 100
+)
+
+// Relevance of a query token found in each field; whole-word matches
+// count double
+const (
+	titleWeight       = 3
+	venueWeight       = 2
+	descriptionWeight = 1
+)
+
+// Case- and umlaut-folded text of the cached events, rebuilt whenever the
+// events list is stored
+type searchIndex struct {
+	mu    sync.RWMutex
+	etag  string
+	until time.Time
+	docs  []searchDoc
+}
+
+type searchDoc struct {
+	ev                        event
+	title, venue, description string
+}
+
+// Replace the indexed events with those of the list entry
+func (x *searchIndex) rebuild(list cacheEntry, events []event) {
+	docs := make([]searchDoc, len(events))
+	for i, ev := range events {
+		docs[i] = searchDoc{
+			ev:          ev,
+			title:       foldText(ev.Title),
+			venue:       foldText(ev.Venue),
+			description: foldText(ev.Description),
+		}
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.etag, x.until, x.docs = list.etag, list.until, docs
+}
+
+// Extend the freshness of an unchanged list
+func (x *searchIndex) touch(list cacheEntry) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.etag == list.etag {
+		x.until = list.until
+	}
+}
+
+// Drop all indexed events
+func (x *searchIndex) clear() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.etag, x.until, x.docs = "", time.Time{}, nil
+}
+
+func (x *searchIndex) fresh() bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.etag != "" && time.Now().Before(x.until)
+}
+
+func (x *searchIndex) currentETag() string {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.etag
+}
+
+// Events containing every token, best matches first, and the expiry of
+// the list they come from. ok is false if nothing has been indexed yet.
+func (x *searchIndex) search(tokens []string) (results []event, until time.Time, ok bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if x.etag == "" {
+		return nil, until, false
+	}
+
+	type hit struct {
+		ev    event
+		score int
+	}
+	var hits []hit
+	for _, doc := range x.docs {
+		if score := doc.score(tokens); score > 0 {
+			hits = append(hits, hit{doc.ev, score})
+		}
+	}
+	// Stable, so equally relevant events keep the upstream order
+	slices.SortStableFunc(hits, func(a, b hit) int { return b.score - a.score })
+
+	results = make([]event, 0, min(len(hits), searchMaxResults))
+	for _, h := range hits[:min(len(hits), searchMaxResults)] {
+		results = append(results, h.ev)
+	}
+	return results, x.until, true
+}
+
+// Sum of field weights over all tokens, or 0 unless every token matches
+func (d searchDoc) score(tokens []string) int {
+	total := 0
+	for _, token := range tokens {
+		score := fieldScore(d.title, token, titleWeight) +
+			fieldScore(d.venue, token, venueWeight) +
+			fieldScore(d.description, token, descriptionWeight)
+		if score == 0 {
+			return 0
+		}
+		total += score
+	}
+	return total
+}
+
+func fieldScore(text, token string, weight int) int {
+	i := strings.Index(text, token)
+	if i < 0 {
+		return 0
+	}
+	for ; i >= 0; i = nextIndex(text, token, i) {
+		before, _ := utf8.DecodeLastRuneInString(text[:i])
+		after, _ := utf8.DecodeRuneInString(text[i+len(token):])
+		if !isWordRune(before) && !isWordRune(after) {
+			return 2 * weight
+		}
+	}
+	return weight
+}
+
+// Index of the next occurrence of token after the one at i, or -1
+func nextIndex(text, token string, i int) int {
+	j := strings.Index(text[i+1:], token)
+	if j < 0 {
+		return -1
+	}
+	return i + 1 + j
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+var umlautFolder = strings.NewReplacer("ä", "ae", "ö", "oe", "ü", "ue", "ß", "ss")
+
+// Lower-case text and spell out German umlauts, so "Müller" and "mueller"
+// match
+func foldText(s string) string {
+	return umlautFolder.Replace(strings.ToLower(s))
+}
+
+// Split a folded query into words
+func searchTokens(q string) []string {
+	return strings.FieldsFunc(foldText(q), func(r rune) bool { return !isWordRune(r) })
+}
+
+// Handle /search?q=, a full-text search over the cached events list
+func (s *Server) searchHandler(policy cachePolicy) http.HandlerFunc {
+	upstream := s.cfg.UpstreamURL + eventsPath
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isRead(r) {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		q := strings.TrimSpace(r.URL.Query().Get("q"))
+		tokens := searchTokens(q)
+		if utf8.RuneCountInString(q) < searchMinQuery || len(tokens) == 0 {
+			writeError(w, r, http.StatusBadRequest, "query_too_short", "Query must be at least 2 characters")
+			return
+		}
+
+		// Refresh the index through the cache once the list has expired;
+		// the old index keeps serving if the upstream is down
+		status := "HIT"
+		var fetchErr error
+		if !s.search.fresh() {
+			list, st, attempts, err := s.resolve(r.Context(), upstream, policy, nil)
+			if attempts > 0 {
+				w.Header().Set("X-Upstream-Attempts", strconv.Itoa(attempts))
+			}
+			status, fetchErr = st, err
+			if err != nil {
+				status = "STALE"
+			} else if list.etag != s.search.currentETag() {
+				s.indexEvents(list)
+			}
+		}
+
+		results, until, ok := s.search.search(tokens)
+		if !ok {
+			if fetchErr == nil {
+				fetchErr = errUpstreamRead
+			}
+			writeUpstreamError(w, r, fetchErr)
+			return
+		}
+
+		body := encodeEvents(results)
+		s.writeResolved(w, r, cacheEntry{
+			data:    body,
+			gzipped: compressBody(body),
+			etag:    computeETag(body),
+			until:   until,
+		}, status)
+	}
+}
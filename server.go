@@ -0,0 +1,636 @@
+package ksk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	eventsPath    = "/events?show_past=true"
+	genresPath    = "/genres"
+	locationsPath = "/locations"
+)
+
+// Server is the calendar API gateway. It owns its cache, upstream client
+// and routes, so several instances can coexist in one process.
+type Server struct {
+	cfg     Config
+	client  *http.Client
+	cache   cacheStore
+	breaker *circuitBreaker
+	limiter *rateLimiter
+	metrics *metrics
+	stats   *requestStats
+	// Rolling upstream latency for the SLO warning
+	latency *latencyTracker
+	// Picks the requests written to the access log
+	accessLog *accessLogSampler
+	// Bounds the upstream requests in flight
+	fetchLimit *fetchLimiter
+	// Upstream URLs of lists the shrink guard refused, with their policy
+	refused sync.Map
+	mux     *http.ServeMux
+	handler http.Handler
+	statics []staticTarget
+	locals  []localTarget
+	started time.Time
+	fetches flightGroup
+	drainer drainer
+	// Built from the events list whenever it is stored
+	index  atomic.Pointer[eventIndex]
+	search searchIndex
+	// Keys removed from upstream JSON, nil if none are configured
+	stripFields map[string]bool
+	// Sanitizer of event bodies, nil if sanitization is off
+	sanitizer *sanitizer
+	// Traces requests and upstream fetches, nil if tracing is off
+	tracing *tracing
+	// Notifies partner sites of list changes, nil if no webhooks are set
+	webhooks *webhookNotifier
+	// Open event streams, woken when the events list changes
+	streams *streamHub
+	// Changes to the events list for /events/changes
+	changes *changeLog
+	// Mux and handler of the admin listener; adminMux is mux and
+	// adminHandler nil if there is none
+	adminMux     *http.ServeMux
+	adminHandler http.Handler
+	// Patterns registered with handleAdmin, which check their methods
+	// themselves
+	adminRoutes map[string]bool
+
+	// Credentials sent to the upstream, nil if none are configured
+	auth *upstreamAuth
+	// Upstream base URLs in failover order
+	upstreams *upstreamPool
+	// Settings that reloadConfig may replace
+	live     atomic.Pointer[liveSettings]
+	reloadMu sync.Mutex
+
+	// Unix nanoseconds of the last successful upstream fetch, 0 if none yet
+	lastUpstreamSuccess atomic.Int64
+}
+
+// New builds a gateway for cfg, which must have passed LoadConfig's
+// validation or come from DefaultConfig
+func New(cfg Config) *Server {
+	cfg.PathPrefix = strings.TrimSuffix(cfg.PathPrefix, "/")
+	s := &Server{
+		cfg:        cfg,
+		client:     newUpstreamClient(cfg),
+		breaker:    newCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
+		mux:        http.NewServeMux(),
+		started:    time.Now(),
+		auth:       newUpstreamAuth(cfg),
+		upstreams:  newUpstreamPool(cfg.UpstreamURL, cfg.UpstreamFallbacks),
+		sanitizer:  newSanitizer(cfg),
+		webhooks:   newWebhookNotifier(cfg),
+		streams:    newStreamHub(cfg.StreamClients),
+		fetchLimit: newFetchLimiter(cfg.UpstreamConcurrency, cfg.UpstreamQueueTimeout),
+	}
+	s.cache = newCache(cfg)
+	if t, err := newTracing(cfg); err != nil {
+		log.Printf("Tracing disabled: %v", err)
+	} else {
+		s.tracing = t
+	}
+	s.live.Store(newLiveSettings(cfg))
+	if cfg.AdminToken != "" && len(cfg.AdminAllow) == 0 {
+		log.Printf("Admin endpoints refuse every request: no admin allowlist is configured")
+	}
+	s.adminMux = s.mux
+	s.adminRoutes = map[string]bool{}
+	if cfg.AdminAddr != "" {
+		s.adminMux = http.NewServeMux()
+	}
+	if len(cfg.StripFields) > 0 {
+		s.stripFields = map[string]bool{}
+		for _, field := range cfg.StripFields {
+			s.stripFields[field] = true
+		}
+	}
+	s.metrics = newMetrics(s)
+	s.stats = newRequestStats()
+	s.latency = newLatencyTracker(cfg)
+	s.accessLog = newAccessLogSampler(cfg.AccessLogSampleRate, cfg.AccessLogSlow)
+	s.changes = newChangeLog(cfg.ChangeLogSize, cfg.ChangeLogAge)
+	if cfg.RateLimit > 0 {
+		s.limiter = newRateLimiter(cfg.RateLimit, cfg.RateBurst)
+	}
+
+	s.routes()
+
+	var handler http.Handler = s.mux
+	if s.limiter != nil {
+		handler = withRateLimit(s.limiter, handler)
+	}
+	handler = s.withMethods(s.mux, handler)
+	handler = withURILimit(cfg.MaxURILength, handler)
+	handler = withCORS(func() *corsPolicy { return &s.live.Load().cors }, func(r *http.Request) bool { return s.adminRoute(s.mux, r) }, handler)
+	// Outside CORS, so error responses to panics still carry its headers
+	handler = s.withRecovery(handler)
+	// Outside recovery, so error responses to panics get them too
+	handler = withResponseHeaders(cfg.ResponseHeaders, s.mux, handler)
+	handler = s.metrics.wrap(s.mux, handler)
+	handler = withAccessLog(slog.Default(), s.accessLog, handler)
+	trust := proxyTrust{peer: cfg.TrustProxy, trusted: cfg.TrustedProxies}
+	handler = withClientIP(trust, handler)
+	s.handler = withRequestID(withPathPrefix(cfg.PathPrefix, withTracing(s.tracing, s.mux, s.drainer.wrap(handler))))
+	if cfg.VersionHeader {
+		s.handler = withVersionHeader(s.handler)
+	}
+
+	if cfg.AdminAddr != "" {
+		var admin http.Handler = s.withRecovery(s.withMethods(s.adminMux, s.adminMux))
+		admin = withAccessLog(slog.Default(), s.accessLog, admin)
+		admin = withClientIP(trust, admin)
+		s.adminHandler = withRequestID(admin)
+	}
+
+	return s
+}
+
+func (s *Server) routes() {
+	eventsPolicy := cachePolicy{endpoint: "events", maxBody: s.cfg.MaxBodySizes["events"], streamMin: s.cfg.StreamMinSize, sanitize: true, normalizeTimes: true, shrinkGuard: true, notifyChanges: true}
+	if eventsPolicy.streamMin > 0 && s.rewrites(eventsPolicy) {
+		log.Printf("Events list misses are never streamed: StripFields, Sanitize or EventTimes rewrite the body")
+	}
+	genresPolicy := cachePolicy{endpoint: "genres", maxBody: s.cfg.MaxBodySizes["genres"]}
+	// Unknown event IDs are a 404 rather than an upstream failure
+	eventPolicy := cachePolicy{endpoint: "event", maxBody: s.cfg.MaxBodySizes["event"], notFound: true, sanitize: true, normalizeTimes: true}
+	locationsPolicy := cachePolicy{endpoint: "locations", maxBody: s.cfg.MaxBodySizes["locations"]}
+	locationPolicy := cachePolicy{endpoint: "location", maxBody: s.cfg.MaxBodySizes["location"], notFound: true}
+
+	var jsonpParams []apiParam
+	if s.cfg.JSONP {
+		jsonpParams = []apiParam{{name: "callback", description: "Wrap the list in a call to this JavaScript function, served as application/javascript", schema: "string"}}
+	}
+
+	// Public API, also described by the OpenAPI document. Each route takes
+	// the deadline of the upstream endpoint it is built on.
+	api := []apiRoute{
+		// Static endpoints
+		{pattern: "/api/v1/events", handler: s.withJSONP(s.eventsHandler(eventsPolicy)), timeout: s.cfg.Timeouts["events"], docs: []apiOperation{{
+			path:    "/api/v1/events",
+			summary: "List events, optionally filtered and paginated",
+			params:  slices.Concat(eventFilterParams, eventPageParams, jsonpParams),
+		}}},
+		{pattern: "/api/v1/genres", handler: s.withJSONP(s.proxyStatic(genresPath, genresPolicy)), timeout: s.cfg.Timeouts["genres"], docs: []apiOperation{{
+			path: "/api/v1/genres", summary: "List genres", params: jsonpParams,
+		}}},
+		{pattern: "/api/v1/locations", handler: s.proxyStatic(locationsPath, locationsPolicy), timeout: s.cfg.Timeouts["locations"], docs: []apiOperation{{
+			path: "/api/v1/locations", summary: "List venues",
+		}}},
+
+		// Several event details in one request
+		{pattern: "/api/v1/events/batch", handler: s.batchHandler(eventPolicy), timeout: s.cfg.Timeouts["event"], docs: []apiOperation{{
+			path:    "/api/v1/events/batch",
+			summary: "Look up several events; IDs that failed map to null and are explained under errors",
+			params:  []apiParam{{name: "ids", description: fmt.Sprintf("Comma-separated event IDs, at most %d", maxBatchIDs), schema: "string", required: true}},
+		}}},
+
+		// Events per day for calendar grids
+		{pattern: "/api/v1/events/by-day", handler: s.byDayHandler(eventsPolicy), timeout: s.cfg.Timeouts["events"], docs: []apiOperation{{
+			path:    "/api/v1/events/by-day",
+			summary: fmt.Sprintf("Events grouped by the days they take place on, for ranges of up to %d days", maxByDayRange),
+			params: []apiParam{
+				{name: "from", description: "First day", schema: "string:date", required: true},
+				{name: "to", description: "Last day", schema: "string:date", required: true},
+			},
+		}}},
+
+		// Counts for teasers that need no list
+		{pattern: "/api/v1/events/summary", handler: s.summaryHandler(eventsPolicy, genresPolicy), timeout: s.cfg.Timeouts["events"], docs: []apiOperation{{
+			path:    "/api/v1/events/summary",
+			summary: "Number of events and upcoming events, upcoming events per genre and the next start time",
+		}}},
+
+		// Changes to the events list for partners syncing incrementally
+		{pattern: "/api/v1/events/changes", handler: s.eventChangesHandler(eventsPolicy), timeout: s.cfg.Timeouts["events"], docs: []apiOperation{{
+			path:    "/api/v1/events/changes",
+			summary: "Events added, removed or modified since a time; resync is set if the change log does not reach back that far",
+			params:  []apiParam{{name: "since", description: "Time after which changes are listed", schema: "string:date-time", required: true}},
+		}}},
+
+		// Events list pushed on change, for displays that would poll it
+		{pattern: "/api/v1/events/stream", handler: s.eventStreamHandler(eventsPolicy), timeout: s.cfg.Timeouts["events"], docs: []apiOperation{{
+			path:        "/api/v1/events/stream",
+			summary:     "Server-Sent Events carrying the events list on connect and whenever it changes",
+			contentType: "text/event-stream",
+		}}},
+
+		// Full-text search over the events list
+		{pattern: "/api/v1/search", handler: s.searchHandler(eventsPolicy), timeout: s.cfg.Timeouts["events"], docs: []apiOperation{{
+			path:    "/api/v1/search",
+			summary: "Full-text search over the events",
+			params:  []apiParam{{name: "q", description: "Search query of at least 2 characters", schema: "string", required: true}},
+		}}},
+
+		// iCalendar feed of the events list for calendar subscriptions
+		{pattern: "/api/v1/events.ics", handler: s.icsHandler(eventsPolicy), timeout: s.cfg.Timeouts["events"], docs: []apiOperation{{
+			path: "/api/v1/events.ics", summary: "Events as an iCalendar feed", contentType: "text/calendar",
+		}}},
+		// RSS feed of upcoming events for CMS feed widgets
+		{pattern: "/api/v1/events.rss", handler: s.rssHandler(eventsPolicy), timeout: s.cfg.Timeouts["events"], docs: []apiOperation{{
+			path: "/api/v1/events.rss", summary: "Upcoming events as an RSS feed", contentType: "application/rss+xml",
+		}}},
+		// CSV export for spreadsheets
+		{pattern: "/api/v1/events.csv", handler: s.csvHandler(eventsPolicy), timeout: s.cfg.Timeouts["events"], docs: []apiOperation{{
+			path:        "/api/v1/events.csv",
+			summary:     "Events as a CSV download",
+			contentType: "text/csv",
+			params: append(slices.Clip(eventFilterParams),
+				apiParam{name: "bom", description: "Start with a byte order mark for spreadsheet programs when 1", schema: "string"}),
+		}}},
+
+		// Venues with their events for map views
+		{pattern: "/api/v1/events.geojson", handler: s.geoJSONHandler(eventsPolicy), timeout: s.cfg.Timeouts["events"], docs: []apiOperation{{
+			path:        "/api/v1/events.geojson",
+			summary:     "Venues of the events as a GeoJSON FeatureCollection, one Point per venue with its events",
+			contentType: "application/geo+json",
+			params:      eventFilterParams,
+		}}},
+
+		// Dynamic endpoint (event details and accessibility)
+		{pattern: "/api/v1/event/", handler: s.eventHandler(eventPolicy), timeout: s.cfg.Timeouts["event"], docs: []apiOperation{
+			{path: "/api/v1/event/{id}", summary: "Event details", params: []apiParam{pathIDParam}, notFound: true},
+			{path: "/api/v1/event/{id}/accessibility", summary: "Accessibility information of an event", params: []apiParam{pathIDParam}, notFound: true},
+		}},
+		// Events at one venue for printed programs
+		{pattern: "/api/v1/venues/", handler: s.venueEventsHandler(eventsPolicy, locationPolicy), timeout: s.cfg.Timeouts["events"], docs: []apiOperation{{
+			path:    "/api/v1/venues/{id}/events",
+			summary: "Events at a venue sorted by start time; a venue that has no events and is unknown upstream is not found",
+			params: []apiParam{pathIDParam,
+				{name: "from", description: "Only events starting on or after this date", schema: "string:date"},
+				{name: "to", description: "Only events starting on or before this date", schema: "string:date"},
+			},
+			notFound: true,
+		}}},
+		// Venue details with accessibility metadata
+		{pattern: "/api/v1/location/", handler: s.locationHandler(locationPolicy), timeout: s.cfg.Timeouts["location"], docs: []apiOperation{{
+			path: "/api/v1/location/{id}", summary: "Venue details", params: []apiParam{pathIDParam}, notFound: true,
+		}}},
+	}
+
+	// Endpoints from the route table; a local path that clashes with a
+	// built-in one makes the mux panic at startup
+	for _, route := range s.cfg.Routes {
+		api = append(api, apiRoute{
+			pattern: route.Local,
+			handler: s.routeHandler(route),
+			timeout: route.Timeout,
+			docs:    []apiOperation{route.apiOperation()},
+		})
+	}
+
+	patterns := make(map[string]bool, len(api)+1)
+	for _, route := range api {
+		s.mux.HandleFunc(route.pattern, withTimeout(route.timeout, s.withLanguageVary(route.handler)))
+		patterns[route.pattern] = true
+	}
+	// Unknown API paths get the JSON error envelope rather than the mux's
+	// plain text 404
+	s.mux.HandleFunc("/api/v1/", writeNotFound)
+	patterns["/api/v1/"] = true
+	// The mux would redirect a subtree's path without its trailing slash,
+	// such as /api/v1/event, to the subtree, and fetch() with CORS fails on
+	// the redirect
+	for pattern := range patterns {
+		if base, ok := strings.CutSuffix(pattern, "/"); ok && !patterns[base] {
+			s.mux.HandleFunc(base, writeNotFound)
+		}
+	}
+	s.mux.HandleFunc("/api/v1/openapi.json", openAPIHandler(api, s.cfg.PathPrefix))
+	if s.cfg.APIDocs {
+		s.mux.HandleFunc("/api/v1/docs", apiDocsHandler)
+	}
+
+	// Health checks for the load balancer, never served from the cache.
+	// They stay on the public listener, which is the one balanced.
+	s.mux.HandleFunc("/healthz", s.healthHandler)
+	s.mux.HandleFunc("/readyz", s.readyHandler())
+	if s.adminMux != s.mux {
+		s.adminMux.HandleFunc("/healthz", s.healthHandler)
+		s.adminMux.HandleFunc("/readyz", s.readyHandler())
+	}
+
+	// Build of the running gateway
+	s.mux.HandleFunc("/version", versionHandler)
+
+	// Event pages for search engines, when their public URL is known
+	if s.cfg.SitemapURLTemplate != "" {
+		s.mux.HandleFunc("/sitemap.xml", withTimeout(s.cfg.Timeouts["events"], s.sitemapHandler(eventsPolicy)))
+	}
+
+	// Prometheus metrics
+	s.adminMux.Handle("/metrics", s.metrics.handler())
+
+	// Cache administration, only reachable from the admin allowlist with
+	// the admin token. Unknown admin paths take the same checks before
+	// their 404, so they reveal nothing either.
+	if s.cfg.AdminToken != "" {
+		s.handleAdmin("/admin/", writeNotFound)
+		s.handleAdmin("/admin/cache/purge", s.purgeHandler)
+		s.handleAdmin("/admin/cache/keys", s.keysHandler)
+		s.handleAdmin("/admin/cache/accept", s.acceptHandler)
+		s.handleAdmin("/admin/cache/refresh", s.refreshHandler)
+		s.handleAdmin("/admin/reload", s.reloadHandler)
+		s.handleAdmin("/admin/stats", s.statsHandler)
+		s.handleAdmin("/admin/stats/reset", s.statsResetHandler)
+	}
+
+	// Profiling and runtime stats, only when enabled and with the admin token
+	if s.cfg.Debug {
+		s.debugRoutes()
+	}
+
+	s.statics = []staticTarget{
+		{upstream: s.cfg.UpstreamURL + eventsPath, policy: eventsPolicy},
+		{upstream: s.cfg.UpstreamURL + genresPath, policy: genresPolicy},
+		{upstream: s.cfg.UpstreamURL + locationsPath, policy: locationsPolicy},
+	}
+
+	// Fixed routes from the route table are static targets as well
+	for _, route := range s.cfg.Routes {
+		if route.IDPattern == nil {
+			s.statics = append(s.statics, staticTarget{
+				upstream: s.cfg.UpstreamURL + route.Upstream,
+				policy:   route.policy(),
+			})
+		}
+	}
+
+	// Local paths of the upstream data they are served from, the events
+	// list's views included
+	s.locals = []localTarget{
+		{local: "/api/v1/genres", upstream: genresPath, policy: genresPolicy},
+		{local: "/api/v1/locations", upstream: locationsPath, policy: locationsPolicy},
+		{local: "/api/v1/event/{id}", upstream: "/event/{id}", id: idRegex, policy: eventPolicy},
+		{local: "/api/v1/event/{id}/accessibility", upstream: "/event/{id}/accessibility", id: idRegex, policy: eventPolicy},
+		{local: "/api/v1/location/{id}", upstream: "/location/{id}", id: idRegex, policy: locationPolicy},
+		{local: "/api/v1/venues/{id}/events", upstream: eventsPath, id: idRegex, policy: eventsPolicy},
+	}
+	for _, local := range []string{"/api/v1/events", "/api/v1/events/by-day", "/api/v1/events/stream", "/api/v1/events/summary", "/api/v1/search", "/api/v1/events.ics", "/api/v1/events.rss", "/api/v1/events.csv", "/api/v1/events.geojson"} {
+		s.locals = append(s.locals, localTarget{local: local, upstream: eventsPath, policy: eventsPolicy})
+	}
+	if s.cfg.SitemapURLTemplate != "" {
+		s.locals = append(s.locals, localTarget{local: "/sitemap.xml", upstream: eventsPath, policy: eventsPolicy})
+	}
+	for _, route := range s.cfg.Routes {
+		s.locals = append(s.locals, localTarget{local: route.Local, upstream: route.Upstream, id: route.IDPattern, policy: route.policy()})
+	}
+}
+
+// Cache backend selected by the configuration
+func newCache(cfg Config) cacheStore {
+	if cfg.CacheBackend == "redis" {
+		return newRedisCache(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB, cfg.RedisPrefix+cacheNamespace())
+	}
+	return newLRUCache(cfg.CacheMaxEntries, cfg.CacheMaxBytes)
+}
+
+// Handler returns the gateway's HTTP handler including all middleware
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+// AdminHandler returns the handler of /metrics, the health checks and the
+// admin and debug endpoints if Config.AdminAddr moves them off Handler,
+// and nil otherwise
+func (s *Server) AdminHandler() http.Handler {
+	return s.adminHandler
+}
+
+// ListenAndServe restores and warms the cache, serves on the configured address and
+// runs the background workers until ctx is cancelled, then shuts down
+// gracefully within the configured grace period
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	server := &http.Server{
+		Handler:           s.handler,
+		ReadHeaderTimeout: s.cfg.ReadHeaderTimeout,
+		ReadTimeout:       5 * time.Second,
+		WriteTimeout:      15 * time.Second,
+		IdleTimeout:       30 * time.Second,
+		MaxHeaderBytes:    s.cfg.MaxHeaderBytes,
+	}
+
+	// Load the certificate before anything else so a bad one stops startup
+	var certs *certReloader
+	if s.cfg.TLSCert != "" {
+		var err error
+		if certs, err = newCertReloader(s.cfg.TLSCert, s.cfg.TLSKey); err != nil {
+			return err
+		}
+		server.TLSConfig = newTLSConfig(certs)
+	}
+	if err := s.prepareFixtures(); err != nil {
+		return err
+	}
+
+	s.loadSnapshot()
+	if s.cfg.WarmTimeout > 0 && !s.offline() {
+		s.warmCache(s.statics, s.cfg.WarmTimeout)
+	}
+
+	// Shutdown closes the listener, which also removes a Unix socket file
+	ln, err := listen(s.cfg.ListenAddr, s.cfg.SocketMode)
+	if err != nil {
+		return err
+	}
+	var admin *http.Server
+	var adminLn net.Listener
+	if s.adminHandler != nil {
+		if adminLn, err = listen(s.cfg.AdminAddr, s.cfg.SocketMode); err != nil {
+			ln.Close()
+			return err
+		}
+		admin = &http.Server{
+			Handler:           s.adminHandler,
+			ReadHeaderTimeout: s.cfg.ReadHeaderTimeout,
+			ReadTimeout:       5 * time.Second,
+			WriteTimeout:      15 * time.Second,
+			IdleTimeout:       30 * time.Second,
+			MaxHeaderBytes:    s.cfg.MaxHeaderBytes,
+		}
+	}
+
+	// Event streams never go idle, so Shutdown would wait for its deadline
+	server.RegisterOnShutdown(s.streams.close)
+
+	// Background workers stop as soon as ctx is cancelled
+	var background sync.WaitGroup
+	s.startBackground(ctx, &background)
+
+	source := "upstream " + s.cfg.UpstreamURL
+	if s.offline() {
+		source = "fixtures from " + s.cfg.FixturesDir
+	}
+
+	serveErr := make(chan error, 3)
+	go func() {
+		if certs == nil {
+			log.Printf("Calendar API Gateway %s running on %s (%s)", currentBuild(), s.cfg.ListenAddr, source)
+			serveErr <- server.Serve(ln)
+			return
+		}
+		log.Printf("Calendar API Gateway %s running on %s with TLS (%s)", currentBuild(), s.cfg.ListenAddr, source)
+		// The certificate comes from TLSConfig.GetCertificate
+		serveErr <- server.ServeTLS(ln, "", "")
+	}()
+
+	if admin != nil {
+		go func() {
+			log.Printf("Serving metrics, health checks and admin endpoints on %s", s.cfg.AdminAddr)
+			serveErr <- admin.Serve(adminLn)
+		}()
+	}
+
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		s.reloadOnHangup(ctx, certs)
+	}()
+
+	var redirect *http.Server
+	if certs != nil {
+		if s.cfg.RedirectAddr != "" {
+			redirect = &http.Server{
+				Addr:              s.cfg.RedirectAddr,
+				Handler:           httpsRedirect(s.cfg.ListenAddr),
+				ReadHeaderTimeout: s.cfg.ReadHeaderTimeout,
+				ReadTimeout:       5 * time.Second,
+				WriteTimeout:      5 * time.Second,
+				MaxHeaderBytes:    s.cfg.MaxHeaderBytes,
+			}
+			go func() {
+				log.Printf("Redirecting plain HTTP on %s to HTTPS", s.cfg.RedirectAddr)
+				serveErr <- redirect.ListenAndServe()
+			}()
+		}
+	}
+
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			if redirect != nil {
+				redirect.Close()
+			}
+			if admin != nil {
+				admin.Close()
+			}
+			server.Close()
+			return err
+		}
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down, waiting up to %s for in-flight requests", s.cfg.ShutdownTimeout)
+	if redirect != nil {
+		// Redirects are answered at once, nothing to drain
+		redirect.Close()
+	}
+	s.drainer.shutdown(server, s.cfg.ShutdownTimeout)
+	// Kept until the public listener is drained, so metrics can be
+	// scraped meanwhile
+	if admin != nil {
+		adminCtx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+		if err := admin.Shutdown(adminCtx); err != nil {
+			admin.Close()
+		}
+		cancel()
+	}
+	background.Wait()
+	// Saved last, so it has everything the drained requests fetched
+	s.saveSnapshot()
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), tracingFlushTimeout)
+	defer cancel()
+	if err := s.tracing.shutdown(flushCtx); err != nil {
+		log.Printf("Exporting the last spans failed: %v", err)
+	}
+	return nil
+}
+
+// Reload the TLS certificate, if any, file-based upstream credentials and
+// the configuration whenever the process gets SIGHUP, until ctx is done
+func (s *Server) reloadOnHangup(ctx context.Context, certs *certReloader) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+
+		if certs != nil {
+			if err := certs.reload(); err != nil {
+				log.Printf("Keeping the previous TLS certificate: %v", err)
+			} else {
+				log.Printf("Reloaded TLS certificate from %s", certs.certFile)
+			}
+		}
+		s.auth.reload()
+		s.reloadConfig()
+	}
+}
+
+// Start prefetching, cache sweeps, webhook deliveries and limiter
+// eviction, tracked in wg
+func (s *Server) startBackground(ctx context.Context, wg *sync.WaitGroup) {
+	if s.cfg.Prefetch && !s.offline() {
+		s.startPrefetch(ctx, wg, s.statics)
+	}
+
+	// Redis expires entries itself; the in-memory cache needs sweeping
+	if lru, ok := s.cache.(*lruCache); ok {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lru.sweepLoop(ctx, s.cfg.CacheSweepInterval)
+		}()
+	}
+
+	if s.webhooks != nil {
+		s.webhooks.run(ctx, wg)
+	}
+
+	if s.limiter != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.limiter.evictLoop(ctx, time.Minute)
+		}()
+	}
+
+	if s.cfg.LatencyThreshold > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.latency.checkLoop(ctx, slog.Default(), latencyCheckInterval)
+		}()
+	}
+
+	if s.accessLog.rate < 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.accessLog.reportLoop(ctx, slog.Default(), accessLogReportInterval)
+		}()
+	}
+}
@@ -0,0 +1,303 @@
+package ksk
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Events list served by the fake upstream
+const testEvents = `[
+	{"id": 1, "title": "Hamlet", "start": "2030-03-01T19:30:00", "end": "2030-03-01T22:00:00", "venue": {"id": 10, "name": "Theater"}, "genres": [3]},
+	{"id": 2, "title": "Orgelnacht", "start": "2030-03-02", "venue": {"id": 11, "name": "Dom"}, "genres": [4]}
+]`
+
+// Calendar API stand-in that counts the requests per path. Paths it has
+// no body for are a 404 unless handler answers them.
+type fakeUpstream struct {
+	*httptest.Server
+	bodies map[string]string
+
+	mu      sync.Mutex
+	hits    map[string]int
+	handler http.HandlerFunc
+}
+
+func newFakeUpstream(t testing.TB) *fakeUpstream {
+	u := &fakeUpstream{
+		bodies: map[string]string{
+			"/events":      testEvents,
+			"/genres":      `[{"id": 3, "name": "Theater"}, {"id": 4, "name": "Musik"}]`,
+			"/locations":   `[{"id": 10, "name": "Theater"}, {"id": 11, "name": "Dom"}]`,
+			"/event/1":     `{"id": 1, "title": "Hamlet", "start": "2030-03-01T19:30:00"}`,
+			"/location/10": `{"id": 10, "name": "Theater"}`,
+		},
+		hits: map[string]int{},
+	}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.mu.Lock()
+		u.hits[r.URL.Path]++
+		handler := u.handler
+		u.mu.Unlock()
+		if handler != nil {
+			handler(w, r)
+			return
+		}
+		u.serve(w, r)
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
+
+// Answer r from the canned bodies
+func (u *fakeUpstream) serve(w http.ResponseWriter, r *http.Request) {
+	body, ok := u.bodies[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, body)
+}
+
+// Replace the upstream's behaviour; u.serve gives the default one
+func (u *fakeUpstream) handle(handler http.HandlerFunc) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.handler = handler
+}
+
+// Number of requests the upstream got for path
+func (u *fakeUpstream) count(path string) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.hits[path]
+}
+
+// Configuration for a gateway in front of upstream, without background
+// work, changed by configure if not nil
+func testConfig(upstream string, configure func(*Config)) Config {
+	cfg := DefaultConfig()
+	cfg.UpstreamURL = upstream
+	cfg.Prefetch = false
+	cfg.WarmTimeout = 0
+	if configure != nil {
+		configure(&cfg)
+	}
+	return cfg
+}
+
+// Gateway in front of upstream
+func newTestServer(t testing.TB, upstream string, configure func(*Config)) *Server {
+	t.Helper()
+	return New(testConfig(upstream, configure))
+}
+
+// Serve a request through h and return the recorded response
+func serve(h http.Handler, method, target string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	for key, values := range header {
+		r.Header[key] = values
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// Error code of a JSON error envelope, "" if body is none
+func errorCode(body string) string {
+	var env errorEnvelope
+	if err := json.Unmarshal([]byte(body), &env); err != nil {
+		return ""
+	}
+	return env.Error.Code
+}
+
+func TestServeCachedHitAndMiss(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestServer(t, upstream.URL, nil).Handler()
+
+	for i, want := range []string{"MISS", "HIT", "HIT"} {
+		w := serve(h, http.MethodGet, "/api/v1/genres", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d, want 200", i, w.Code)
+		}
+		if got := w.Header().Get("X-Cache"); got != want {
+			t.Errorf("request %d: X-Cache %q, want %q", i, got, want)
+		}
+		if !strings.Contains(w.Body.String(), "Musik") {
+			t.Errorf("request %d: body %q lacks the upstream's", i, w.Body)
+		}
+	}
+	if n := upstream.count("/genres"); n != 1 {
+		t.Errorf("upstream fetched /genres %d times, want 1", n)
+	}
+}
+
+func TestServeCachedNotModified(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestServer(t, upstream.URL, nil).Handler()
+
+	w := serve(h, http.MethodGet, "/api/v1/locations", nil)
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+	w = serve(h, http.MethodGet, "/api/v1/locations", http.Header{"If-None-Match": {etag}})
+	if w.Code != http.StatusNotModified {
+		t.Errorf("status %d, want 304", w.Code)
+	}
+}
+
+func TestMethodRejected(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestServer(t, upstream.URL, nil).Handler()
+
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodPatch} {
+		w := serve(h, method, "/api/v1/events", nil)
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s: status %d, want 405", method, w.Code)
+		}
+		if allow := w.Header().Get("Allow"); allow != "GET, HEAD, OPTIONS" {
+			t.Errorf("%s: Allow %q", method, allow)
+		}
+		if code := errorCode(w.Body.String()); code != "method_not_allowed" {
+			t.Errorf("%s: error code %q", method, code)
+		}
+	}
+	if n := upstream.count("/events"); n != 0 {
+		t.Errorf("upstream fetched /events %d times, want 0", n)
+	}
+}
+
+func TestIDValidation(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestServer(t, upstream.URL, nil).Handler()
+
+	tests := []struct {
+		target string
+		status int
+		code   string
+	}{
+		{"/api/v1/event/1", http.StatusOK, ""},
+		{"/api/v1/event/abc", http.StatusBadRequest, "invalid_event_id"},
+		{"/api/v1/event/-1", http.StatusBadRequest, "invalid_event_id"},
+		{"/api/v1/event/1234567890123456789", http.StatusBadRequest, "invalid_event_id"},
+		{"/api/v1/event/2", http.StatusNotFound, "not_found"},
+		{"/api/v1/location/10", http.StatusOK, ""},
+		{"/api/v1/location/x10", http.StatusBadRequest, "invalid_location_id"},
+	}
+	for _, tt := range tests {
+		w := serve(h, http.MethodGet, tt.target, nil)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.target, w.Code, tt.status)
+		}
+		if tt.code != "" {
+			if code := errorCode(w.Body.String()); code != tt.code {
+				t.Errorf("%s: error code %q, want %q", tt.target, code, tt.code)
+			}
+		}
+	}
+	for _, path := range []string{"/event/abc", "/event/-1", "/location/x10"} {
+		if n := upstream.count(path); n != 0 {
+			t.Errorf("upstream got %d requests for %s", n, path)
+		}
+	}
+}
+
+func TestErrorEnvelopes(t *testing.T) {
+	failWith := func(status int) func(*testing.T, *Server, *fakeUpstream) {
+		return func(t *testing.T, s *Server, u *fakeUpstream) {
+			u.handle(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(status) })
+		}
+	}
+	// Upstream that answers nothing until the test is over
+	hang := func(t *testing.T, s *Server, u *fakeUpstream) {
+		release := make(chan struct{})
+		t.Cleanup(func() { close(release) })
+		u.handle(func(w http.ResponseWriter, r *http.Request) { <-release })
+	}
+
+	tests := []struct {
+		name      string
+		configure func(*Config)
+		setup     func(*testing.T, *Server, *fakeUpstream)
+		target    string
+		status    int
+		code      string
+		class     string
+	}{
+		{"upstream error", nil, failWith(http.StatusInternalServerError),
+			"/api/v1/genres", http.StatusBadGateway, "upstream_error", "status"},
+		{"upstream invalid", nil, func(t *testing.T, s *Server, u *fakeUpstream) { u.bodies["/genres"] = `[{"id": 3,` },
+			"/api/v1/genres", http.StatusBadGateway, "upstream_invalid_response", "invalid"},
+		{"upstream timeout", func(cfg *Config) { cfg.UpstreamHeaderTimeout = 20 * time.Millisecond }, hang,
+			"/api/v1/genres", http.StatusGatewayTimeout, "upstream_timeout", "timeout"},
+		{"circuit open", func(cfg *Config) { cfg.BreakerThreshold = 1 }, func(t *testing.T, s *Server, u *fakeUpstream) {
+			failWith(http.StatusInternalServerError)(t, s, u)
+			serve(s.Handler(), http.MethodGet, "/api/v1/locations", nil)
+		}, "/api/v1/genres", http.StatusBadGateway, "upstream_unavailable", "unavailable"},
+		{"upstream busy", func(cfg *Config) {
+			cfg.UpstreamConcurrency = 1
+			cfg.UpstreamQueueTimeout = 10 * time.Millisecond
+		}, func(t *testing.T, s *Server, u *fakeUpstream) {
+			if err := s.fetchLimit.acquire(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(s.fetchLimit.release)
+		}, "/api/v1/genres", http.StatusServiceUnavailable, "upstream_busy", ""},
+		{"route timeout", func(cfg *Config) { cfg.Timeouts["genres"] = 20 * time.Millisecond }, hang,
+			"/api/v1/genres", http.StatusGatewayTimeout, "timeout", ""},
+		{"processing failed", nil, func(t *testing.T, s *Server, u *fakeUpstream) { u.bodies["/events"] = `{"id": 1}` },
+			"/api/v1/events?genre=3", http.StatusBadGateway, "processing_failed", ""},
+		{"invalid parameter", nil, nil,
+			"/api/v1/events?limit=many", http.StatusBadRequest, "invalid_parameter", ""},
+		{"rate limited", func(cfg *Config) { cfg.RateLimit, cfg.RateBurst = 1, 1 }, func(t *testing.T, s *Server, u *fakeUpstream) {
+			serve(s.Handler(), http.MethodGet, "/api/v1/genres", nil)
+		}, "/api/v1/genres", http.StatusTooManyRequests, "rate_limited", ""},
+		{"too many streams", func(cfg *Config) { cfg.StreamClients = 1 }, func(t *testing.T, s *Server, u *fakeUpstream) {
+			updates, ok := s.streams.subscribe()
+			if !ok {
+				t.Fatal("no stream slot")
+			}
+			t.Cleanup(func() { s.streams.unsubscribe(updates) })
+		}, "/api/v1/events/stream", http.StatusServiceUnavailable, "too_many_streams", ""},
+		{"shutting down", nil, func(t *testing.T, s *Server, u *fakeUpstream) { s.drainer.draining.Store(true) },
+			"/api/v1/genres", http.StatusServiceUnavailable, "shutting_down", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			s := newTestServer(t, upstream.URL, func(cfg *Config) {
+				cfg.UpstreamRetries = 0
+				if tt.configure != nil {
+					tt.configure(cfg)
+				}
+			})
+			if tt.setup != nil {
+				tt.setup(t, s, upstream)
+			}
+
+			w := serve(s.Handler(), http.MethodGet, tt.target, nil)
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d", w.Code, tt.status)
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+				t.Errorf("Content-Type %q", ct)
+			}
+			var env errorEnvelope
+			if err := json.Unmarshal(w.Body.Bytes(), &env); err != nil {
+				t.Fatalf("body %q: %v", w.Body, err)
+			}
+			if env.Error.Code != tt.code || env.Error.Class != tt.class || env.Error.Status != tt.status || env.Error.Message == "" {
+				t.Errorf("error %+v, want code %q, class %q and status %d", env.Error, tt.code, tt.class, tt.status)
+			}
+		})
+	}
+}
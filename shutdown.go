@@ -0,0 +1,52 @@
+package ksk
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Tracks in-flight requests and rejects new ones once shutdown has begun
+type drainer struct {
+	inFlight atomic.Int64
+	draining atomic.Bool
+}
+
+func (d *drainer) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d.draining.Load() {
+			w.Header().Set("Connection", "close")
+			writeError(w, r, http.StatusServiceUnavailable, "shutting_down", "Server shutting down")
+			return
+		}
+
+		d.inFlight.Add(1)
+		defer d.inFlight.Add(-1)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Stop accepting requests and wait up to timeout for in-flight ones to finish
+func (d *drainer) shutdown(server *http.Server, timeout time.Duration) {
+	d.draining.Store(true)
+	pending := d.inFlight.Load()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := server.Shutdown(ctx)
+	remaining := d.inFlight.Load()
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		log.Printf("Shutdown deadline of %s hit: drained %d of %d in-flight requests", timeout, pending-remaining, pending)
+	case err != nil:
+		log.Printf("Shutdown error: %v (drained %d of %d in-flight requests)", err, pending-remaining, pending)
+	default:
+		log.Printf("Shutdown complete: drained %d in-flight requests", pending)
+	}
+}
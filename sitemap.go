@@ -0,0 +1,80 @@
+package ksk
+
+import (
+	"encoding/xml"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Placeholder for the event ID in Config.SitemapURLTemplate
+	sitemapIDPlaceholder = "{id}"
+	// Most URLs one sitemap may list, per sitemaps.org
+	sitemapMaxURLs = 50000
+)
+
+// Sitemap document of the sitemaps.org protocol
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// Handle /sitemap.xml, the public pages of the events for search engines.
+// It is derived from the cached events list and so rebuilt whenever the
+// list changes.
+func (s *Server) sitemapHandler(policy cachePolicy) http.HandlerFunc {
+	build := func(source cacheEntry) ([]byte, string, error) {
+		events, err := decodeEvents(source.data)
+		if err != nil {
+			return nil, "", err
+		}
+		body, err := buildSitemap(events, s.cfg.SitemapURLTemplate)
+		return body, "application/xml; charset=utf-8", err
+	}
+	view := derivedView{name: "sitemap", build: build}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isRead(r) {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+		s.serveDerived(w, r, s.cfg.UpstreamURL+eventsPath, policy, view)
+	}
+}
+
+// Render one URL per event, at most sitemapMaxURLs, with the ID escaped
+// into the template and the upstream's last change as lastmod
+func buildSitemap(events []event, template string) ([]byte, error) {
+	set := sitemapURLSet{URLs: make([]sitemapURL, 0, min(len(events), sitemapMaxURLs))}
+	seen := make(map[string]bool, len(events))
+	for _, ev := range events {
+		if ev.ID == "" || seen[ev.ID] {
+			continue
+		}
+		seen[ev.ID] = true
+		if len(set.URLs) == sitemapMaxURLs {
+			log.Printf("Sitemap lists only the first %d of %d events", sitemapMaxURLs, len(events))
+			break
+		}
+
+		u := sitemapURL{Loc: strings.ReplaceAll(template, sitemapIDPlaceholder, url.PathEscape(ev.ID))}
+		if !ev.Updated.IsZero() {
+			u.LastMod = ev.Updated.Format(time.RFC3339)
+		}
+		set.URLs = append(set.URLs, u)
+	}
+
+	body, err := xml.MarshalIndent(set, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
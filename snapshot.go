@@ -0,0 +1,161 @@
+package ksk
+
+import (
+	"compress/gzip"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Format of snapshot files; files of any other version are ignored
+const snapshotVersion = 1
+
+// Start of a snapshot file, followed by the entries
+type snapshotHeader struct {
+	Version int
+	// cacheSchemaVersion of the entries, 0 in snapshots from before it
+	// was recorded
+	Schema int
+	Saved  time.Time
+}
+
+// Cache entry in a snapshot with the point it is dropped from the cache
+type snapshotItem struct {
+	Key     string
+	Entry   storedEntry
+	Expires time.Time
+}
+
+// Entries still within their retention, least recently used first
+func (c *lruCache) snapshot() []snapshotItem {
+	now := time.Now()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	items := make([]snapshotItem, 0, c.order.Len())
+	for el := c.order.Back(); el != nil; el = el.Prev() {
+		item := el.Value.(*lruItem)
+		if now.After(item.expires) {
+			continue
+		}
+		items = append(items, snapshotItem{Key: item.key, Entry: newStoredEntry(item.entry), Expires: item.expires})
+	}
+	return items
+}
+
+// Insert snapshot items, dropping those whose retention has passed, and
+// return how many were restored
+func (c *lruCache) restore(items []snapshotItem) int {
+	restored := 0
+	for _, item := range items {
+		retain := time.Until(item.Expires)
+		if retain <= 0 {
+			continue
+		}
+		c.Set(item.Key, item.Entry.entry(), retain)
+		restored++
+	}
+	return restored
+}
+
+// Write the cache to the snapshot file. Writing a new file and renaming it
+// keeps the previous snapshot intact if saving fails halfway.
+func (s *Server) saveSnapshot() {
+	lru, ok := s.cache.(*lruCache)
+	if !ok || s.cfg.CacheSnapshot == "" {
+		return
+	}
+
+	items := lru.snapshot()
+	if err := writeSnapshot(s.cfg.CacheSnapshot, items); err != nil {
+		log.Printf("Saving the cache snapshot failed: %v", err)
+		return
+	}
+	log.Printf("Saved %d cache entries to %s", len(items), s.cfg.CacheSnapshot)
+}
+
+func writeSnapshot(path string, items []snapshotItem) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	zw := gzip.NewWriter(tmp)
+	enc := gob.NewEncoder(zw)
+	if err := enc.Encode(snapshotHeader{Version: snapshotVersion, Schema: cacheSchemaVersion, Saved: time.Now()}); err != nil {
+		return err
+	}
+	if err := enc.Encode(items); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Fill the cache from the snapshot file, if there is one. A missing,
+// corrupt or outdated snapshot only costs the warm cache.
+func (s *Server) loadSnapshot() {
+	lru, ok := s.cache.(*lruCache)
+	if !ok || s.cfg.CacheSnapshot == "" {
+		return
+	}
+
+	items, header, err := readSnapshot(s.cfg.CacheSnapshot)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		log.Printf("Ignoring cache snapshot %s: %v", s.cfg.CacheSnapshot, err)
+		return
+	}
+	// Entries of another schema may be shaped differently
+	if header.Schema != cacheSchemaVersion {
+		log.Printf("Discarded %d cache entries from %s: cache schema version %d, expected %d",
+			len(items), s.cfg.CacheSnapshot, header.Schema, cacheSchemaVersion)
+		return
+	}
+	restored := lru.restore(items)
+	log.Printf("Restored %d of %d cache entries from %s, saved %s ago",
+		restored, len(items), s.cfg.CacheSnapshot, time.Since(header.Saved).Round(time.Second))
+}
+
+func readSnapshot(path string) (items []snapshotItem, header snapshotHeader, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, header, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, header, err
+	}
+	dec := gob.NewDecoder(zr)
+	if err := dec.Decode(&header); err != nil {
+		return nil, header, err
+	}
+	if header.Version != snapshotVersion {
+		return nil, header, fmt.Errorf("version %d, expected %d", header.Version, snapshotVersion)
+	}
+	if err := dec.Decode(&items); err != nil {
+		return nil, header, err
+	}
+	return items, header, nil
+}
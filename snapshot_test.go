@@ -0,0 +1,104 @@
+package ksk
+
+import (
+	"compress/gzip"
+	"encoding/gob"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+	configure := func(cfg *Config) { cfg.CacheSnapshot = path }
+
+	saved := newTestServer(t, upstream.URL, configure)
+	h := saved.Handler()
+	before := map[string]http.Header{}
+	for _, target := range []string{"/api/v1/genres", "/api/v1/event/1"} {
+		w := serve(h, http.MethodGet, target, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, want 200", target, w.Code)
+		}
+		before[target] = w.Header()
+	}
+	// Past its retention, so not restored
+	saved.cache.Set("gone", cacheEntry{data: []byte("{}")}, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	saved.saveSnapshot()
+
+	restored := newTestServer(t, upstream.URL, configure)
+	restored.loadSnapshot()
+	h = restored.Handler()
+	for target, header := range before {
+		w := serve(h, http.MethodGet, target, nil)
+		if w.Code != http.StatusOK || w.Header().Get("X-Cache") != "HIT" {
+			t.Errorf("%s: status %d, X-Cache %q, want 200 and HIT", target, w.Code, w.Header().Get("X-Cache"))
+		}
+		for _, key := range []string{"ETag", "Content-Length", "X-Cache-Expires"} {
+			if got, want := w.Header().Get(key), header.Get(key); got != want {
+				t.Errorf("%s: %s %q after restoring, was %q", target, key, got, want)
+			}
+		}
+	}
+	if _, ok := restored.cache.Get("gone"); ok {
+		t.Error("entry past its retention restored")
+	}
+	if n := upstream.count("/genres") + upstream.count("/event/1"); n != 2 {
+		t.Errorf("upstream got %d requests, want 2", n)
+	}
+}
+
+func TestSnapshotIgnored(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	dir := t.TempDir()
+
+	corrupt := filepath.Join(dir, "corrupt.snapshot")
+	if err := os.WriteFile(corrupt, []byte("not a snapshot"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// Saved before the cache schema was recorded
+	old := filepath.Join(dir, "old.snapshot")
+	item := snapshotItem{Key: upstream.URL + genresPath, Entry: newStoredEntry(cacheEntry{data: []byte("[]")}), Expires: time.Now().Add(time.Hour)}
+	if err := writeSnapshot(old, []snapshotItem{item}); err != nil {
+		t.Fatal(err)
+	}
+	rewriteSnapshotSchema(t, old, 0)
+
+	for _, path := range []string{corrupt, old, filepath.Join(dir, "missing.snapshot")} {
+		s := newTestServer(t, upstream.URL, func(cfg *Config) { cfg.CacheSnapshot = path })
+		s.loadSnapshot()
+		if n := s.cache.Stats().Entries; n != 0 {
+			t.Errorf("%s: %d entries restored", filepath.Base(path), n)
+		}
+	}
+}
+
+// Rewrite a snapshot file as if saved by a gateway with another cache
+// schema version
+func rewriteSnapshotSchema(t *testing.T, path string, schema int) {
+	t.Helper()
+	items, _, err := readSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := gzip.NewWriter(f)
+	enc := gob.NewEncoder(zw)
+	if err := enc.Encode(snapshotHeader{Version: snapshotVersion, Schema: schema, Saved: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Encode(items); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
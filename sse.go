@@ -0,0 +1,193 @@
+package ksk
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Interval of the keep-alive comments on idle event streams, short enough
+// for proxies that drop connections silent for 30 seconds or more. Each
+// one also rechecks the list, which may have been refreshed by another
+// replica sharing the cache.
+const sseKeepAlive = 15 * time.Second
+
+// Subscribers of /events/stream, woken whenever the events list changes
+type streamHub struct {
+	mu      sync.Mutex
+	limit   int
+	clients map[chan struct{}]struct{}
+	closed  bool
+}
+
+func newStreamHub(limit int) *streamHub {
+	return &streamHub{limit: limit, clients: map[chan struct{}]struct{}{}}
+}
+
+// Channel signalled on changes and closed at shutdown, false if the limit
+// of concurrent streams is reached or the gateway is shutting down
+func (h *streamHub) subscribe() (chan struct{}, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed || len(h.clients) >= h.limit {
+		return nil, false
+	}
+	ch := make(chan struct{}, 1)
+	h.clients[ch] = struct{}{}
+	return ch, true
+}
+
+func (h *streamHub) unsubscribe(ch chan struct{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients, ch)
+}
+
+// Wake every stream; one that has not caught up with the last change yet
+// needs no second signal
+func (h *streamHub) notify() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.clients {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// End all streams, so they do not hold up shutdown until its deadline
+func (h *streamHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for ch := range h.clients {
+		close(ch)
+		delete(h.clients, ch)
+	}
+}
+
+func (h *streamHub) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients)
+}
+
+// Handle /events/stream: Server-Sent Events carrying the whole events
+// list on connect and again whenever it changes. Event IDs are the list's
+// hash, so a client reconnecting with Last-Event-ID gets the list only if
+// it changed in between.
+func (s *Server) eventStreamHandler(policy cachePolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isRead(r) {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		updates, ok := s.streams.subscribe()
+		if !ok {
+			w.Header().Set("Retry-After", "5")
+			writeError(w, r, http.StatusServiceUnavailable, "too_many_streams", "Too many open event streams")
+			return
+		}
+		defer s.streams.unsubscribe(updates)
+
+		upstream := s.localize(r, s.cfg.UpstreamURL+eventsPath)
+		entry, status, ok := s.resolveOrFail(w, r, upstream, policy, nil)
+		if !ok {
+			return
+		}
+		// Only the initial list is bound by the route's deadline
+		if !routeDeadlineFrom(r.Context()).hold() {
+			s.writeCanceled(w, r)
+			return
+		}
+		rc := http.NewResponseController(w)
+		rc.SetWriteDeadline(time.Time{})
+
+		h := w.Header()
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Cache-Control", "no-store")
+		// Keep nginx from buffering the stream
+		h.Set("X-Accel-Buffering", "no")
+		s.setCacheStatus(w, status)
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodHead {
+			return
+		}
+
+		last := r.Header.Get("Last-Event-ID")
+		send := func(entry cacheEntry) (bool, error) {
+			id := strings.Trim(entry.etag, `"`)
+			if id == last {
+				return false, nil
+			}
+			if _, err := w.Write(sseEvent("events", id, entry.data)); err != nil {
+				return false, err
+			}
+			last = id
+			return true, rc.Flush()
+		}
+		// The latest list, if resolving it still works
+		refresh := func() (bool, error) {
+			entry, _, _, err := s.resolve(r.Context(), upstream, policy, nil)
+			if err != nil {
+				return false, nil
+			}
+			return send(entry)
+		}
+
+		if _, err := send(entry); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		keepAlive := time.NewTicker(sseKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case _, open := <-updates:
+				if !open {
+					return
+				}
+				if _, err := refresh(); err != nil {
+					return
+				}
+			case <-keepAlive.C:
+				sent, err := refresh()
+				if err != nil {
+					return
+				}
+				if sent {
+					continue
+				}
+				if _, err := w.Write([]byte(": keep-alive\n\n")); err != nil {
+					return
+				}
+				if err := rc.Flush(); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Encode a Server-Sent Event, one data line per line of data
+func sseEvent(event, id string, data []byte) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "id: %s\nevent: %s\n", id, event)
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		b.WriteString("data: ")
+		b.Write(bytes.TrimSuffix(line, []byte("\r")))
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	return b.Bytes()
+}
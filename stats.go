@@ -0,0 +1,245 @@
+package ksk
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Stats bucket of the route table's endpoints
+const routesStatsName = "routes"
+
+// Counters of one endpoint, updated atomically on the request path
+type endpointCounters struct {
+	requests       atomic.Uint64
+	hits           atomic.Uint64
+	misses         atomic.Uint64
+	coalesced      atomic.Uint64
+	stale          atomic.Uint64
+	fallbacks      atomic.Uint64
+	upstreamErrors atomic.Uint64
+	fetches        atomic.Uint64
+	fetchNanos     atomic.Uint64
+	// Upstream errors by class, with every class of upstreamErrorClasses
+	errorClasses map[string]*atomic.Uint64
+}
+
+func newEndpointCounters() *endpointCounters {
+	c := &endpointCounters{errorClasses: map[string]*atomic.Uint64{}}
+	for _, class := range upstreamErrorClasses {
+		c.errorClasses[class] = new(atomic.Uint64)
+	}
+	return c
+}
+
+// Request and cache statistics for GET /admin/stats, a lightweight
+// alternative to the Prometheus metrics. They count from startup or the
+// last reset.
+type requestStats struct {
+	// Fixed when built, so lookups need no lock
+	endpoints map[string]*endpointCounters
+	// Unix nanoseconds of startup or the last reset
+	since atomic.Int64
+}
+
+func newRequestStats() *requestStats {
+	st := &requestStats{endpoints: map[string]*endpointCounters{}}
+	for _, endpoint := range upstreamEndpoints {
+		st.endpoints[endpoint] = newEndpointCounters()
+	}
+	st.endpoints[routesStatsName] = newEndpointCounters()
+	st.since.Store(time.Now().UnixNano())
+	return st
+}
+
+func (st *requestStats) counters(policy cachePolicy) *endpointCounters {
+	if c, ok := st.endpoints[policy.endpoint]; ok {
+		return c
+	}
+	return st.endpoints[routesStatsName]
+}
+
+// Count a request resolved with the given X-Cache status
+func (st *requestStats) record(policy cachePolicy, status string) {
+	c := st.counters(policy)
+	c.requests.Add(1)
+	switch status {
+	case "HIT":
+		c.hits.Add(1)
+	case "MISS":
+		c.misses.Add(1)
+	case "COALESCED":
+		c.coalesced.Add(1)
+	case "STALE":
+		c.stale.Add(1)
+	}
+}
+
+// Count an upstream fetch that took d
+func (st *requestStats) observeFetch(policy cachePolicy, d time.Duration, err error) {
+	c := st.counters(policy)
+	c.fetches.Add(1)
+	c.fetchNanos.Add(uint64(d))
+	if err != nil && !errors.Is(err, errUpstreamNotFound) {
+		c.upstreamErrors.Add(1)
+		c.errorClasses[upstreamErrorClass(err)].Add(1)
+	}
+}
+
+// Statistics of one endpoint or all of them
+type statsCounts struct {
+	Requests       uint64 `json:"requests"`
+	Hits           uint64 `json:"hits"`
+	Misses         uint64 `json:"misses"`
+	Coalesced      uint64 `json:"coalesced"`
+	Stale          uint64 `json:"stale"`
+	Fallbacks      uint64 `json:"fallbacks"`
+	UpstreamErrors uint64 `json:"upstream_errors"`
+	// Upstream errors by class, such as timeout or dns; classes that did
+	// not occur are left out
+	UpstreamErrorClasses map[string]uint64 `json:"upstream_error_classes"`
+	// Upstream fetches, counting each retry, and their average duration
+	UpstreamFetches    uint64  `json:"upstream_fetches"`
+	AvgUpstreamLatency float64 `json:"avg_upstream_latency_ms"`
+	Entries            int     `json:"entries"`
+	Bytes              int64   `json:"bytes"`
+	upstreamFetchNanos uint64
+}
+
+func (c *statsCounts) add(o statsCounts) {
+	c.Requests += o.Requests
+	c.Hits += o.Hits
+	c.Misses += o.Misses
+	c.Coalesced += o.Coalesced
+	c.Stale += o.Stale
+	c.Fallbacks += o.Fallbacks
+	c.UpstreamErrors += o.UpstreamErrors
+	for class, n := range o.UpstreamErrorClasses {
+		c.UpstreamErrorClasses[class] += n
+	}
+	c.UpstreamFetches += o.UpstreamFetches
+	c.upstreamFetchNanos += o.upstreamFetchNanos
+}
+
+func (c *statsCounts) average() {
+	if c.UpstreamFetches > 0 {
+		c.AvgUpstreamLatency = float64(c.upstreamFetchNanos) / float64(c.UpstreamFetches) / float64(time.Millisecond)
+	}
+}
+
+// Response of GET /admin/stats
+type statsReport struct {
+	Since     time.Time              `json:"since"`
+	Total     statsCounts            `json:"total"`
+	Endpoints map[string]statsCounts `json:"endpoints"`
+	// Upstream requests in flight now and the configured limit, 0 for none
+	UpstreamInFlight    int64 `json:"upstream_in_flight"`
+	UpstreamConcurrency int   `json:"upstream_concurrency"`
+	// Upstream fetch latency per endpoint over the latency window
+	UpstreamLatency map[string]latencyStats `json:"upstream_latency"`
+}
+
+// Read the counters, zeroing them if reset is set
+func (st *requestStats) read(reset bool) statsReport {
+	load := func(v *atomic.Uint64) uint64 {
+		if reset {
+			return v.Swap(0)
+		}
+		return v.Load()
+	}
+	since := st.since.Load()
+	if reset {
+		since = st.since.Swap(time.Now().UnixNano())
+	}
+
+	report := statsReport{Since: time.Unix(0, since).UTC(), Endpoints: map[string]statsCounts{}}
+	report.Total.UpstreamErrorClasses = map[string]uint64{}
+	for name, c := range st.endpoints {
+		counts := statsCounts{
+			Requests:             load(&c.requests),
+			Hits:                 load(&c.hits),
+			Misses:               load(&c.misses),
+			Coalesced:            load(&c.coalesced),
+			Stale:                load(&c.stale),
+			Fallbacks:            load(&c.fallbacks),
+			UpstreamErrors:       load(&c.upstreamErrors),
+			UpstreamErrorClasses: map[string]uint64{},
+			UpstreamFetches:      load(&c.fetches),
+			upstreamFetchNanos:   load(&c.fetchNanos),
+		}
+		for class, v := range c.errorClasses {
+			if n := load(v); n > 0 {
+				counts.UpstreamErrorClasses[class] = n
+			}
+		}
+		report.Total.add(counts)
+		counts.average()
+		report.Endpoints[name] = counts
+	}
+	report.Total.average()
+	return report
+}
+
+// Add the cache's entries and bytes to a report, per endpoint by the
+// upstream path of their keys
+func (s *Server) addCacheStats(report *statsReport) {
+	for _, key := range s.cache.Keys() {
+		name := s.keyEndpoint(key.Key)
+		counts := report.Endpoints[name]
+		counts.Entries++
+		counts.Bytes += key.Size
+		report.Endpoints[name] = counts
+	}
+	cs := s.cache.Stats()
+	report.Total.Entries, report.Total.Bytes = cs.Entries, cs.Bytes
+	report.UpstreamInFlight = s.fetchLimit.inFlight.Load()
+	report.UpstreamConcurrency = s.cfg.UpstreamConcurrency
+}
+
+// Endpoint a cache key belongs to: the first segment of its upstream path,
+// which names the built-in endpoints, or the route table's bucket
+func (s *Server) keyEndpoint(key string) string {
+	rel := strings.TrimPrefix(strings.TrimPrefix(key, s.cfg.UpstreamURL), "/")
+	if u, err := url.Parse(rel); err == nil {
+		rel = u.Path
+	}
+	segment, _, _ := strings.Cut(rel, "/")
+	if slices.Contains(upstreamEndpoints, segment) {
+		return segment
+	}
+	return routesStatsName
+}
+
+// GET /admin/stats reports requests, cache results and upstream fetches
+// per endpoint since startup or the last reset
+func (s *Server) statsHandler(w http.ResponseWriter, r *http.Request) {
+	if !isRead(r) {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	report := s.stats.read(false)
+	s.addCacheStats(&report)
+	report.UpstreamLatency = s.latency.read()
+	writeJSON(w, http.StatusOK, report)
+}
+
+// POST /admin/stats/reset zeroes the counters and the latency windows and
+// returns their values before the reset, so a scraper loses no counts in
+// between
+func (s *Server) statsResetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	report := s.stats.read(true)
+	s.addCacheStats(&report)
+	report.UpstreamLatency = s.latency.read()
+	s.latency.reset()
+	writeJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,137 @@
+package ksk
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Client response that a cache-filling fetch streams a large upstream body
+// into while it reads it. The fetch runs on its own goroutine and may
+// outlive the handler, so writes stop once the handler has closed the
+// sink; the fetch then keeps reading to complete the cache entry.
+type streamSink struct {
+	w http.ResponseWriter
+	// Bodies shorter than this, by Content-Length, are buffered as usual
+	minSize int64
+	// Called with the headers set, before they are sent
+	onStart func()
+	// Deadline of the request, which no longer applies once streaming
+	deadline *routeDeadline
+
+	mu      sync.Mutex
+	closed  bool
+	started bool
+	failed  bool
+}
+
+// Report whether bodies fetched with policy are rewritten before they are
+// cached, which keeps them from being streamed
+func (s *Server) rewrites(policy cachePolicy) bool {
+	return s.stripFields != nil || s.sanitizes(policy) || s.normalizesTimes(policy)
+}
+
+// Sink for a cache miss of r, or nil if the response cannot be streamed:
+// streaming is disabled for the endpoint, r is not a GET, or the body has
+// to be rewritten or indented before it can be sent. The fetch does not
+// stream either when the shrink guard may refuse the body, as it may with
+// a previous body to compare it with.
+func (s *Server) newStreamSink(w http.ResponseWriter, r *http.Request, policy cachePolicy) *streamSink {
+	if policy.streamMin <= 0 || r.Method != http.MethodGet || s.rewrites(policy) || wantsPretty(r) || jsonpCallback(r) != "" {
+		return nil
+	}
+	return &streamSink{
+		w:        w,
+		minSize:  policy.streamMin,
+		onStart:  func() { s.setCacheStatus(w, "MISS") },
+		deadline: routeDeadlineFrom(r.Context()),
+	}
+}
+
+// Report whether a response with the given Content-Length, -1 if unknown,
+// is worth streaming
+func (k *streamSink) wants(contentLength int64) bool {
+	return k != nil && (contentLength < 0 || contentLength >= k.minSize)
+}
+
+// Send the response headers for an upstream body that expires at until,
+// with the content headers of upstream. It reports false if the handler
+// has already given up on the response or its deadline has passed;
+// otherwise the deadline stops applying.
+func (k *streamSink) start(contentLength int64, until time.Time, upstream http.Header) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.closed || !k.deadline.hold() {
+		return false
+	}
+
+	// The ETag is only known once the whole body has been read
+	h := k.w.Header()
+	h.Set("Content-Type", cmp.Or(upstream.Get("Content-Type"), "application/json"))
+	if lang := upstream.Get("Content-Language"); lang != "" {
+		h.Set("Content-Language", lang)
+	}
+	h.Set("Cache-Control", "public, max-age="+strconv.Itoa(max(int(time.Until(until).Seconds()), 0)))
+	h.Add("Vary", "Accept-Encoding")
+	if contentLength >= 0 {
+		h.Set("Content-Length", strconv.FormatInt(contentLength, 10))
+	}
+	k.onStart()
+	k.w.WriteHeader(http.StatusOK)
+	k.started = true
+	return true
+}
+
+// Write passes body bytes on to the client. It never fails, so a client
+// that went away does not interrupt reading the body for the cache.
+func (k *streamSink) Write(b []byte) (int, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.closed || k.failed {
+		return len(b), nil
+	}
+	if _, err := k.w.Write(b); err != nil {
+		k.failed = true
+	}
+	return len(b), nil
+}
+
+// Detach the sink from the response, waiting for a write in progress.
+// It reports whether the response has been started, in which case
+// nothing else may be written to it.
+func (k *streamSink) close() bool {
+	if k == nil {
+		return false
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.closed = true
+	return k.started
+}
+
+// Read the body while streaming it to sink, failing like readBody if it
+// is larger than limit bytes. The client then has a truncated body, which
+// the handler turns into an aborted response.
+func readBodyStreaming(resp *http.Response, limit int64, sink *streamSink) ([]byte, error) {
+	var buf bytes.Buffer
+	if resp.ContentLength > 0 {
+		buf.Grow(int(min(resp.ContentLength, max(limit, 0)+1)))
+	}
+
+	src := io.Reader(resp.Body)
+	if limit > 0 {
+		src = io.LimitReader(resp.Body, limit+1)
+	}
+	if _, err := io.Copy(&buf, io.TeeReader(src, sink)); err != nil {
+		return nil, err
+	}
+	if limit > 0 && int64(buf.Len()) > limit {
+		return nil, fmt.Errorf("%w: more than %d bytes", errUpstreamTooLarge, limit)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,52 @@
+package ksk
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestStreamEventsList(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	// Streams with the default EventTimes
+	s := newTestServer(t, upstream.URL, func(cfg *Config) {
+		cfg.StreamMinSize = 10
+	})
+	h := s.Handler()
+
+	// Nothing to compare with yet, so the shrink guard does not stop it.
+	// The ETag is only known once the whole body has been read.
+	cold := serve(h, http.MethodGet, "/api/v1/events", nil)
+	if cold.Code != http.StatusOK || cold.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("status %d, X-Cache %q, want 200 and MISS", cold.Code, cold.Header().Get("X-Cache"))
+	}
+	if etag := cold.Header().Get("ETag"); etag != "" {
+		t.Errorf("ETag %q, want a streamed response without one", etag)
+	}
+	if !jsonEqual(t, cold.Body.String(), testEvents) {
+		t.Errorf("streamed body %s", cold.Body)
+	}
+
+	// A refill that the shrink guard refuses is not streamed
+	upstream.bodies["/events"] = `[]`
+	expireEntry(t, s, upstream.URL+eventsPath)
+	refill := serve(h, http.MethodGet, "/api/v1/events", nil)
+	if refill.Code != http.StatusOK || refill.Header().Get("ETag") == "" {
+		t.Fatalf("status %d, ETag %q, want 200 with a buffered body", refill.Code, refill.Header().Get("ETag"))
+	}
+	if !jsonEqual(t, refill.Body.String(), testEvents) {
+		t.Errorf("body %s, want the previous list", refill.Body)
+	}
+}
+
+func TestStreamOffWithEventTimes(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestServer(t, upstream.URL, func(cfg *Config) {
+		cfg.EventTimes = eventTimesOffset
+		cfg.StreamMinSize = 10
+	}).Handler()
+
+	w := serve(h, http.MethodGet, "/api/v1/events", nil)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == "" {
+		t.Errorf("status %d, ETag %q, want 200 with a buffered body", w.Code, w.Header().Get("ETag"))
+	}
+}
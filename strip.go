@@ -0,0 +1,62 @@
+package ksk
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+// Remove the given object keys from a JSON document at any depth. Numbers
+// keep their original text; key order is not preserved.
+func stripJSONFields(data []byte, fields map[string]bool) ([]byte, error) {
+	doc, err := decodeJSONDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	return encodeJSONDocument(stripValue(doc, fields))
+}
+
+func stripValue(v any, fields map[string]bool) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, child := range v {
+			if fields[key] {
+				delete(v, key)
+				continue
+			}
+			v[key] = stripValue(child, fields)
+		}
+	case []any:
+		for i, child := range v {
+			v[i] = stripValue(child, fields)
+		}
+	}
+	return v
+}
+
+// Decode a JSON document for rewriting, keeping numbers as json.Number
+func decodeJSONDocument(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("trailing data after JSON document")
+	}
+	return doc, nil
+}
+
+// Encode a rewritten document as compact JSON
+func encodeJSONDocument(doc any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	// Keep <, > and & as sent, they are not HTML here
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
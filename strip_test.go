@@ -0,0 +1,94 @@
+package ksk
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStripJSONFields(t *testing.T) {
+	fields := map[string]bool{"editor_email": true, "internal_notes": true, "draft": true}
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"top level", `{"id": 1, "draft": true, "title": "Hamlet"}`, `{"id": 1, "title": "Hamlet"}`},
+		{"nested object", `{"id": 1, "venue": {"name": "Dom", "contact": {"phone": "123", "editor_email": "a@example.org"}}}`,
+			`{"id": 1, "venue": {"name": "Dom", "contact": {"phone": "123"}}}`},
+		{"objects in a list", `[{"id": 1, "internal_notes": "x"}, {"id": 2, "dates": [{"start": "2030-03-01", "draft": false}]}]`,
+			`[{"id": 1}, {"id": 2, "dates": [{"start": "2030-03-01"}]}]`},
+		{"removed subtree", `{"id": 1, "internal_notes": {"editor_email": "a@example.org", "text": "x"}}`, `{"id": 1}`},
+		{"field name as value", `{"id": 1, "tags": ["draft", "editor_email"]}`, `{"id": 1, "tags": ["draft", "editor_email"]}`},
+		{"scalar", `"draft"`, `"draft"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := stripJSONFields([]byte(tt.in), fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !jsonEqual(t, string(out), tt.want) {
+				t.Errorf("got %s, want %s", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripJSONFieldsKeepsNumberText(t *testing.T) {
+	out, err := stripJSONFields([]byte(`{"id": 12345678901234567890, "price": 1.50, "draft": 1}`), map[string]bool{"draft": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, number := range []string{"12345678901234567890", "1.50"} {
+		if !strings.Contains(string(out), number) {
+			t.Errorf("%s rewritten in %s", number, out)
+		}
+	}
+}
+
+func TestStripJSONFieldsInvalid(t *testing.T) {
+	for _, in := range []string{``, `{"id": 1`, `{"id": 1} {"id": 2}`} {
+		if out, err := stripJSONFields([]byte(in), map[string]bool{"id": true}); err == nil {
+			t.Errorf("%q: no error, got %s", in, out)
+		}
+	}
+}
+
+func TestStripFieldsServed(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	upstream.bodies["/event/1"] = `{"id": 1, "title": "Hamlet", "internal_notes": "x",
+		"venue": {"id": 10, "name": "Theater", "contact": {"phone": "123", "editor_email": "a@example.org"}}}`
+	h := newTestServer(t, upstream.URL, func(cfg *Config) {
+		cfg.StripFields = []string{"internal_notes", "editor_email"}
+	}).Handler()
+
+	for _, status := range []string{"MISS", "HIT"} {
+		w := serve(h, http.MethodGet, "/api/v1/event/1", nil)
+		if w.Code != http.StatusOK || w.Header().Get("X-Cache") != status {
+			t.Fatalf("status %d, X-Cache %q, want 200 and %s", w.Code, w.Header().Get("X-Cache"), status)
+		}
+		body := w.Body.String()
+		if strings.Contains(body, "internal_notes") || strings.Contains(body, "editor_email") {
+			t.Errorf("%s: stripped field served in %s", status, body)
+		}
+		if !strings.Contains(body, `"phone":"123"`) {
+			t.Errorf("%s: nested field lost in %s", status, body)
+		}
+	}
+}
+
+// Report whether two JSON documents are equal, ignoring key order
+func jsonEqual(t *testing.T, a, b string) bool {
+	t.Helper()
+	var va, vb any
+	if err := json.Unmarshal([]byte(a), &va); err != nil {
+		t.Fatalf("%s: %v", a, err)
+	}
+	if err := json.Unmarshal([]byte(b), &vb); err != nil {
+		t.Fatalf("%s: %v", b, err)
+	}
+	return reflect.DeepEqual(va, vb)
+}
@@ -0,0 +1,123 @@
+package ksk
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// How long a summary stays fresh; its upcoming counts age with the clock
+const summaryTTL = time.Minute
+
+// Response of /events/summary
+type eventsSummary struct {
+	Total    int `json:"total"`
+	Upcoming int `json:"upcoming"`
+	// Genres of the upcoming events with how many each has, most first
+	Genres []genreCount `json:"genres"`
+	// Start of the next upcoming event, null if there is none
+	NextStart *time.Time `json:"next_start"`
+}
+
+type genreCount struct {
+	ID string `json:"id"`
+	// Empty if the genres list could not be fetched
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// Handle /events/summary, the counts a homepage teaser needs instead of
+// the whole list. It is built from the cached events and genres lists,
+// fetching either if it is cold, and cached until one of them changes or
+// summaryTTL passes.
+func (s *Server) summaryHandler(eventsPolicy, genresPolicy cachePolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isRead(r) {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		upstream := s.localize(r, s.cfg.UpstreamURL+eventsPath)
+		source, status, ok := s.resolveOrFail(w, r, upstream, eventsPolicy, nil)
+		if !ok {
+			return
+		}
+
+		// Genre names are nice to have; without them the counts still help
+		names := map[string]string{}
+		genres, _, _, err := s.resolve(r.Context(), s.localize(r, s.cfg.UpstreamURL+genresPath), genresPolicy, nil)
+		if err == nil {
+			if names, err = decodeGenreNames(genres.data); err != nil {
+				log.Printf("Decoding the genres list failed: %v [request %s]", err, requestIDFrom(r.Context()))
+				genres.etag = ""
+			}
+		} else {
+			genres.etag = ""
+		}
+
+		// The key takes the genres list's version along with the events list's
+		view := derivedView{name: "summary+" + genres.etag, ttl: summaryTTL, build: func(source cacheEntry) ([]byte, string, error) {
+			events, err := decodeEvents(source.data)
+			if err != nil {
+				return nil, "", err
+			}
+			body, err := json.Marshal(summarizeEvents(events, names, time.Now()))
+			return body, "application/json", err
+		}}
+		entry, err := s.derived(upstream, view, source)
+		if err != nil {
+			log.Printf("Building the events summary failed: %v [request %s]", err, requestIDFrom(r.Context()))
+			writeError(w, r, http.StatusBadGateway, "processing_failed", "Failed to process upstream response")
+			return
+		}
+		s.writeResolved(w, r, entry, status)
+	}
+}
+
+// Count events, upcoming ones and those per genre as of now
+func summarizeEvents(events []event, names map[string]string, now time.Time) eventsSummary {
+	summary := eventsSummary{Total: len(events), Genres: []genreCount{}}
+	counts := map[string]int{}
+	for _, ev := range events {
+		if ev.Start.IsZero() || !ev.Start.After(now) {
+			continue
+		}
+		summary.Upcoming++
+		for _, id := range ev.Genres {
+			counts[id]++
+		}
+		if summary.NextStart == nil || ev.Start.Before(*summary.NextStart) {
+			start := ev.Start
+			summary.NextStart = &start
+		}
+	}
+
+	for id, n := range counts {
+		summary.Genres = append(summary.Genres, genreCount{ID: id, Name: names[id], Count: n})
+	}
+	slices.SortFunc(summary.Genres, func(a, b genreCount) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.ID, b.ID))
+	})
+	return summary
+}
+
+// Names of the genres in the upstream genres list by ID
+func decodeGenreNames(data []byte) (map[string]string, error) {
+	var genres []struct {
+		ID    flexString `json:"id"`
+		Name  string     `json:"name"`
+		Title string     `json:"title"`
+	}
+	if err := json.Unmarshal(data, &genres); err != nil {
+		return nil, fmt.Errorf("decode genres: %w", err)
+	}
+	names := make(map[string]string, len(genres))
+	for _, g := range genres {
+		names[string(g.ID)] = cmp.Or(g.Name, g.Title)
+	}
+	return names, nil
+}
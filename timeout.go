@@ -0,0 +1,82 @@
+package ksk
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Cause of a request context cancelled by its route's deadline
+var errRouteTimeout = errors.New("route deadline exceeded")
+
+type routeDeadlineKey struct{}
+
+// Deadline of one request. It cancels the request context when it
+// expires, unless a response body has started streaming by then.
+type routeDeadline struct {
+	mu      sync.Mutex
+	expired bool
+	held    bool
+	cancel  context.CancelCauseFunc
+}
+
+func (d *routeDeadline) expire() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.held {
+		d.expired = true
+		d.cancel(errRouteTimeout)
+	}
+}
+
+// Exempt the rest of the request from the deadline, reporting false if it
+// has already expired. Safe to call on nil.
+func (d *routeDeadline) hold() bool {
+	if d == nil {
+		return true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.expired {
+		return false
+	}
+	d.held = true
+	return true
+}
+
+func routeDeadlineFrom(ctx context.Context) *routeDeadline {
+	d, _ := ctx.Value(routeDeadlineKey{}).(*routeDeadline)
+	return d
+}
+
+// Give requests to next at most timeout to resolve their upstream data,
+// answering them with a 504 beyond that; 0 disables the deadline
+func withTimeout(timeout time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	if timeout <= 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithCancelCause(r.Context())
+		defer cancel(nil)
+
+		d := &routeDeadline{cancel: cancel}
+		timer := time.AfterFunc(timeout, d.expire)
+		defer timer.Stop()
+
+		next(w, r.WithContext(context.WithValue(ctx, routeDeadlineKey{}, d)))
+	}
+}
+
+// Answer a request whose context ended before its data was resolved: a 504
+// if its route's deadline passed, otherwise the client went away
+func (s *Server) writeCanceled(w http.ResponseWriter, r *http.Request) {
+	if !errors.Is(context.Cause(r.Context()), errRouteTimeout) {
+		// Nobody is left to read the response; the fetch fills the cache
+		w.WriteHeader(statusClientClosedRequest)
+		return
+	}
+	s.metrics.timeouts.Inc()
+	writeError(w, r, http.StatusGatewayTimeout, "timeout", "Upstream did not respond in time")
+}
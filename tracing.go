@@ -0,0 +1,150 @@
+package ksk
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// Name of the gateway's tracer and service in exported spans
+	tracerName = "go-ksk"
+	// How long shutdown waits for the last spans to be exported
+	tracingFlushTimeout = 5 * time.Second
+)
+
+// OpenTelemetry tracing of requests and upstream fetches, exported over
+// OTLP/HTTP. Its methods do nothing on nil, which stands for tracing
+// being off, so untraced requests pay no more than a nil check.
+type tracing struct {
+	provider   *sdktrace.TracerProvider
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// Tracing to the configured OTLP endpoint, nil if none is configured
+func newTracing(cfg Config) (*tracing, error) {
+	if cfg.TracingEndpoint == "" {
+		return nil, nil
+	}
+	endpoint := cfg.TracingEndpoint
+	if u, err := url.Parse(endpoint); err == nil && strings.Trim(u.Path, "/") == "" {
+		endpoint = strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	}
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("tracing exporter: %w", err)
+	}
+
+	// Callers that sampled a request get its spans whatever the ratio
+	sampler := sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TracingSampleRatio))
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sampler),
+		sdktrace.WithResource(resource.NewSchemaless(
+			semconv.ServiceName(tracerName),
+			semconv.ServiceVersion(currentBuild().Version),
+		)),
+	)
+	return &tracing{
+		provider:   provider,
+		tracer:     provider.Tracer(tracerName),
+		propagator: propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}),
+	}, nil
+}
+
+// Export the remaining spans, waiting at most until ctx is done
+func (t *tracing) shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	return t.provider.Shutdown(ctx)
+}
+
+// Start a server span per request, continuing the trace of an incoming
+// traceparent header. It is named after the mux pattern the request
+// matched and records its status, size and cache result.
+func withTracing(t *tracing, mux *http.ServeMux, next http.Handler) http.Handler {
+	if t == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := t.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := t.tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+				attribute.String("ksk.request_id", requestIDFrom(r.Context())),
+			))
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		if _, route := mux.Handler(r); route != "" {
+			span.SetName(r.Method + " " + route)
+			span.SetAttributes(semconv.HTTPRoute(route))
+		}
+		span.SetAttributes(
+			semconv.HTTPResponseStatusCode(rec.code()),
+			semconv.HTTPResponseBodySize(int(rec.bytes)),
+		)
+		if cache := rec.Header().Get("X-Cache"); cache != "" {
+			span.SetAttributes(attribute.String("ksk.cache", cache))
+		}
+		if rec.code() >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.code()))
+		}
+	})
+}
+
+// Start a client span for one upstream request, a child of the request
+// that caused it. Without tracing it is the context's non-recording span.
+func (t *tracing) startUpstream(ctx context.Context, target string) (context.Context, trace.Span) {
+	if t == nil {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	attrs := []attribute.KeyValue{semconv.HTTPRequestMethodKey.String(http.MethodGet), semconv.URLFull(target)}
+	if u, err := url.Parse(target); err == nil {
+		attrs = append(attrs, semconv.ServerAddress(u.Hostname()))
+	}
+	return t.tracer.Start(ctx, http.MethodGet, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// Send the trace context of ctx to the upstream in h
+func (t *tracing) inject(ctx context.Context, h http.Header) {
+	if t == nil {
+		return
+	}
+	t.propagator.Inject(ctx, propagation.HeaderCarrier(h))
+}
+
+// Record the outcome of an upstream request and end its span
+func endUpstreamSpan(span trace.Span, status, size int, err error) {
+	if !span.IsRecording() {
+		return
+	}
+	if status != 0 {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetAttributes(semconv.HTTPResponseBodySize(size))
+	}
+	span.End()
+}
@@ -0,0 +1,470 @@
+package ksk
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"mime"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// How long upstream 404s are remembered for endpoints that pass them through
+	notFoundTTL = time.Minute
+
+	// All attempts of one fetch must finish within the server's 15s WriteTimeout
+	upstreamBudget    = 12 * time.Second
+	retryBaseInterval = 200 * time.Millisecond
+
+	// Leading bytes of a rejected upstream body that are logged
+	rejectedBodyLogSize = 200
+)
+
+var (
+	errUpstreamUnavailable = errors.New("Upstream unavailable")
+	errUpstreamTimeout     = errors.New("Upstream timed out")
+	errUpstreamStatus      = errors.New("Upstream error")
+	errUpstreamRead        = errors.New("Failed to read upstream response")
+	errUpstreamNotFound    = errors.New("Not found")
+	errUpstreamInvalid     = errors.New("Invalid upstream response")
+	errUpstreamTooLarge    = errors.New("Upstream response too large")
+
+	errCircuitOpen = fmt.Errorf("%w: circuit breaker open", errUpstreamUnavailable)
+)
+
+// Unexpected upstream status; matches errUpstreamStatus with errors.Is
+type upstreamStatusError struct {
+	status int
+}
+
+func (e *upstreamStatusError) Error() string {
+	return errUpstreamStatus.Error() + ": status " + strconv.Itoa(e.status)
+}
+
+func (e *upstreamStatusError) Is(target error) bool {
+	return target == errUpstreamStatus
+}
+
+// HTTP client for the upstream. It ignores expired/invalid SSL certificates
+// and goes through the proxy in HTTPS_PROXY/HTTP_PROXY unless NO_PROXY
+// exempts the upstream.
+func newUpstreamClient(cfg Config) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			TLSClientConfig:       &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2:     cfg.UpstreamHTTP2,
+			MaxIdleConnsPerHost:   cfg.UpstreamIdleConns,
+			IdleConnTimeout:       cfg.UpstreamIdleTimeout,
+			TLSHandshakeTimeout:   cfg.UpstreamTLSTimeout,
+			ResponseHeaderTimeout: cfg.UpstreamHeaderTimeout,
+			ExpectContinueTimeout: time.Second,
+			// fetchOnce asks for gzip and decompresses itself
+			DisableCompression: true,
+		},
+	}
+}
+
+// Outcome of fetchUpstream, shared by coalesced callers
+type fetchResult struct {
+	entry    cacheEntry
+	attempts int
+}
+
+// Fetch the upstream URL and store a successful response in the cache,
+// retrying transient failures with exponential backoff and jitter.
+// With policy.notFound, a missing resource is cached briefly as well and
+// reported as errUpstreamNotFound. The fetch keeps ctx's values, such as
+// the request ID, but not its cancellation, so it completes for the cache
+// even if the caller goes away. A large body is streamed to sink, if not
+// nil, while it is read.
+func (s *Server) fetchUpstream(ctx context.Context, upstream string, policy cachePolicy, sink *streamSink) (res fetchResult, err error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), upstreamBudget)
+	defer cancel()
+	logSuffix := ""
+	if id := requestIDFrom(ctx); id != "" {
+		logSuffix = " [request " + id + "]"
+	}
+
+	for {
+		// Wait for a free upstream request slot. Taken before asking the
+		// breaker, as a half-open breaker's probe must end in record.
+		if busy := s.fetchLimit.acquire(ctx); busy != nil {
+			if err == nil {
+				err = busy
+			}
+			break
+		}
+
+		// Fail fast while the upstream is known to be down
+		if !s.breaker.allow() {
+			s.fetchLimit.release()
+			if err == nil {
+				err = errCircuitOpen
+			}
+			break
+		}
+		res.attempts++
+		res.entry, err = s.fetchFailover(ctx, upstream, policy, sink)
+		s.fetchLimit.release()
+		// Only failures that indicate an unhealthy upstream trip the breaker
+		s.breaker.record(!upstreamUnhealthy(err))
+		if err == nil || res.attempts > s.cfg.UpstreamRetries || !retryable(err) {
+			break
+		}
+
+		delay := retryDelay(res.attempts)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			break
+		}
+		log.Printf("Upstream %s attempt %d failed: %v (retrying in %s)%s", upstream, res.attempts, err, delay.Round(time.Millisecond), logSuffix)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return res, err
+		case <-timer.C:
+		}
+	}
+
+	if err != nil && !errors.Is(err, errUpstreamNotFound) && err != errCircuitOpen && err != errUpstreamBusy {
+		log.Printf("Upstream %s failed after %d attempts: %v%s", upstream, res.attempts, err, logSuffix)
+	}
+	switch {
+	case err == nil:
+		s.cache.Delete(failureKey(upstream))
+	case !errors.Is(err, errUpstreamNotFound) && err != errUpstreamBusy && !upstreamAuthFailure(err) && s.cfg.FailureTTL > 0:
+		code, _ := upstreamErrorCode(err)
+		s.cache.Set(failureKey(upstream), cacheEntry{
+			failure:      code,
+			failureClass: upstreamErrorClass(err),
+			stored:       time.Now(),
+			until:        time.Now().Add(s.cfg.FailureTTL),
+		}, s.cfg.FailureTTL)
+	}
+	return res, err
+}
+
+// Cache key of the negative entry remembering a failed fetch of upstream
+func failureKey(upstream string) string {
+	return parseCacheKey(upstream).withVariant(failedVariant).String()
+}
+
+// Error of a recent failed fetch of upstream, nil if there is none
+func (s *Server) recentFailure(upstream string) error {
+	if s.cfg.FailureTTL <= 0 {
+		return nil
+	}
+	entry, ok := s.cache.Get(failureKey(upstream))
+	if !ok || entry.failure == "" || !time.Now().Before(entry.until) {
+		return nil
+	}
+	if slices.Contains(connErrorClasses, entry.failureClass) {
+		return &upstreamConnError{class: entry.failureClass}
+	}
+	return upstreamErrorFromCode(entry.failure)
+}
+
+// Connection errors, timeouts and gateway errors are worth retrying;
+// client errors would fail the same way again
+func retryable(err error) bool {
+	if errors.Is(err, errUpstreamUnavailable) {
+		return true
+	}
+	var statusErr *upstreamStatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.status {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+	}
+	return false
+}
+
+// Report whether the upstream rejected the gateway's credentials. These
+// failures are not remembered, so fixed credentials take effect at once.
+func upstreamAuthFailure(err error) bool {
+	var statusErr *upstreamStatusError
+	return errors.As(err, &statusErr) &&
+		(statusErr.status == http.StatusUnauthorized || statusErr.status == http.StatusForbidden)
+}
+
+// Connection errors and 5xx responses count against the circuit breaker
+func upstreamUnhealthy(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, errUpstreamUnavailable) {
+		return true
+	}
+	var statusErr *upstreamStatusError
+	return errors.As(err, &statusErr) && statusErr.status >= http.StatusInternalServerError
+}
+
+// Exponential backoff with up to 50% random jitter
+func retryDelay(attempt int) time.Duration {
+	d := retryBaseInterval << (attempt - 1)
+	return d + rand.N(d/2+1)
+}
+
+// Single request for upstream to the given base URL; see fetchUpstream
+func (s *Server) fetchOnce(ctx context.Context, upstream, base string, policy cachePolicy, sink *streamSink) (entry cacheEntry, err error) {
+	start := time.Now()
+	defer func() {
+		s.metrics.observeUpstream(start, err)
+		s.stats.observeFetch(policy, time.Since(start), err)
+		s.latency.observe(policy, time.Since(start))
+	}()
+
+	target := base + strings.TrimPrefix(upstream, s.cfg.UpstreamURL)
+	ctx, span := s.tracing.startUpstream(ctx, target)
+	var status int
+	defer func() { endUpstreamSpan(span, status, len(entry.data), err) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return entry, fmt.Errorf("%w: %v", errUpstreamUnavailable, err)
+	}
+
+	if id := requestIDFrom(ctx); id != "" {
+		req.Header.Set(requestIDHeader, id)
+	}
+	s.auth.apply(req)
+	s.tracing.inject(ctx, req.Header)
+	req.Header.Set("Accept-Encoding", "gzip")
+	lang := s.upstreamLanguage(upstream)
+	if lang != "" {
+		req.Header.Set("Accept-Language", lang)
+	}
+
+	// Revalidate what we already have instead of re-downloading it
+	var previous cacheEntry
+	var cached bool
+	if !policy.uncached {
+		previous, cached = s.cache.Get(upstream)
+	}
+	if cached && !previous.notFound {
+		if previous.upstreamETag != "" {
+			req.Header.Set("If-None-Match", previous.upstreamETag)
+		}
+		if previous.lastModified != "" {
+			req.Header.Set("If-Modified-Since", previous.lastModified)
+		}
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return entry, newUpstreamConnError(req.URL.Host, err)
+	}
+	defer resp.Body.Close()
+	status = resp.StatusCode
+	if resp.Header.Get("Content-Language") == "" && lang != "" {
+		resp.Header.Set("Content-Language", lang)
+	}
+
+	ttl, cacheable := s.upstreamTTL(resp.Header, s.policyTTL(policy))
+	ttl = s.jitterTTL(ttl)
+
+	if resp.StatusCode == http.StatusNotModified && cached && !previous.notFound {
+		previous.stored = time.Now()
+		previous.until = previous.stored.Add(ttl)
+		previous.source = base
+		s.keep(upstream, previous, cacheable)
+		s.lastUpstreamSuccess.Store(time.Now().UnixNano())
+		return previous, nil
+	}
+
+	if policy.notFound && (resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone) {
+		if policy.uncached {
+			return entry, errUpstreamNotFound
+		}
+		s.store(upstream, cacheEntry{
+			notFound: true,
+			stored:   time.Now(),
+			until:    time.Now().Add(notFoundTTL),
+		})
+		return entry, errUpstreamNotFound
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		log.Printf("Upstream %s rejected the gateway's credentials with status %d; check the upstream auth configuration", target, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return entry, &upstreamStatusError{status: resp.StatusCode}
+	}
+	if err := decodeUpstreamBody(resp, policy.maxBody); err != nil {
+		return entry, err
+	}
+
+	var body []byte
+	contentType := resp.Header.Get("Content-Type")
+	// A body the shrink guard may refuse must not reach the client early
+	guarded := s.guards(policy) && !policy.acceptShrink && cached && !previous.notFound
+	if !guarded && sink.wants(resp.ContentLength) && (policy.maxBody <= 0 || resp.ContentLength <= policy.maxBody) &&
+		checkJSONMediaType(contentType) == nil && sink.start(resp.ContentLength, time.Now().Add(ttl), resp.Header) {
+		body, err = readBodyStreaming(resp, policy.maxBody, sink)
+	} else {
+		body, err = readBody(resp, policy.maxBody)
+	}
+	if errors.Is(err, errUpstreamTooLarge) {
+		return entry, err
+	}
+	if err != nil {
+		return entry, fmt.Errorf("%w: %v", errUpstreamRead, err)
+	}
+
+	if err := validateJSONResponse(contentType, body); err != nil {
+		s.metrics.rejectedBodies.Inc()
+		log.Printf("Rejected upstream %s body: %v: %q", target, err, body[:min(len(body), rejectedBodyLogSize)])
+		return entry, fmt.Errorf("%w: %v", errUpstreamInvalid, err)
+	}
+
+	// Never cache or serve the raw body if it cannot be cleaned. Stripping
+	// writes compact JSON, other bodies are compacted.
+	if s.stripFields != nil {
+		if body, err = stripJSONFields(body, s.stripFields); err != nil {
+			return entry, fmt.Errorf("%w: %v", errUpstreamInvalid, err)
+		}
+	} else {
+		body = compactJSON(body)
+	}
+	if s.normalizesTimes(policy) {
+		var unparsed int
+		if body, unparsed, err = normalizeEventTimes(body, s.cfg.EventTimes == eventTimesUTC); err != nil {
+			return entry, fmt.Errorf("%w: %v", errUpstreamInvalid, err)
+		}
+		s.metrics.unparsedTimes.Add(float64(unparsed))
+	}
+	if s.sanitizes(policy) {
+		if body, err = s.sanitizer.sanitizeJSON(body); err != nil {
+			return entry, fmt.Errorf("%w: %v", errUpstreamInvalid, err)
+		}
+	}
+	if guarded {
+		if reason := s.shrinkReason(previous.data, body); reason != "" {
+			return s.refuseShrunk(upstream, previous, policy, ttl, reason), nil
+		}
+	}
+
+	entry = cacheEntry{
+		data:            body,
+		gzipped:         compressBody(body),
+		stored:          time.Now(),
+		until:           time.Now().Add(ttl),
+		etag:            computeETag(body),
+		upstreamETag:    resp.Header.Get("ETag"),
+		lastModified:    resp.Header.Get("Last-Modified"),
+		contentType:     contentType,
+		contentLanguage: resp.Header.Get("Content-Language"),
+		source:          base,
+	}
+	if policy.uncached {
+		entry.private = true
+		return entry, nil
+	}
+	s.keep(upstream, entry, cacheable)
+	s.refused.Delete(upstream)
+	if policy.notifyChanges && cached && !previous.notFound && previous.etag != entry.etag {
+		s.listChanged(upstream, previous, entry)
+	}
+	s.recordFixture(upstream, body)
+	s.lastUpstreamSuccess.Store(time.Now().UnixNano())
+
+	return entry, nil
+}
+
+// Read the whole body, failing without returning any of it if it is larger
+// than limit bytes
+func readBody(resp *http.Response, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		return io.ReadAll(resp.Body)
+	}
+	if resp.ContentLength > limit {
+		return nil, fmt.Errorf("%w: %d bytes announced, limit is %d", errUpstreamTooLarge, resp.ContentLength, limit)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, fmt.Errorf("%w: more than %d bytes", errUpstreamTooLarge, limit)
+	}
+	return body, nil
+}
+
+// Check that an upstream response is JSON, so error pages served with
+// status 200 are never cached
+func validateJSONResponse(contentType string, body []byte) error {
+	if err := checkJSONMediaType(contentType); err != nil {
+		return err
+	}
+	if !json.Valid(body) {
+		return errors.New("body is not valid JSON")
+	}
+	return nil
+}
+
+// Accept a JSON media type or none at all
+func checkJSONMediaType(contentType string) error {
+	if contentType == "" {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("invalid content type %q", contentType)
+	}
+	if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return fmt.Errorf("unexpected content type %q", mediaType)
+	}
+	return nil
+}
+
+// Tell webhooks and event streams that a fetch replaced the cached
+// previous with a different entry
+func (s *Server) listChanged(upstream string, previous, entry cacheEntry) {
+	if s.webhooks != nil {
+		s.webhooks.notify(strings.TrimPrefix(upstream, s.cfg.UpstreamURL), previous.etag, entry.etag)
+	}
+	s.streams.notify()
+}
+
+// Cache a fetched entry unless the upstream forbids it, in which case an
+// older copy must not be served either
+func (s *Server) keep(upstream string, entry cacheEntry, cacheable bool) {
+	if cacheable {
+		s.store(upstream, entry)
+		return
+	}
+	if s.cache.Delete(upstream) && upstream == s.cfg.UpstreamURL+eventsPath {
+		s.invalidateEventIndex()
+	}
+}
+
+// Cache an entry, retaining successful responses for the stale window
+// past their expiry
+func (s *Server) store(key string, entry cacheEntry) {
+	retain := time.Until(entry.until)
+	if !entry.notFound {
+		retain += s.cfg.StaleTTL
+	}
+	s.cache.Set(key, entry, retain)
+
+	if key == s.cfg.UpstreamURL+eventsPath && !entry.notFound {
+		s.indexEvents(entry)
+	}
+}
@@ -0,0 +1,210 @@
+package ksk
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Let the cached entry of an upstream URL expire, as its TTL running out
+// would
+func expireEntry(t *testing.T, s *Server, upstream string) {
+	t.Helper()
+	entry, ok := s.cache.Get(upstream)
+	if !ok {
+		t.Fatalf("%s is not cached", upstream)
+	}
+	entry.until = time.Now().Add(-time.Second)
+	s.cache.Set(upstream, entry, time.Minute)
+}
+
+func TestRevalidateNotModified(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	var mu sync.Mutex
+	var conditional []string
+	upstream.handle(func(w http.ResponseWriter, r *http.Request) {
+		if match := r.Header.Get("If-None-Match"); match != "" {
+			mu.Lock()
+			conditional = append(conditional, match)
+			mu.Unlock()
+			if match == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Sat, 01 Mar 2030 10:00:00 GMT")
+		upstream.serve(w, r)
+	})
+	s := newTestServer(t, upstream.URL, nil)
+	h := s.Handler()
+
+	first := serve(h, http.MethodGet, "/api/v1/genres", nil)
+	if first.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", first.Code)
+	}
+	before, _ := s.cache.Get(upstream.URL + genresPath)
+	expireEntry(t, s, upstream.URL+genresPath)
+
+	second := serve(h, http.MethodGet, "/api/v1/genres", nil)
+	if second.Code != http.StatusOK {
+		t.Fatalf("revalidated: status %d, want 200", second.Code)
+	}
+	if got := second.Header().Get("X-Cache"); got != "MISS" {
+		t.Errorf("revalidated: X-Cache %q, want MISS", got)
+	}
+	if second.Body.String() != first.Body.String() {
+		t.Errorf("revalidated body %q, want the cached %q", second.Body, first.Body)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(conditional) != 1 || conditional[0] != `"v1"` {
+		t.Errorf("conditional requests %q, want one for \"v1\"", conditional)
+	}
+
+	after, _ := s.cache.Get(upstream.URL + genresPath)
+	if !after.until.After(time.Now()) {
+		t.Errorf("entry still expired at %v", after.until)
+	}
+	if after.etag != before.etag || after.lastModified != before.lastModified {
+		t.Errorf("validators changed from %q/%q to %q/%q", before.etag, before.lastModified, after.etag, after.lastModified)
+	}
+	if n := upstream.count("/genres"); n != 2 {
+		t.Errorf("upstream got %d requests, want 2", n)
+	}
+}
+
+func TestRetryAfterTransientFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		fail     http.HandlerFunc
+		status   int
+		attempts string
+		requests int
+	}{
+		{"service unavailable", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}, http.StatusOK, "2", 2},
+		{"connection reset", func(w http.ResponseWriter, r *http.Request) {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+		}, http.StatusOK, "2", 2},
+		{"client error", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		}, http.StatusBadGateway, "1", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			var failed sync.Once
+			upstream.handle(func(w http.ResponseWriter, r *http.Request) {
+				first := false
+				failed.Do(func() { first = true })
+				if first {
+					tt.fail(w, r)
+					return
+				}
+				upstream.serve(w, r)
+			})
+			h := newTestServer(t, upstream.URL, nil).Handler()
+
+			w := serve(h, http.MethodGet, "/api/v1/genres", nil)
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("X-Upstream-Attempts"); got != tt.attempts {
+				t.Errorf("X-Upstream-Attempts %q, want %q", got, tt.attempts)
+			}
+			if n := upstream.count("/genres"); n != tt.requests {
+				t.Errorf("upstream got %d requests, want %d", n, tt.requests)
+			}
+		})
+	}
+}
+
+func TestFailureCache(t *testing.T) {
+	tests := []struct {
+		status   int
+		requests int
+	}{
+		{http.StatusInternalServerError, 1},
+		{http.StatusUnauthorized, 2},
+		{http.StatusForbidden, 2},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			upstream.handle(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			})
+			h := newTestServer(t, upstream.URL, nil).Handler()
+
+			for i := range 2 {
+				if w := serve(h, http.MethodGet, "/api/v1/genres", nil); w.Code != http.StatusBadGateway {
+					t.Errorf("request %d: status %d, want 502", i, w.Code)
+				}
+			}
+			if n := upstream.count("/genres"); n != tt.requests {
+				t.Errorf("upstream got %d requests, want %d", n, tt.requests)
+			}
+		})
+	}
+}
+
+func TestUpstreamConnectionReuse(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestServer(t, upstream.URL, nil).Handler()
+
+	var mu sync.Mutex
+	var conns, reused int
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			conns++
+			if info.Reused {
+				reused++
+			}
+		},
+	}
+	// Sequential misses of different URLs
+	for _, target := range []string{"/api/v1/genres", "/api/v1/locations", "/api/v1/event/1"} {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r = r.WithContext(httptrace.WithClientTrace(r.Context(), trace))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Header().Get("X-Cache") != "MISS" {
+			t.Fatalf("%s: status %d, X-Cache %q, want 200 and MISS", target, w.Code, w.Header().Get("X-Cache"))
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if conns != 3 || reused != 2 {
+		t.Errorf("%d upstream requests of which %d reused a connection, want 3 and 2", conns, reused)
+	}
+}
+
+func TestUpstreamTransportSettings(t *testing.T) {
+	cfg := testConfig("http://upstream.invalid", func(cfg *Config) {
+		cfg.UpstreamIdleConns = 7
+		cfg.UpstreamIdleTimeout = time.Minute
+		cfg.UpstreamTLSTimeout = 3 * time.Second
+		cfg.UpstreamHeaderTimeout = 4 * time.Second
+		cfg.UpstreamHTTP2 = false
+	})
+	transport, ok := newUpstreamClient(cfg).Transport.(*http.Transport)
+	if !ok {
+		t.Fatal("no *http.Transport")
+	}
+	if transport.MaxIdleConnsPerHost != 7 || transport.IdleConnTimeout != time.Minute ||
+		transport.TLSHandshakeTimeout != 3*time.Second || transport.ResponseHeaderTimeout != 4*time.Second ||
+		transport.ForceAttemptHTTP2 || transport.Proxy == nil {
+		t.Errorf("transport does not follow the configuration: %+v", transport)
+	}
+}
@@ -0,0 +1,16 @@
+package ksk
+
+import "net/http"
+
+// Answer requests whose URI is longer than limit bytes with a 414 before
+// any handler parses it
+func withURILimit(limit int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.RequestURI) > limit {
+			w.Header().Set("Connection", "close")
+			writeError(w, r, http.StatusRequestURITooLong, "uri_too_long", "Request URI too long")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,144 @@
+package ksk
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Run a gateway with ListenAndServe on a Unix socket until the test ends
+// and return the socket's path
+func startGateway(t *testing.T, upstream string, configure func(*Config)) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "gateway.sock")
+	s := newTestServer(t, upstream, func(cfg *Config) {
+		cfg.ListenAddr = "unix:" + path
+		cfg.ShutdownTimeout = time.Second
+		if configure != nil {
+			configure(cfg)
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- s.ListenAndServe(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-served
+	})
+	eventually(t, "the socket", func() bool {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	})
+	return path
+}
+
+// Send a raw request over the socket and read the response status
+func rawRequest(t *testing.T, path, request string) (int, error) {
+	t.Helper()
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte(request)); err != nil {
+		return 0, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func TestURILimit(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	h := newTestServer(t, upstream.URL, func(cfg *Config) {
+		cfg.MaxURILength = 64
+	}).Handler()
+
+	fits := "/api/v1/genres?q=" + strings.Repeat("x", 64-len("/api/v1/genres?q="))
+	tests := []struct {
+		target string
+		status int
+	}{
+		{fits, http.StatusOK},
+		{fits + "x", http.StatusRequestURITooLong},
+		{"/api/v1/event/" + strings.Repeat("1", 1<<20), http.StatusRequestURITooLong},
+		{"/api/v1/genres?" + strings.Repeat("a=1&", 1<<10), http.StatusRequestURITooLong},
+	}
+	for _, tt := range tests {
+		w := serve(h, http.MethodGet, tt.target, nil)
+		if w.Code != tt.status {
+			t.Errorf("URI of %d bytes: status %d, want %d", len(tt.target), w.Code, tt.status)
+		}
+		if tt.status == http.StatusRequestURITooLong {
+			if code := errorCode(w.Body.String()); code != "uri_too_long" {
+				t.Errorf("URI of %d bytes: error code %q", len(tt.target), code)
+			}
+			if w.Header().Get("Connection") != "close" {
+				t.Errorf("URI of %d bytes: connection kept open", len(tt.target))
+			}
+		}
+	}
+	if n := upstream.count("/event/" + strings.Repeat("1", 1<<20)); n != 0 {
+		t.Error("oversized URI reached the upstream")
+	}
+}
+
+func TestOversizedRequests(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	path := startGateway(t, upstream.URL, func(cfg *Config) {
+		cfg.MaxHeaderBytes = 4 << 10
+		cfg.ReadHeaderTimeout = 200 * time.Millisecond
+	})
+
+	tests := []struct {
+		name    string
+		request string
+		status  int
+	}{
+		{"small", "GET /api/v1/genres HTTP/1.1\r\nHost: gateway\r\n\r\n", http.StatusOK},
+		{"long URI", "GET /api/v1/genres?q=" + strings.Repeat("x", 4<<10) + " HTTP/1.1\r\nHost: gateway\r\n\r\n", http.StatusRequestURITooLong},
+		{"large header", "GET /api/v1/genres HTTP/1.1\r\nHost: gateway\r\nX-Padding: " + strings.Repeat("x", 64<<10) + "\r\n\r\n", http.StatusRequestHeaderFieldsTooLarge},
+		{"many headers", "GET /api/v1/genres HTTP/1.1\r\nHost: gateway\r\n" + strings.Repeat("X-Padding: xxxxxxxxxxxxxxxxxxxxxxxx\r\n", 2000) + "\r\n", http.StatusRequestHeaderFieldsTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, err := rawRequest(t, path, tt.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status != tt.status {
+				t.Errorf("status %d, want %d", status, tt.status)
+			}
+		})
+	}
+
+	// Headers that never end are cut off after ReadHeaderTimeout
+	t.Run("slow header", func(t *testing.T) {
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		start := time.Now()
+		fmt.Fprint(conn, "GET /api/v1/genres HTTP/1.1\r\nHost: gateway\r\n")
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		// Closed, after a 408 or without an answer
+		if _, err := io.ReadAll(conn); err != nil {
+			t.Errorf("connection still open after %s: %v", time.Since(start).Round(time.Millisecond), err)
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package ksk
+
+import (
+	"log"
+	"net/http"
+	"slices"
+)
+
+// Events of a list by venue ID, each venue's sorted by start time with
+// events without a start last
+func groupByVenue(events []event) map[string][]event {
+	byVenue := map[string][]event{}
+	for _, ev := range events {
+		if ev.VenueID != "" {
+			byVenue[ev.VenueID] = append(byVenue[ev.VenueID], ev)
+		}
+	}
+	for _, list := range byVenue {
+		slices.SortStableFunc(list, func(a, b event) int {
+			switch {
+			case a.Start.IsZero() == b.Start.IsZero():
+				return a.Start.Compare(b.Start)
+			case a.Start.IsZero():
+				return 1
+			default:
+				return -1
+			}
+		})
+	}
+	return byVenue
+}
+
+// Events of a venue in the list entry source, from the index if it was
+// built from that entry, which is only the case for the default language
+func (s *Server) venueEvents(source cacheEntry, venueID string) ([]event, error) {
+	if idx := s.index.Load(); idx != nil && idx.etag == source.etag {
+		return idx.byVenue[venueID], nil
+	}
+	events, err := decodeEvents(source.data)
+	if err != nil {
+		return nil, err
+	}
+	return groupByVenue(events)[venueID], nil
+}
+
+// Handle /venues/{id}/events, the events at one venue sorted by start time
+// for printed venue programs, optionally limited to those starting between
+// ?from= and ?to=. It is built from the cached events list; a venue with
+// no events is a 404 only if the upstream does not know it either.
+func (s *Server) venueEventsHandler(eventsPolicy, locationPolicy cachePolicy) http.HandlerFunc {
+	upstream := s.cfg.UpstreamURL + eventsPath
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isRead(r) {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		id, suffix, ok := idPath(r, "/api/v1/venues/", "events")
+		if !ok || suffix != "events" {
+			writeNotFound(w, r)
+			return
+		}
+		if !idRegex.MatchString(id) {
+			writeError(w, r, http.StatusBadRequest, "invalid_venue_id", "Invalid venue id")
+			return
+		}
+		var filter eventFilter
+		var err error
+		query := r.URL.Query()
+		if filter.from, err = dateParam(query, "from"); err == nil {
+			filter.to, err = dateParam(query, "to")
+		}
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_parameter", err.Error())
+			return
+		}
+
+		source, status, ok := s.resolveOrFail(w, r, s.localize(r, upstream), eventsPolicy, nil)
+		if !ok {
+			return
+		}
+		if s.defaultLanguage(r) {
+			if idx := s.index.Load(); idx == nil || idx.etag != source.etag {
+				s.indexEvents(source)
+			}
+		}
+		events, err := s.venueEvents(source, id)
+		if err != nil {
+			log.Printf("Building the events of venue %s failed: %v [request %s]", id, err, requestIDFrom(r.Context()))
+			writeError(w, r, http.StatusBadGateway, "processing_failed", "Failed to process upstream response")
+			return
+		}
+		if len(events) == 0 {
+			// Answers 404 itself if the venue does not exist
+			if _, _, ok := s.resolveOrFail(w, r, s.cfg.UpstreamURL+"/location/"+id, locationPolicy, nil); !ok {
+				return
+			}
+		}
+
+		var kept []event
+		for _, ev := range events {
+			if filter.match(ev) {
+				kept = append(kept, ev)
+			}
+		}
+		body := encodeEvents(kept)
+		s.writeResolved(w, r, cacheEntry{
+			data:            body,
+			gzipped:         compressBody(body),
+			stored:          source.stored,
+			until:           source.until,
+			etag:            computeETag(body),
+			contentType:     source.contentType,
+			contentLanguage: source.contentLanguage,
+		}, status)
+	}
+}
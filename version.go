@@ -0,0 +1,104 @@
+package ksk
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// Import path of the gateway, to find its version in programs that embed it
+const modulePath = "github.com/Kulturleben/go-ksk"
+
+// Set at build time with
+// -ldflags "-X github.com/Kulturleben/go-ksk.version=1.2.3 -X github.com/Kulturleben/go-ksk.commit=abc123 -X github.com/Kulturleben/go-ksk.buildTime=2024-05-01T12:00:00Z".
+// Empty values are filled from the build info Go embeds in the binary,
+// which only has the commit time.
+var (
+	version   string
+	commit    string
+	buildTime string
+)
+
+// Build of the running gateway, as served by /version
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	// Built from a checkout with uncommitted changes
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+var currentBuild = sync.OnceValue(readBuildInfo)
+
+// Build from the values set at build time, completed from the build info
+func readBuildInfo() buildInfo {
+	b := buildInfo{Version: version, Commit: commit, BuildTime: buildTime, GoVersion: runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if info.Main.Path != modulePath {
+			// Embedded in another program, whose VCS settings are its own
+			for _, dep := range info.Deps {
+				if dep.Path == modulePath && b.Version == "" {
+					b.Version = dep.Version
+				}
+			}
+			info.Settings = nil
+		}
+		if b.Version == "" && info.Main.Path == modulePath && info.Main.Version != "(devel)" {
+			b.Version = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if b.Commit == "" {
+					b.Commit = setting.Value
+				}
+			case "vcs.time":
+				if b.BuildTime == "" {
+					b.BuildTime = setting.Value
+				}
+			case "vcs.modified":
+				b.Modified = setting.Value == "true"
+			}
+		}
+	}
+	if b.Version == "" {
+		b.Version = "dev"
+	}
+	return b
+}
+
+// Short description for logs: version, commit and build time
+func (b buildInfo) String() string {
+	s := b.Version
+	if b.Commit != "" {
+		s += " (" + b.Commit[:min(len(b.Commit), 12)]
+		if b.Modified {
+			s += "+modified"
+		}
+		if b.BuildTime != "" {
+			s += ", built " + b.BuildTime
+		}
+		s += ")"
+	}
+	return s
+}
+
+// GET /version describes the running build
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	if !isRead(r) {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+	writeJSON(w, http.StatusOK, currentBuild())
+}
+
+// Name the gateway's version in an X-Gateway-Version header on every response
+func withVersionHeader(next http.Handler) http.Handler {
+	v := currentBuild().Version
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Gateway-Version", v)
+		next.ServeHTTP(w, r)
+	})
+}
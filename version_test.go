@@ -0,0 +1,58 @@
+package ksk
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// Set the build values as -ldflags -X would for the rest of the test
+func injectBuild(t *testing.T, v, c, at string) {
+	saved := []string{version, commit, buildTime}
+	savedBuild := currentBuild
+	t.Cleanup(func() {
+		version, commit, buildTime = saved[0], saved[1], saved[2]
+		currentBuild = savedBuild
+	})
+	version, commit, buildTime = v, c, at
+	currentBuild = sync.OnceValue(readBuildInfo)
+}
+
+func TestVersionEndpoint(t *testing.T) {
+	injectBuild(t, "1.2.3", "0123456789abcdef", "2030-03-01T12:00:00Z")
+	upstream := newFakeUpstream(t)
+	h := newTestServer(t, upstream.URL, nil).Handler()
+
+	w := serve(h, http.MethodGet, "/version", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", w.Code)
+	}
+	var got buildInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != "1.2.3" || got.Commit != "0123456789abcdef" || got.BuildTime != "2030-03-01T12:00:00Z" || got.GoVersion == "" {
+		t.Errorf("build %+v, want the injected values", got)
+	}
+	for _, target := range []string{"/version", "/healthz", "/api/v1/genres"} {
+		if v := serve(h, http.MethodGet, target, nil).Header().Get("X-Gateway-Version"); v != "1.2.3" {
+			t.Errorf("%s: X-Gateway-Version %q, want 1.2.3", target, v)
+		}
+	}
+	if s := got.String(); s != "1.2.3 (0123456789ab, built 2030-03-01T12:00:00Z)" {
+		t.Errorf("log description %q", s)
+	}
+
+	h = newTestServer(t, upstream.URL, func(cfg *Config) { cfg.VersionHeader = false }).Handler()
+	if v := serve(h, http.MethodGet, "/healthz", nil).Header().Get("X-Gateway-Version"); v != "" {
+		t.Errorf("X-Gateway-Version %q although disabled", v)
+	}
+}
+
+func TestVersionDefault(t *testing.T) {
+	injectBuild(t, "", "", "")
+	if b := currentBuild(); b.Version == "" {
+		t.Errorf("empty version in %+v", b)
+	}
+}
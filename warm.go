@@ -0,0 +1,41 @@
+package ksk
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Fill the cache for the given targets, waiting at most timeout.
+// Failures are logged but never fatal; fetches still running when the
+// timeout hits keep going and populate the cache in the background.
+func (s *Server) warmCache(targets []staticTarget, timeout time.Duration) {
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target staticTarget) {
+			defer wg.Done()
+			if _, _, err := s.fetches.do(context.Background(), target.upstream, func() (fetchResult, error) {
+				return s.fetchUpstream(context.Background(), target.upstream, target.policy, nil)
+			}); err != nil {
+				log.Printf("Cache warm-up of %s failed: %v", target.upstream, err)
+			}
+		}(target)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Printf("Cache warm-up finished in %s", time.Since(start).Round(time.Millisecond))
+	case <-time.After(timeout):
+		log.Printf("Cache warm-up timed out after %s, starting anyway", timeout)
+	}
+}
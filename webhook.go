@@ -0,0 +1,154 @@
+package ksk
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Header carrying the hex HMAC-SHA256 of the body, keyed with the
+	// webhook secret, as sha256=<hex>
+	webhookSignatureHeader = "X-KSK-Signature"
+	// Deliveries tried per notification and URL, and the delay before the
+	// first retry, doubled for each further one
+	webhookAttempts   = 3
+	webhookRetryDelay = time.Second
+	// Notifications waiting per URL; more are dropped
+	webhookQueueSize = 16
+)
+
+// Body POSTed to the webhook URLs when a cached list changes
+type webhookPayload struct {
+	Timestamp time.Time `json:"timestamp"`
+	// Upstream path of the changed list, such as /events?show_past=true
+	Endpoint string `json:"endpoint"`
+	// SHA-256 prefixes of the normalized bodies, the ETags served for them
+	OldHash string `json:"old_hash"`
+	NewHash string `json:"new_hash"`
+}
+
+// Tells partner sites about changes to the cached program. Each URL has
+// its own queue and worker, so a slow one delays no other and request
+// handling never waits for either.
+type webhookNotifier struct {
+	client *http.Client
+	secret []byte
+	queues map[string]chan []byte
+}
+
+// Notifier for the configured webhook URLs, nil if there are none
+func newWebhookNotifier(cfg Config) *webhookNotifier {
+	if len(cfg.WebhookURLs) == 0 {
+		return nil
+	}
+	n := &webhookNotifier{
+		client: &http.Client{Timeout: cfg.WebhookTimeout},
+		secret: []byte(cfg.WebhookSecret),
+		queues: map[string]chan []byte{},
+	}
+	for _, target := range cfg.WebhookURLs {
+		n.queues[target] = make(chan []byte, webhookQueueSize)
+	}
+	return n
+}
+
+// Deliver queued notifications until ctx is done, tracked in wg
+func (n *webhookNotifier) run(ctx context.Context, wg *sync.WaitGroup) {
+	for target, queue := range n.queues {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case body := <-queue:
+					n.deliver(ctx, target, body)
+				}
+			}
+		}()
+	}
+}
+
+// Queue a notification that the list at endpoint changed from oldETag to
+// newETag, dropping it for URLs whose queue is full
+func (n *webhookNotifier) notify(endpoint, oldETag, newETag string) {
+	body, err := json.Marshal(webhookPayload{
+		Timestamp: time.Now().UTC(),
+		Endpoint:  endpoint,
+		OldHash:   strings.Trim(oldETag, `"`),
+		NewHash:   strings.Trim(newETag, `"`),
+	})
+	if err != nil {
+		log.Printf("Encoding the webhook payload failed: %v", err)
+		return
+	}
+	for target, queue := range n.queues {
+		select {
+		case queue <- body:
+		default:
+			log.Printf("Dropped the change notification of %s for webhook %s: too many pending", endpoint, target)
+		}
+	}
+}
+
+// POST body to target, retrying with backoff until it is accepted or the
+// attempts run out
+func (n *webhookNotifier) deliver(ctx context.Context, target string, body []byte) {
+	delay := webhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := n.post(ctx, target, body)
+		if err == nil {
+			return
+		}
+		if attempt == webhookAttempts || ctx.Err() != nil {
+			log.Printf("Webhook %s failed after %d attempts: %v", target, attempt, err)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (n *webhookNotifier) post(ctx context.Context, target string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookSignatureHeader, "sha256="+n.sign(body))
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Drain a little so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Hex HMAC-SHA256 of body keyed with the secret
+func (n *webhookNotifier) sign(body []byte) string {
+	mac := hmac.New(sha256.New, n.secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}